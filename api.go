@@ -0,0 +1,611 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+// JSON API under /api/v1, described in static/openapi.json.
+// Reading doesn't need authentication. Changing translations needs a logged
+// in user (session cookie) and CSRF token in X-CSRF-Token header, the same
+// as /savetranslation. Errors are returned as {"error": $msg} with
+// appropriate http status code.
+
+const (
+	apiDefaultPerPage = 100
+	apiMaxPerPage     = 500
+	// default number of days of progress history
+	apiDefaultProgressDays = 365
+)
+
+// APIApp describes an app in /api/v1 responses
+type APIApp struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	Langs        int    `json:"langs"`
+	Strings      int    `json:"strings"`
+	Untranslated int    `json:"untranslated"`
+	Edits        int    `json:"edits"`
+}
+
+// APILang describes translation progress of a language
+type APILang struct {
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	Strings      int    `json:"strings"`
+	Translated   int    `json:"translated"`
+	Untranslated int    `json:"untranslated"`
+	// percentage of translated strings
+	Progress int `json:"progress"`
+}
+
+// APIProgressDay is translation progress of a language at the end of a day
+type APIProgressDay struct {
+	// YYYY-MM-DD, UTC
+	Date         string `json:"date"`
+	Strings      int    `json:"strings"`
+	Translated   int    `json:"translated"`
+	Untranslated int    `json:"untranslated"`
+	Progress     int    `json:"progress"`
+}
+
+// APIProgress is daily history of translation progress of a language
+type APIProgress struct {
+	Code string           `json:"code"`
+	Name string           `json:"name"`
+	Days []APIProgressDay `json:"days"`
+}
+
+// APIEdit is a single change of a translation
+type APIEdit struct {
+	Translation string    `json:"translation"`
+	User        string    `json:"user"`
+	Time        time.Time `json:"time"`
+}
+
+// APIReview describes the latest approval of a translation by a maintainer
+type APIReview struct {
+	User        string    `json:"user"`
+	Translation string    `json:"translation"`
+	Time        time.Time `json:"time"`
+	// true if translation changed after it was reviewed
+	Outdated bool `json:"outdated"`
+}
+
+// APIString is a string with its translation in a given language
+type APIString struct {
+	String      string `json:"string"`
+	Translation string `json:"translation"`
+	// edits of the translation, oldest first
+	History []APIEdit  `json:"history"`
+	Review  *APIReview `json:"review,omitempty"`
+}
+
+// APIPage is a page of a paginated list
+type APIPage struct {
+	Page    int         `json:"page"`
+	PerPage int         `json:"perPage"`
+	Total   int         `json:"total"`
+	Items   interface{} `json:"items"`
+}
+
+// APITranslationRequest is a body of POST requests that change translations
+type APITranslationRequest struct {
+	String string `json:"string"`
+	// only for submitting a translation
+	Translation string `json:"translation"`
+}
+
+func apiAppFromApp(app *App) *APIApp {
+	return &APIApp{
+		Name:         app.Name,
+		URL:          app.Url,
+		Langs:        app.store.LangsCount(),
+		Strings:      app.store.StringsCount(),
+		Untranslated: app.store.UntranslatedCount(),
+		Edits:        app.store.EditsCount(),
+	}
+}
+
+// progressPercent returns percentage of translated strings
+func progressPercent(translated, total int) int {
+	if total == 0 {
+		return 100
+	}
+	return translated * 100 / total
+}
+
+func apiLangFromLangInfo(li *store.LangInfo) *APILang {
+	total := len(li.ActiveStrings)
+	untranslated := li.UntranslatedCount()
+	return &APILang{
+		Code:         li.Code,
+		Name:         li.Name,
+		Strings:      total,
+		Translated:   total - untranslated,
+		Untranslated: untranslated,
+		Progress:     progressPercent(total-untranslated, total),
+	}
+}
+
+// apiProgress converts the last days (all if 0) of points
+func apiProgress(lang string, points []store.ProgressPoint, days int) *APIProgress {
+	if days > 0 && len(points) > days {
+		points = points[len(points)-days:]
+	}
+	res := &APIProgress{
+		Code: lang,
+		Name: store.LangNameByCode(lang),
+		Days: make([]APIProgressDay, 0, len(points)),
+	}
+	for i := range points {
+		p := &points[i]
+		res.Days = append(res.Days, APIProgressDay{
+			Date:         p.Day.Format("2006-01-02"),
+			Strings:      p.Strings,
+			Translated:   p.Translated,
+			Untranslated: p.Untranslated(),
+			Progress:     progressPercent(p.Translated, p.Strings),
+		})
+	}
+	return res
+}
+
+// apiProgressArgs checks days argument and returns it with the current time.
+// Returns false if nothing changed since the client's copy or on error.
+// extra is other state the response depends on, for ETag
+func apiProgressArgs(w http.ResponseWriter, r *http.Request, app *App, kind string, extra ...interface{}) (int, time.Time, bool) {
+	days, err := intArg(r, "days", apiDefaultProgressDays)
+	if err == nil && days < 0 {
+		err = fmt.Errorf("days must be >= 0")
+	}
+	if err != nil {
+		serveJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, time.Time{}, false
+	}
+	// the history goes until today, so it changes every day even if
+	// translations didn't, which Last-Modified can't express
+	now := time.Now()
+	extra = append(extra, now.UTC().Format("20060102"), days)
+	if checkAppETagNotModified(w, r, app, kind, extra...) {
+		return 0, time.Time{}, false
+	}
+	return days, now, true
+}
+
+func apiStrings(app *App, li *store.LangInfo, filter string) []*APIString {
+	// edits are newest first
+	edits := app.store.EditsForLang(li.Code, -1)
+	history := make(map[string][]APIEdit)
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		history[e.Text] = append(history[e.Text], APIEdit{Translation: e.Translation, User: e.User, Time: e.Time})
+	}
+	reviews := app.store.Reviews(li.Code)
+	res := make([]*APIString, 0)
+	for _, tr := range li.ActiveStrings {
+		s := &APIString{
+			String:      tr.String,
+			Translation: tr.Current(),
+			History:     history[tr.String],
+		}
+		if s.History == nil {
+			s.History = make([]APIEdit, 0)
+		}
+		if r := reviews[tr.String]; r != nil {
+			s.Review = &APIReview{
+				User:        r.User,
+				Translation: r.Translation,
+				Time:        r.Time,
+				Outdated:    r.Translation != s.Translation,
+			}
+		}
+		switch filter {
+		case "untranslated":
+			if tr.IsTranslated() {
+				continue
+			}
+		case "translated":
+			if !tr.IsTranslated() {
+				continue
+			}
+		case "unreviewed":
+			if !tr.IsTranslated() || (s.Review != nil && !s.Review.Outdated) {
+				continue
+			}
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func intArg(r *http.Request, name string, def int) (int, error) {
+	s := strings.TrimSpace(r.FormValue(name))
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return n, nil
+}
+
+// apiPageArgs returns page and per_page arguments or writes an error
+func apiPageArgs(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, err := intArg(r, "page", 1)
+	if err == nil && page < 1 {
+		err = fmt.Errorf("page must be >= 1")
+	}
+	if err != nil {
+		serveJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, 0, false
+	}
+	perPage, err := intArg(r, "per_page", apiDefaultPerPage)
+	if err == nil && (perPage < 1 || perPage > apiMaxPerPage) {
+		err = fmt.Errorf("per_page must be between 1 and %d", apiMaxPerPage)
+	}
+	if err != nil {
+		serveJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// checkAPIPageNotModified is checkAppNotModified for a paginated list. The
+// page is part of ETag. Returns true if the response was written, either
+// 304 Not Modified or an error
+func checkAPIPageNotModified(w http.ResponseWriter, r *http.Request, app *App, kind string, extra ...interface{}) bool {
+	page, perPage, ok := apiPageArgs(w, r)
+	if !ok {
+		return true
+	}
+	extra = append(extra, page, perPage)
+	return checkAppNotModified(w, r, app, kind, extra...)
+}
+
+// serveAPIPage serves a page of items given by page and per_page arguments
+func serveAPIPage(w http.ResponseWriter, r *http.Request, n int, items func(start, end int) interface{}) {
+	page, perPage, ok := apiPageArgs(w, r)
+	if !ok {
+		return
+	}
+	// pages past the end are empty. Compare before multiplying, which could
+	// overflow for huge page
+	start := n
+	if page-1 <= n/perPage {
+		start = (page - 1) * perPage
+		if start > n {
+			start = n
+		}
+	}
+	end := start + perPage
+	if end > n {
+		end = n
+	}
+	serveJSON(w, &APIPage{
+		Page:    page,
+		PerPage: perPage,
+		Total:   n,
+		Items:   items(start, end),
+	})
+}
+
+func checkAPIGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		serveJSONError(w, http.StatusMethodNotAllowed, "must be GET")
+		return false
+	}
+	return true
+}
+
+// apiAppArg returns app given in the url or writes an error
+func apiAppArg(w http.ResponseWriter, r *http.Request) *App {
+	appName := mux.Vars(r)["appname"]
+	app := findApp(appName)
+	if app == nil {
+		serveJSONError(w, http.StatusNotFound, fmt.Sprintf("application %q doesn't exist", appName))
+	}
+	return app
+}
+
+// apiAppLangArg returns app and language given in the url or writes an error
+func apiAppLangArg(w http.ResponseWriter, r *http.Request) (*App, string) {
+	app := apiAppArg(w, r)
+	if app == nil {
+		return nil, ""
+	}
+	langCode := mux.Vars(r)["lang"]
+	if !store.IsValidLangCode(langCode) {
+		serveJSONError(w, http.StatusNotFound, fmt.Sprintf("language %q doesn't exist", langCode))
+		return nil, ""
+	}
+	return app, langCode
+}
+
+// url: GET /api/v1/apps
+func handleAPIApps(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	apps := appState.Apps
+	serveAPIPage(w, r, len(apps), func(start, end int) interface{} {
+		res := make([]*APIApp, 0)
+		for _, app := range apps[start:end] {
+			res = append(res, apiAppFromApp(app))
+		}
+		return res
+	})
+}
+
+// url: GET /api/v1/apps/{appname}
+func handleAPIApp(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app := apiAppArg(w, r)
+	if app == nil || checkAppNotModified(w, r, app, "api-app") {
+		return
+	}
+	serveJSON(w, apiAppFromApp(app))
+}
+
+// url: GET /api/v1/apps/{appname}/langs
+// Languages are sorted by progress, most translated first
+func handleAPILangs(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app := apiAppArg(w, r)
+	if app == nil || checkAPIPageNotModified(w, r, app, "api-langs") {
+		return
+	}
+	langs := app.store.LangInfos()
+	serveAPIPage(w, r, len(langs), func(start, end int) interface{} {
+		res := make([]*APILang, 0)
+		for _, li := range langs[start:end] {
+			res = append(res, apiLangFromLangInfo(li))
+		}
+		return res
+	})
+}
+
+// url: GET /api/v1/apps/{appname}/langs/{lang}
+func handleAPILang(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app, langCode := apiAppLangArg(w, r)
+	if app == nil || checkAppNotModified(w, r, app, "api-lang", langCode) {
+		return
+	}
+	li := findLangInfo(app.store.LangInfos(), langCode)
+	serveJSON(w, apiLangFromLangInfo(li))
+}
+
+// url: GET /api/v1/apps/{appname}/langs/{lang}/strings?filter=${filter}
+// filter is all (default), untranslated, translated or unreviewed
+func handleAPIStrings(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app, langCode := apiAppLangArg(w, r)
+	if app == nil {
+		return
+	}
+	filter := strings.TrimSpace(r.FormValue("filter"))
+	switch filter {
+	case "", "all", "untranslated", "translated", "unreviewed":
+	default:
+		serveJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter %q", filter))
+		return
+	}
+	// strings include reviews, which don't change the revision
+	if checkAPIPageNotModified(w, r, app, "api-strings", langCode, filter, app.store.ReviewsCount()) {
+		return
+	}
+	li := findLangInfo(app.store.LangInfos(), langCode)
+	strs := apiStrings(app, li, filter)
+	serveAPIPage(w, r, len(strs), func(start, end int) interface{} {
+		return strs[start:end]
+	})
+}
+
+// url: GET /api/v1/apps/{appname}/progress?days=${days}
+// Daily progress history of languages that ever had a translation, in the
+// same order as /langs. days is the number of last days (default 365, 0 for
+// all history)
+func handleAPIProgress(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app := apiAppArg(w, r)
+	if app == nil {
+		return
+	}
+	days, now, ok := apiProgressArgs(w, r, app, "api-progress")
+	if !ok {
+		return
+	}
+	history := app.store.ProgressHistoryAll(now)
+	res := make([]*APIProgress, 0)
+	for _, li := range app.store.LangInfos() {
+		points := history[li.Code]
+		for _, p := range points {
+			if p.Translated > 0 {
+				res = append(res, apiProgress(li.Code, points, days))
+				break
+			}
+		}
+	}
+	serveJSON(w, res)
+}
+
+// url: GET /api/v1/apps/{appname}/langs/{lang}/progress?days=${days}
+func handleAPILangProgress(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIGet(w, r) {
+		return
+	}
+	app, langCode := apiAppLangArg(w, r)
+	if app == nil {
+		return
+	}
+	days, now, ok := apiProgressArgs(w, r, app, "api-lang-progress", langCode)
+	if !ok {
+		return
+	}
+	serveJSON(w, apiProgress(langCode, app.store.ProgressHistory(langCode, now), days))
+}
+
+// apiTranslationRequest checks a request that changes a translation of
+// a string of app and returns logged in user and decoded body or writes
+// an error
+func apiTranslationRequest(w http.ResponseWriter, r *http.Request, app *App) (string, *APITranslationRequest) {
+	if r.Method != "POST" {
+		serveJSONError(w, http.StatusMethodNotAllowed, "must be POST")
+		return "", nil
+	}
+	if !isValidCSRFToken(r) {
+		serveJSONError(w, http.StatusForbidden, "invalid or missing CSRF token")
+		return "", nil
+	}
+	user := decodeUserFromCookie(r)
+	if user == "" {
+		serveJSONError(w, http.StatusUnauthorized, "not logged in")
+		return "", nil
+	}
+	if !userCanTranslate(user) {
+		logger.Noticef("Rejected API request by banned user %s", user)
+		serveJSONError(w, http.StatusForbidden, "you're not allowed to edit translations")
+		return "", nil
+	}
+	if isRateLimited(r, user) {
+		w.Header().Set("Retry-After", "60")
+		serveJSONError(w, http.StatusTooManyRequests, "too many requests, please slow down")
+		return "", nil
+	}
+	var req APITranslationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		serveJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %s", err))
+		return "", nil
+	}
+	req.String = strings.TrimSpace(req.String)
+	if req.String == "" {
+		serveJSONError(w, http.StatusBadRequest, "string is missing")
+		return "", nil
+	}
+	if !app.store.IsActiveString(req.String) {
+		serveJSONError(w, http.StatusNotFound, fmt.Sprintf("string %q doesn't exist", req.String))
+		return "", nil
+	}
+	return user, &req
+}
+
+// url: POST /api/v1/apps/{appname}/langs/{lang}/translations
+// Body is json-encoded APITranslationRequest
+func handleAPITranslations(w http.ResponseWriter, r *http.Request) {
+	app, langCode := apiAppLangArg(w, r)
+	if app == nil {
+		return
+	}
+	user, req := apiTranslationRequest(w, r, app)
+	if req == nil {
+		return
+	}
+	if msg := invalidTranslationReason(req.String, req.Translation); msg != "" {
+		serveJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+	checkOverwrite(app, req.String, langCode, user, req.Translation)
+	if err := writeTranslation(app, req.String, req.Translation, langCode, user); err != nil {
+		serveJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to add a translation: %s", err))
+		return
+	}
+	serveJSON(w, map[string]interface{}{
+		"ok":           true,
+		"untranslated": app.store.UntranslatedForLang(langCode),
+	})
+}
+
+// url: POST /api/v1/apps/{appname}/langs/{lang}/reviews
+// url: POST /api/v1/apps/{appname}/langs/{lang}/reverts
+// Body is json-encoded APITranslationRequest. Only for maintainers
+func handleAPIMaintainerAction(w http.ResponseWriter, r *http.Request, action string) {
+	app, langCode := apiAppLangArg(w, r)
+	if app == nil {
+		return
+	}
+	user, req := apiTranslationRequest(w, r, app)
+	if req == nil {
+		return
+	}
+	if !userIsMaintainer(app, langCode, user) {
+		serveJSONError(w, http.StatusForbidden, fmt.Sprintf("you're not a maintainer of %s", store.LangNameByCode(langCode)))
+		return
+	}
+	if cur, _ := app.store.CurrentTranslation(req.String, langCode); cur == "" {
+		serveJSONError(w, http.StatusConflict, fmt.Sprintf("string %q is not translated", req.String))
+		return
+	}
+	if action == "review" {
+		if err := app.store.WriteReview(req.String, langCode, user); err != nil {
+			serveJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to review translation: %s", err))
+			return
+		}
+		logger.Noticef("%s: Approved translation of %q in %s/%s", user, req.String, app.Name, langCode)
+		serveJSON(w, map[string]interface{}{"ok": true})
+		return
+	}
+	trans, err := revertTranslation(app, req.String, langCode, user)
+	if err != nil {
+		serveJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to revert translation: %s", err))
+		return
+	}
+	logger.Noticef("%s: Reverted translation of %q to %q in %s/%s", user, req.String, trans, app.Name, langCode)
+	serveJSON(w, map[string]interface{}{
+		"ok":          true,
+		"translation": trans,
+	})
+}
+
+func handleAPIReviews(w http.ResponseWriter, r *http.Request) {
+	handleAPIMaintainerAction(w, r, "review")
+}
+
+func handleAPIReverts(w http.ResponseWriter, r *http.Request) {
+	handleAPIMaintainerAction(w, r, "revert")
+}
+
+// url: /api/v1/openapi.json
+func handleAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	serveFileStatic(w, r, "openapi.json")
+}
+
+// url: /api/v1/*, for urls that don't match any endpoint
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	serveJSONError(w, http.StatusNotFound, fmt.Sprintf("no API endpoint %s", r.URL.Path))
+}
+
+func registerAPIHandlers(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.json", handleAPIOpenAPI)
+	api.HandleFunc("/apps", makeTimingHandler(handleAPIApps))
+	api.HandleFunc("/apps/{appname}", makeTimingHandler(handleAPIApp))
+	api.HandleFunc("/apps/{appname}/langs", makeTimingHandler(handleAPILangs))
+	api.HandleFunc("/apps/{appname}/progress", makeTimingHandler(handleAPIProgress))
+	api.HandleFunc("/apps/{appname}/langs/{lang}", makeTimingHandler(handleAPILang))
+	api.HandleFunc("/apps/{appname}/langs/{lang}/progress", makeTimingHandler(handleAPILangProgress))
+	api.HandleFunc("/apps/{appname}/langs/{lang}/strings", makeTimingHandler(handleAPIStrings))
+	api.HandleFunc("/apps/{appname}/langs/{lang}/translations", makeTimingHandler(handleAPITranslations))
+	api.HandleFunc("/apps/{appname}/langs/{lang}/reviews", makeTimingHandler(handleAPIReviews))
+	api.HandleFunc("/apps/{appname}/langs/{lang}/reverts", makeTimingHandler(handleAPIReverts))
+	api.PathPrefix("/").HandlerFunc(handleAPINotFound)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func apiRequest(h http.Handler, method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if cookies != nil {
+		req.Header.Set("X-CSRF-Token", csrfFromCookies(cookies))
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func apiGet(t *testing.T, h http.Handler, path string, v interface{}) {
+	rr := apiRequest(h, "GET", path, "", nil)
+	if rr.Code != 200 {
+		t.Fatalf("GET %s returned %d, %s", path, rr.Code, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s returned invalid json %s", path, err)
+	}
+}
+
+func expectAPIError(t *testing.T, rr *httptest.ResponseRecorder, code int) {
+	var res map[string]string
+	if rr.Code != code || json.Unmarshal(rr.Body.Bytes(), &res) != nil || res["error"] == "" {
+		t.Fatalf("expected %d with json error, got %d, %s", code, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAPI(t *testing.T) {
+	defer setupTestUserStore(t, "apitest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "apiapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar", "baz"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var apps struct {
+		Total int
+		Items []APIApp
+	}
+	apiGet(t, h, "/api/v1/apps", &apps)
+	if apps.Total != len(appState.Apps) || apps.Items[len(apps.Items)-1].Name != "TestApp" {
+		t.Fatalf("unexpected apps %#v", apps)
+	}
+	expectAPIError(t, apiRequest(h, "GET", "/api/v1/apps/NoApp", "", nil), 404)
+	expectAPIError(t, apiRequest(h, "GET", "/api/v1/apps/TestApp/langs/xx/strings", "", nil), 404)
+	expectAPIError(t, apiRequest(h, "GET", "/api/v1/nothing", "", nil), 404)
+	expectAPIError(t, apiRequest(h, "GET", "/api/v1/apps?page=0", "", nil), 400)
+	apiGet(t, h, "/api/v1/apps?page=184467440737095518&per_page=100", &apps)
+	if apps.Total != len(appState.Apps) || len(apps.Items) != 0 {
+		t.Fatalf("page past the end returned %#v", apps)
+	}
+
+	// submitting needs a logged in user and CSRF token
+	body := `{"string": "foo", "translation": "fuu"}`
+	path := "/api/v1/apps/TestApp/langs/pl/translations"
+	expectAPIError(t, apiRequest(h, "POST", path, body, nil), 403)
+	alice := loginTestUser(t, "alice", false)
+	if rr := apiRequest(h, "POST", path, body, alice); rr.Code != 200 {
+		t.Fatalf("submitting translation returned %d, %s", rr.Code, rr.Body.String())
+	}
+	expectAPIError(t, apiRequest(h, "POST", path, `{"string": "nope", "translation": "x"}`, alice), 404)
+	expectAPIError(t, apiRequest(h, "POST", path, `{"string": "foo", "translation": "%s"}`, alice), 400)
+
+	var lang APILang
+	apiGet(t, h, "/api/v1/apps/TestApp/langs/pl", &lang)
+	if lang.Strings != 3 || lang.Translated != 1 || lang.Progress != 33 {
+		t.Fatalf("unexpected lang %#v", lang)
+	}
+
+	var progress []APIProgress
+	apiGet(t, h, "/api/v1/apps/TestApp/progress", &progress)
+	if len(progress) != 1 || progress[0].Code != "pl" || len(progress[0].Days) != 1 {
+		t.Fatalf("unexpected progress %#v", progress)
+	}
+	if day := progress[0].Days[0]; day.Translated != 1 || day.Untranslated != 2 || day.Progress != 33 {
+		t.Fatalf("unexpected progress %#v", day)
+	}
+	var langProgress APIProgress
+	apiGet(t, h, "/api/v1/apps/TestApp/langs/de/progress?days=0", &langProgress)
+	if len(langProgress.Days) != 1 || langProgress.Days[0].Translated != 0 || langProgress.Days[0].Strings != 3 {
+		t.Fatalf("unexpected progress %#v", langProgress)
+	}
+	expectAPIError(t, apiRequest(h, "GET", "/api/v1/apps/TestApp/progress?days=-1", "", nil), 400)
+
+	var strs struct {
+		Page    int
+		PerPage int
+		Total   int
+		Items   []APIString
+	}
+	apiGet(t, h, "/api/v1/apps/TestApp/langs/pl/strings?per_page=2&page=2", &strs)
+	if strs.Total != 3 || len(strs.Items) != 1 || strs.Items[0].String != "foo" {
+		t.Fatalf("unexpected strings %#v", strs)
+	}
+	s := strs.Items[0]
+	if s.Translation != "fuu" || len(s.History) != 1 || s.History[0].User != "alice" || s.Review != nil {
+		t.Fatalf("unexpected string %#v", s)
+	}
+
+	// only maintainers can review and revert
+	body = `{"string": "foo"}`
+	expectAPIError(t, apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/reviews", body, alice), 403)
+	kjk := loginTestUser(t, "kjk", false)
+	if rr := apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/reviews", body, kjk); rr.Code != 200 {
+		t.Fatalf("review returned %d, %s", rr.Code, rr.Body.String())
+	}
+	apiGet(t, h, "/api/v1/apps/TestApp/langs/pl/strings?filter=translated", &strs)
+	if len(strs.Items) != 1 || strs.Items[0].Review == nil || strs.Items[0].Review.User != "kjk" {
+		t.Fatalf("unexpected strings %#v", strs)
+	}
+	if rr := apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/reverts", body, kjk); rr.Code != 200 {
+		t.Fatalf("revert returned %d, %s", rr.Code, rr.Body.String())
+	}
+	expectAPIError(t, apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/reverts", body, kjk), 409)
+	apiGet(t, h, "/api/v1/apps/TestApp/langs/pl/strings?filter=untranslated", &strs)
+	if strs.Total != 3 {
+		t.Fatalf("unexpected strings %#v", strs)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	h := setupLoginTest(t, nil, nil)
+	var spec struct {
+		OpenAPI string
+		Paths   map[string]interface{}
+	}
+	apiGet(t, h, "/api/v1/openapi.json", &spec)
+	if spec.OpenAPI == "" || spec.Paths["/apps/{app}/langs/{lang}/strings"] == nil {
+		t.Fatalf("unexpected spec %#v", spec)
+	}
+}
@@ -0,0 +1,238 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/kjk/apptranslator/store"
+)
+
+// API tokens authenticate scripts that upload strings, download
+// translations etc. They're sent in Authorization header:
+//
+//   Authorization: Bearer apptr_...
+//
+// Each token belongs to one app and has scopes limiting what it can do.
+// We only store sha256 of the token.
+
+const apiTokenPrefix = "apptr_"
+
+// newAPIToken returns id and value of a new token
+func newAPIToken() (string, string) {
+	id := fmt.Sprintf("%x", securecookie.GenerateRandomKey(8))
+	token := apiTokenPrefix + fmt.Sprintf("%x", securecookie.GenerateRandomKey(24))
+	return id, token
+}
+
+func hashAPIToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// bearerToken returns token from Authorization header or "" if not present
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// apiTokenUploader returns how uploads done with a token are attributed
+func apiTokenUploader(tok *store.APIToken) string {
+	return "token:" + tok.ID
+}
+
+// authAPIRequest checks that r has a valid token of app with a given scope
+// and returns who made the request, to be recorded with uploads. Before
+// tokens, apps had a single UploadSecret passed as secret argument, which
+// is still accepted for uploads if configured. Writes an error response
+// and returns "" if r is not authorized
+func authAPIRequest(w http.ResponseWriter, r *http.Request, app *App, scope string) string {
+	if !checkAuthLockout(w, r) || !checkRateLimit(w, r, "") {
+		return ""
+	}
+	if token := bearerToken(r); token != "" {
+		tok := userStore.APITokenByHash(hashAPIToken(token))
+		if tok == nil || tok.Revoked || tok.App != app.Name {
+			logger.Noticef("Invalid API token for %s in %s", app.Name, r.URL.Path)
+			recordAuthFailure(r)
+			http.Error(w, "Invalid API token", http.StatusUnauthorized)
+			return ""
+		}
+		if !tokenLimiter.allow(tok.ID, time.Now()) {
+			logger.Noticef("Rate limited API token %s (%s) of %s", tok.ID, tok.Name, app.Name)
+			writeTooManyRequests(w, "Too many requests with this API token, please slow down")
+			return ""
+		}
+		if !tok.HasScope(scope) {
+			logger.Noticef("API token %s (%s) of %s doesn't have scope %s", tok.ID, tok.Name, app.Name, scope)
+			http.Error(w, fmt.Sprintf("API token doesn't have scope %s", scope), http.StatusForbidden)
+			return ""
+		}
+		if err := userStore.MarkAPITokenUsed(tok.ID, time.Now()); err != nil {
+			logger.Errorf("MarkAPITokenUsed() failed with %s", err)
+		}
+		return apiTokenUploader(tok)
+	}
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret != "" && app.UploadSecret != "" && scope == store.ScopeUploadStrings {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(app.UploadSecret)) == 1 {
+			logger.Noticef("%s used deprecated UploadSecret in %s, use API token instead", app.Name, r.URL.Path)
+			return "secret"
+		}
+	}
+	logger.Noticef("Unauthorized API request for %s in %s", app.Name, r.URL.Path)
+	recordAuthFailure(r)
+	http.Error(w, "Missing or invalid API token", http.StatusUnauthorized)
+	return ""
+}
+
+// redactSecrets removes values of arguments with secrets from query, so
+// that they don't end up in logs
+func redactSecrets(rawQuery string) string {
+	vals, err := url.ParseQuery(rawQuery)
+	if err != nil || vals.Get("secret") == "" {
+		return rawQuery
+	}
+	vals.Set("secret", "redacted")
+	return vals.Encode()
+}
+
+// describeUploader returns human-readable name of who did an upload
+func describeUploader(by string) string {
+	if !strings.HasPrefix(by, "token:") {
+		return by
+	}
+	id := strings.TrimPrefix(by, "token:")
+	if tok := userStore.GetAPIToken(id); tok != nil {
+		return fmt.Sprintf("token %q", tok.Name)
+	}
+	return by
+}
+
+// UploadDisplay describes an upload shown on tokens page
+type UploadDisplay struct {
+	store.Upload
+	Uploader string
+}
+
+// ModelTokens describes /admin/tokens page
+type ModelTokens struct {
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	App         *App
+	Message     string
+	Tokens      []*store.APIToken
+	Scopes      []string
+	Uploads     []*UploadDisplay
+	// value of a newly created token, shown only once
+	NewToken     string
+	NewTokenName string
+}
+
+const maxUploadsShown = 20
+
+func buildModelTokens(app *App, user string) *ModelTokens {
+	model := &ModelTokens{
+		PageTitle: fmt.Sprintf("API tokens of %s", app.Name),
+		User:      user,
+		App:       app,
+		Tokens:    userStore.APITokens(app.Name),
+		Scopes:    store.TokenScopes,
+	}
+	for _, up := range app.store.RecentUploads(maxUploadsShown) {
+		model.Uploads = append(model.Uploads, &UploadDisplay{
+			Upload:   up,
+			Uploader: describeUploader(up.By),
+		})
+	}
+	return model
+}
+
+func getAppAdminArg(w http.ResponseWriter, r *http.Request) (*App, string) {
+	app := getAppArg(w, r)
+	if app == nil {
+		return nil, ""
+	}
+	user := decodeUserFromCookie(r)
+	if !userIsAdmin(app, user) {
+		httpErrorf(w, "You're not an admin of %s", app.Name)
+		return nil, ""
+	}
+	return app, user
+}
+
+func serveTokensPage(w http.ResponseWriter, r *http.Request, model *ModelTokens) {
+	model.RedirectUrl = "/admin/tokens?app=" + url.QueryEscape(model.App.Name)
+	model.CsrfToken = csrfToken(r)
+	ExecTemplate(w, tmplTokens, model)
+}
+
+// url: /admin/tokens?app=${app}&msg=${msg}
+func handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	model := buildModelTokens(app, user)
+	model.Message = r.FormValue("msg")
+	serveTokensPage(w, r, model)
+}
+
+// url: POST /admin/tokens/create
+// app, name, scope (can be repeated)
+func handleAdminTokensCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	id, token := newAPIToken()
+	tok, err := userStore.CreateAPIToken(id, app.Name, name, r.Form["scope"], hashAPIToken(token), user)
+	if err != nil {
+		httpErrorf(w, "Failed to create API token: %s", err)
+		return
+	}
+	logger.Noticef("%s created API token %s (%s) for %s with scopes %v", user, tok.ID, tok.Name, app.Name, tok.Scopes)
+	model := buildModelTokens(app, user)
+	model.NewToken = token
+	model.NewTokenName = tok.Name
+	serveTokensPage(w, r, model)
+}
+
+// url: POST /admin/tokens/revoke
+// app, id
+func handleAdminTokensRevoke(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	tok := userStore.GetAPIToken(r.FormValue("id"))
+	if tok == nil || tok.App != app.Name {
+		httpErrorf(w, "API token doesn't exist")
+		return
+	}
+	if err := userStore.RevokeAPIToken(tok.ID, user); err != nil {
+		httpErrorf(w, "Failed to revoke API token: %s", err)
+		return
+	}
+	logger.Noticef("%s revoked API token %s (%s) of %s", user, tok.ID, tok.Name, app.Name)
+	msg := fmt.Sprintf("Revoked API token %q", tok.Name)
+	u := fmt.Sprintf("/admin/tokens?app=%s&msg=%s", url.QueryEscape(app.Name), url.QueryEscape(msg))
+	http.Redirect(w, r, u, http.StatusFound)
+}
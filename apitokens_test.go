@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+// setupTestApp adds an app with a fresh store. Call the returned function to
+// remove it
+func setupTestApp(t *testing.T, name, path string) (*App, func()) {
+	os.Remove(path)
+	s, err := store.NewStoreCsv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{AppConfig: AppConfig{Name: name, Admins: []string{"kjk"}}, store: s}
+	appState.Apps = append(appState.Apps, app)
+	return app, func() {
+		appState.Apps = appState.Apps[:len(appState.Apps)-1]
+		s.Close()
+		os.Remove(path)
+	}
+}
+
+func uploadStrings(h http.Handler, app, token, secret string) *httptest.ResponseRecorder {
+	vals := url.Values{
+		"app":     {app},
+		"strings": {"AppTranslator strings\nfoo\nbar"},
+	}
+	if secret != "" {
+		vals.Set("secret", secret)
+	}
+	req := httptest.NewRequest("POST", "/uploadstrings", strings.NewReader(vals.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func createTestToken(t *testing.T, app, name string, scopes ...string) (*store.APIToken, string) {
+	id, token := newAPIToken()
+	tok, err := userStore.CreateAPIToken(id, app, name, scopes, hashAPIToken(token), "kjk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok, token
+}
+
+func TestAPITokens(t *testing.T) {
+	defer setupTestUserStore(t, "apitokenstest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "apitokensapptest.dat")
+	defer cleanup()
+	_, cleanup2 := setupTestApp(t, "OtherApp", "apitokensothertest.dat")
+	defer cleanup2()
+
+	tok, token := createTestToken(t, "TestApp", "ci", store.ScopeUploadStrings)
+	_, dlToken := createTestToken(t, "TestApp", "dl", store.ScopeDownload)
+	_, otherToken := createTestToken(t, "OtherApp", "ci", store.ScopeAdmin)
+
+	if rr := uploadStrings(h, "TestApp", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("upload without a token returned %d", rr.Code)
+	}
+	if rr := uploadStrings(h, "TestApp", "apptr_bad", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("upload with invalid token returned %d", rr.Code)
+	}
+	if rr := uploadStrings(h, "TestApp", otherToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("upload with token of another app returned %d", rr.Code)
+	}
+	if rr := uploadStrings(h, "TestApp", dlToken, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("upload with download token returned %d", rr.Code)
+	}
+	if rr := uploadStrings(h, "TestApp", token, ""); rr.Code != 200 {
+		t.Fatalf("upload returned %d, %s", rr.Code, rr.Body.String())
+	}
+	uploads := app.store.RecentUploads(10)
+	if len(uploads) != 1 || uploads[0].By != "token:"+tok.ID {
+		t.Fatalf("upload not attributed to the token: %#v", uploads)
+	}
+	if userStore.GetAPIToken(tok.ID).LastUsed.IsZero() {
+		t.Fatalf("last use of the token not recorded")
+	}
+
+	// deprecated UploadSecret still works, but only if configured
+	if rr := uploadStrings(h, "TestApp", "", "sec"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("upload with unconfigured secret returned %d", rr.Code)
+	}
+	app.UploadSecret = "sec"
+	if rr := uploadStrings(h, "TestApp", "", "sec"); rr.Code != 200 {
+		t.Fatalf("upload with secret returned %d", rr.Code)
+	}
+	if uploads = app.store.RecentUploads(1); uploads[0].By != "secret" {
+		t.Fatalf("upload not attributed to the secret: %#v", uploads)
+	}
+
+	if err := userStore.RevokeAPIToken(tok.ID, "kjk"); err != nil {
+		t.Fatal(err)
+	}
+	if rr := uploadStrings(h, "TestApp", token, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("upload with revoked token returned %d", rr.Code)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	if s := redactSecrets("app=foo&secret=bar"); strings.Contains(s, "bar") {
+		t.Fatalf("secret not redacted in %q", s)
+	}
+	if s := redactSecrets("app=foo"); s != "app=foo" {
+		t.Fatalf("unexpected %q", s)
+	}
+}
@@ -0,0 +1,238 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+// SVG badges for embedding in READMEs, in the style of shields.io:
+// /badge/${app}.svg, /badge/${app}/${lang}.svg and /widget/${app}.svg with
+// progress of all languages
+
+// badges are mostly fetched by image proxies (e.g. GitHub's camo), which
+// honor Cache-Control. They revalidate with ETag afterwards
+const badgeMaxAge = 5 * 60
+
+const (
+	badgeColorGrey = "#9f9f9f"
+	widgetMaxLangs = 50
+)
+
+// badgeColor returns color for a percentage of translated strings
+func badgeColor(progress int) string {
+	switch {
+	case progress >= 90:
+		return "#4c1"
+	case progress >= 75:
+		return "#97ca00"
+	case progress >= 50:
+		return "#dfb317"
+	case progress >= 25:
+		return "#fe7d37"
+	}
+	return "#e05d44"
+}
+
+// textWidth approximates width in pixels of s in 11px Verdana, which is
+// good enough for sizing badges
+func textWidth(s string) int {
+	w := 0.0
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("ijlI.,:;|!' ", c):
+			w += 3.8
+		case strings.ContainsRune("frt()[]", c):
+			w += 4.9
+		case strings.ContainsRune("mwMW%", c):
+			w += 10.5
+		case c >= 'A' && c <= 'Z':
+			w += 7.7
+		default:
+			w += 7
+		}
+	}
+	return int(w + 0.5)
+}
+
+func xmlEscape(s string) string {
+	return template.HTMLEscapeString(s)
+}
+
+// badgeSVG returns a flat badge with label on grey and message on color
+func badgeSVG(label, message, color string) []byte {
+	lw := textWidth(label) + 10
+	mw := textWidth(message) + 10
+	label, message = xmlEscape(label), xmlEscape(message)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, lw+mw, label, message)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, message)
+	b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, lw+mw)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`, lw, lw, mw, color, lw+mw)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	for _, t := range []struct {
+		x    int
+		text string
+	}{{lw / 2, label}, {lw + mw/2, message}} {
+		fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, t.x, t.text, t.x, t.text)
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+// badgeLang is progress of a language shown in the widget
+type badgeLang struct {
+	Name     string
+	Progress int
+}
+
+// langInfoProgress returns percentage of translated active strings of li
+func langInfoProgress(li *store.LangInfo) int {
+	total := len(li.ActiveStrings)
+	return progressPercent(total-li.UntranslatedCount(), total)
+}
+
+// translatedLangs returns progress of languages that have any translations,
+// most translated first
+func translatedLangs(app *App) []badgeLang {
+	var res []badgeLang
+	for _, li := range app.store.LangInfos() {
+		if li.UntranslatedCount() < len(li.ActiveStrings) {
+			res = append(res, badgeLang{li.Name, langInfoProgress(li)})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Progress > res[j].Progress
+	})
+	return res
+}
+
+// widgetSVG returns a card with a progress bar for each language
+func widgetSVG(title string, langs []badgeLang) []byte {
+	const width, rowHeight, nameWidth, barWidth = 280, 20, 110, 120
+	height := 30 + rowHeight*len(langs)
+	if len(langs) == 0 {
+		height += rowHeight
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s">`, width, height, xmlEscape(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, xmlEscape(title))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" rx="4" fill="#fff" stroke="#ddd"/>`, width-1, height-1)
+	b.WriteString(`<g font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11" fill="#333">`)
+	fmt.Fprintf(&b, `<text x="10" y="19" font-weight="bold">%s</text>`, xmlEscape(title))
+	if len(langs) == 0 {
+		b.WriteString(`<text x="10" y="42" fill="#999">no translations yet</text>`)
+	}
+	for i, l := range langs {
+		y := 30 + i*rowHeight
+		fmt.Fprintf(&b, `<text x="10" y="%d">%s</text>`, y+14, xmlEscape(l.Name))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="10" rx="2" fill="#eee"/>`, nameWidth, y+5, barWidth)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="10" rx="2" fill="%s"/>`, nameWidth, y+5, barWidth*l.Progress/100, badgeColor(l.Progress))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%d%%</text>`, width-10, y+14, l.Progress)
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+func serveSVG(w http.ResponseWriter, code int, svg []byte) {
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(svg)))
+	w.WriteHeader(code)
+	w.Write(svg)
+}
+
+// serveBadgeError serves a grey badge, so that a broken badge in a README
+// says what's wrong
+func serveBadgeError(w http.ResponseWriter, message string) {
+	serveSVG(w, http.StatusNotFound, badgeSVG("translated", message, badgeColorGrey))
+}
+
+// badgeApp returns app from the url or serves an error badge
+func badgeApp(w http.ResponseWriter, r *http.Request) *App {
+	app := findApp(mux.Vars(r)["appname"])
+	if app == nil {
+		serveBadgeError(w, "unknown app")
+	}
+	return app
+}
+
+// checkBadgeNotModified sets caching headers of a badge that depends on
+// translations of app
+func checkBadgeNotModified(w http.ResponseWriter, r *http.Request, app *App, kind string, extra ...interface{}) bool {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", badgeMaxAge))
+	return checkAppNotModified(w, r, app, kind, extra...)
+}
+
+// url: /badge/${app}.svg
+// average progress of languages that have any translations
+func handleBadgeApp(w http.ResponseWriter, r *http.Request) {
+	app := badgeApp(w, r)
+	if app == nil || checkBadgeNotModified(w, r, app, "badge") {
+		return
+	}
+	langs := translatedLangs(app)
+	if len(langs) == 0 {
+		serveSVG(w, http.StatusOK, badgeSVG("translated", "no languages", badgeColorGrey))
+		return
+	}
+	sum := 0
+	for _, l := range langs {
+		sum += l.Progress
+	}
+	progress := sum / len(langs)
+	msg := fmt.Sprintf("%d%% in %d languages", progress, len(langs))
+	if len(langs) == 1 {
+		msg = fmt.Sprintf("%d%% in 1 language", progress)
+	}
+	serveSVG(w, http.StatusOK, badgeSVG("translated", msg, badgeColor(progress)))
+}
+
+// url: /badge/${app}/${lang}.svg
+func handleBadgeLang(w http.ResponseWriter, r *http.Request) {
+	app := badgeApp(w, r)
+	if app == nil {
+		return
+	}
+	lang := mux.Vars(r)["lang"]
+	if !store.IsValidLangCode(lang) {
+		serveBadgeError(w, "unknown language")
+		return
+	}
+	if checkBadgeNotModified(w, r, app, "badge", lang) {
+		return
+	}
+	li := findLangInfo(app.store.LangInfos(), lang)
+	progress := langInfoProgress(li)
+	serveSVG(w, http.StatusOK, badgeSVG(li.Name, fmt.Sprintf("%d%% translated", progress), badgeColor(progress)))
+}
+
+// url: /widget/${app}.svg[?max=${n}]
+// progress of languages that have any translations, most translated first.
+// max limits the number of languages (default and maximum is 50)
+func handleWidget(w http.ResponseWriter, r *http.Request) {
+	app := badgeApp(w, r)
+	if app == nil {
+		return
+	}
+	max, err := intArg(r, "max", widgetMaxLangs)
+	if err != nil || max < 1 || max > widgetMaxLangs {
+		max = widgetMaxLangs
+	}
+	if checkBadgeNotModified(w, r, app, "widget", max) {
+		return
+	}
+	langs := translatedLangs(app)
+	if len(langs) > max {
+		langs = langs[:max]
+	}
+	serveSVG(w, http.StatusOK, widgetSVG(app.Name+" translations", langs))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBadgeColor(t *testing.T) {
+	tests := map[int]string{100: "#4c1", 90: "#4c1", 89: "#97ca00", 50: "#dfb317", 25: "#fe7d37", 0: "#e05d44"}
+	for progress, exp := range tests {
+		if got := badgeColor(progress); got != exp {
+			t.Errorf("badgeColor(%d) is %s, exp: %s", progress, got, exp)
+		}
+	}
+}
+
+func TestBadges(t *testing.T) {
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "Badge&Test", "badgetest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar", "baz", "qux"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range [][]string{{"foo", "de"}, {"bar", "de"}, {"baz", "de"}, {"qux", "de"}, {"foo", "pl"}} {
+		if err := app.store.WriteNewTranslation(tr[0], tr[0]+"-"+tr[1], tr[1], "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string, code int, exp ...string) *httptest.ResponseRecorder {
+		rr := getURL(h, path)
+		body := rr.Body.String()
+		if rr.Code != code || rr.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" {
+			t.Fatalf("%s returned %d, %s", path, rr.Code, body)
+		}
+		for _, s := range exp {
+			if !strings.Contains(body, s) {
+				t.Fatalf("%s doesn't contain %q:\n%s", path, s, body)
+			}
+		}
+		return rr
+	}
+	rr := get("/badge/Badge&Test/de.svg", 200, "German: 100% translated", "#4c1")
+	if rr.Header().Get("Cache-Control") == "" || rr.Header().Get("ETag") == "" {
+		t.Fatalf("badge has no caching headers %v", rr.Header())
+	}
+	req := httptest.NewRequest("GET", "/badge/Badge&Test/de.svg", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr2 := httptest.NewRecorder()
+	h.ServeHTTP(rr2, req)
+	if rr2.Code != 304 {
+		t.Fatalf("revalidating badge returned %d", rr2.Code)
+	}
+	get("/badge/Badge&Test/pl.svg", 200, "Polish: 25% translated", "#fe7d37")
+	get("/badge/Badge&Test/fr.svg", 200, "French: 0% translated", "#e05d44")
+	get("/badge/Badge&Test.svg", 200, "translated: 62% in 2 languages")
+	get("/badge/Badge&Test/xx.svg", 404, "unknown language")
+	get("/badge/NoApp.svg", 404, "unknown app")
+	get("/widget/Badge&Test.svg", 200, "Badge&amp;Test translations", ">German<", ">25%<")
+	rr = get("/widget/Badge&Test.svg?max=1", 200, ">German<")
+	if strings.Contains(rr.Body.String(), "Polish") {
+		t.Fatalf("widget has more languages than max")
+	}
+
+	// nothing to translate is as good as everything translated, like in
+	// the api
+	_, cleanup2 := setupTestApp(t, "Empty", "badgetest2.dat")
+	defer cleanup2()
+	get("/badge/Empty/de.svg", 200, "German: 100% translated", "#4c1")
+}
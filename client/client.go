@@ -0,0 +1,436 @@
+// This code is under BSD license. See license-bsd.txt
+
+// Package client talks to AppTranslator server: uploads strings of an app
+// and downloads their translations.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultServer is the public AppTranslator server
+const DefaultServer = "https://www.apptranslator.org"
+
+// Client uploads strings and downloads translations of an app
+type Client struct {
+	// url of the server e.g. https://www.apptranslator.org
+	Server string
+	App    string
+	// API token of the app, created on /admin/tokens. Must have
+	// upload-strings scope for uploading
+	Token      string
+	HTTPClient *http.Client
+}
+
+// New returns a client for app
+func New(server, app, token string) *Client {
+	return &Client{
+		Server:     strings.TrimSuffix(server, "/"),
+		App:        app,
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(d))
+		var apiErr struct{ Error string }
+		if json.Unmarshal(d, &apiErr) == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
+		return nil, fmt.Errorf("%s %s failed with %s: %s", req.Method, req.URL.Path, resp.Status, msg)
+	}
+	return d, nil
+}
+
+// FormatStrings returns strings in the format expected by /uploadstrings
+func FormatStrings(strs []string) string {
+	return "AppTranslator strings\n" + strings.Join(strs, "\n")
+}
+
+// UploadStrings replaces the list of strings of the app. Returns the
+// message from the server describing what changed
+func (c *Client) UploadStrings(strs []string) (string, error) {
+	for _, s := range strs {
+		if s == "" || strings.ContainsAny(s, "\r\n") {
+			return "", fmt.Errorf("string %q is empty or has a newline", s)
+		}
+	}
+	vals := url.Values{
+		"app":     {c.App},
+		"strings": {FormatStrings(strs)},
+	}
+	req, err := http.NewRequest("POST", c.Server+"/uploadstrings", strings.NewReader(vals.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	d, err := c.do(req)
+	return string(d), err
+}
+
+func (c *Client) getJSON(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", c.Server+path, nil)
+	if err != nil {
+		return err
+	}
+	d, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(d, v)
+}
+
+// Strings returns strings of the app currently on the server, sorted
+func (c *Client) Strings() ([]string, error) {
+	appPath := "/api/v1/apps/" + url.PathEscape(c.App)
+	var langs struct {
+		Items []struct{ Code string }
+	}
+	if err := c.getJSON(appPath+"/langs?per_page=1", &langs); err != nil {
+		return nil, err
+	}
+	if len(langs.Items) == 0 {
+		return nil, fmt.Errorf("app %s has no languages", c.App)
+	}
+	// every language has all the strings
+	var res []string
+	for page := 1; ; page++ {
+		var strs struct {
+			Total int
+			Items []struct{ String string }
+		}
+		path := fmt.Sprintf("%s/langs/%s/strings?per_page=500&page=%d", appPath, langs.Items[0].Code, page)
+		if err := c.getJSON(path, &strs); err != nil {
+			return nil, err
+		}
+		for _, s := range strs.Items {
+			res = append(res, s.String)
+		}
+		if len(strs.Items) == 0 || len(res) >= strs.Total {
+			break
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Diff returns strings that are in newStrings but not in oldStrings and
+// the other way around, sorted
+func Diff(oldStrings, newStrings []string) (added []string, removed []string) {
+	inOld := make(map[string]bool)
+	for _, s := range oldStrings {
+		inOld[s] = true
+	}
+	inNew := make(map[string]bool)
+	for _, s := range newStrings {
+		inNew[s] = true
+		if !inOld[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range oldStrings {
+		if !inNew[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// StringTranslations are translations of a string
+type StringTranslations struct {
+	String string
+	// maps language code to translation. Newlines are escaped as \n
+	Translations map[string]string
+}
+
+// Translations are translations of an app, as returned by /dltrans
+type Translations struct {
+	App  string
+	Sha1 string
+	// false if translations didn't change since the download with sha1
+	// given to Download
+	Changed bool
+	// sorted by string
+	Strings []*StringTranslations
+	// response of the server
+	Raw []byte
+}
+
+// Langs returns codes of languages with at least one translation, sorted
+func (t *Translations) Langs() []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, st := range t.Strings {
+		for lang := range st.Translations {
+			if !seen[lang] {
+				seen[lang] = true
+				res = append(res, lang)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ParseTranslations parses the format returned by /dltrans:
+//
+//	AppTranslator: $appName
+//	$sha1
+//	:string 1
+//	cv:translation for cv language
+//	pl:translation for pl language
+//	:string 2
+//	pl:translation for pl language
+//
+// Instead of sha1 the second line can be "No change" or "Error: $msg"
+func ParseTranslations(d []byte) (*Translations, error) {
+	res := &Translations{Raw: d}
+	r := bufio.NewScanner(bytes.NewReader(d))
+	r.Buffer(nil, 1024*1024)
+	lineNo := 0
+	var cur *StringTranslations
+	for r.Scan() {
+		line := r.Text()
+		lineNo++
+		switch {
+		case lineNo == 1:
+			if !strings.HasPrefix(line, "AppTranslator: ") {
+				return nil, fmt.Errorf("line 1 should be 'AppTranslator: $app', is %q", line)
+			}
+			res.App = strings.TrimPrefix(line, "AppTranslator: ")
+		case lineNo == 2:
+			if line == "No change" {
+				return res, nil
+			}
+			if strings.HasPrefix(line, "Error: ") {
+				return nil, fmt.Errorf("server returned %q", line)
+			}
+			if !isSha1Hex(line) {
+				return nil, fmt.Errorf("line 2 should be sha1, is %q", line)
+			}
+			res.Sha1 = line
+			res.Changed = true
+		case strings.HasPrefix(line, ":"):
+			cur = &StringTranslations{String: line[1:], Translations: make(map[string]string)}
+			res.Strings = append(res.Strings, cur)
+		default:
+			parts := strings.SplitN(line, ":", 2)
+			if cur == nil || len(parts) != 2 {
+				return nil, fmt.Errorf("unexpected line %d: %q", lineNo, line)
+			}
+			cur.Translations[parts[0]] = parts[1]
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if lineNo < 2 {
+		return nil, fmt.Errorf("response is too short: %q", d)
+	}
+	return res, nil
+}
+
+var sha1Re = regexp.MustCompile("^[0-9a-f]{40}$")
+
+func isSha1Hex(s string) bool {
+	return sha1Re.MatchString(s)
+}
+
+// Download downloads translations of the app. If sha1 is the sha1 of
+// the previous download and translations didn't change since then, returns
+// Translations with Changed set to false. Use "" to always get everything
+func (c *Client) Download(sha1 string) (*Translations, error) {
+	u := fmt.Sprintf("%s/dltrans?app=%s", c.Server, url.QueryEscape(c.App))
+	if sha1 != "" {
+		u += "&sha1=" + url.QueryEscape(sha1)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTranslations(d)
+}
+
+// ExtractStrings returns strings matched by patterns in r, in the order of
+// appearance. If a pattern has a group, string is the text of the first
+// group, otherwise the whole match
+func ExtractStrings(r io.Reader, patterns []*regexp.Regexp) ([]string, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	type match struct {
+		pos int
+		s   string
+	}
+	var matches []match
+	for _, re := range patterns {
+		for _, m := range re.FindAllSubmatchIndex(d, -1) {
+			start, end := m[0], m[1]
+			if len(m) > 2 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			matches = append(matches, match{m[0], string(d[start:end])})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].pos < matches[j].pos
+	})
+	res := make([]string, 0, len(matches))
+	for _, m := range matches {
+		res = append(res, m.s)
+	}
+	return res, nil
+}
+
+// TranslationsDelta are translations that changed between two revisions, as
+// returned by /dltransdelta
+type TranslationsDelta struct {
+	App string
+	// current revision, to be given to the next DownloadDelta
+	Revision int
+	// revision the delta is from. If 0, the delta has all translations
+	Since int
+	// sorted by string. Empty translation means the string is no longer
+	// translated
+	Strings []*StringTranslations
+}
+
+// ParseTranslationsDelta parses the format returned by /dltransdelta:
+//
+//	AppTranslator: $appName
+//	Revision: $revision
+//	Since: $since
+//	:string 1
+//	pl:new translation for pl language
+//	de:
+func ParseTranslationsDelta(d []byte) (*TranslationsDelta, error) {
+	lines := strings.Split(strings.TrimSuffix(string(d), "\n"), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "AppTranslator: ") {
+		return nil, fmt.Errorf("invalid response %q", d)
+	}
+	res := &TranslationsDelta{App: strings.TrimPrefix(lines[0], "AppTranslator: ")}
+	if _, err := fmt.Sscanf(lines[1], "Revision: %d", &res.Revision); err != nil {
+		return nil, fmt.Errorf("line 2 should be 'Revision: $rev', is %q", lines[1])
+	}
+	if _, err := fmt.Sscanf(lines[2], "Since: %d", &res.Since); err != nil {
+		return nil, fmt.Errorf("line 3 should be 'Since: $rev', is %q", lines[2])
+	}
+	var cur *StringTranslations
+	for i, line := range lines[3:] {
+		if strings.HasPrefix(line, ":") {
+			cur = &StringTranslations{String: line[1:], Translations: make(map[string]string)}
+			res.Strings = append(res.Strings, cur)
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if cur == nil || len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected line %d: %q", i+4, line)
+		}
+		cur.Translations[parts[0]] = parts[1]
+	}
+	return res, nil
+}
+
+// DownloadDelta downloads translations that changed since revision rev,
+// returned by the previous call (use 0 for the first download)
+func (c *Client) DownloadDelta(rev int) (*TranslationsDelta, error) {
+	u := fmt.Sprintf("%s/dltransdelta?app=%s&rev=%d", c.Server, url.QueryEscape(c.App), rev)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTranslationsDelta(d)
+}
+
+// Apply updates translations t with changes in delta. If delta.Since is 0,
+// it replaces them. Sha1 and Raw of t are updated to be the same as
+// a download from /dltrans would return
+func (d *TranslationsDelta) Apply(t *Translations) {
+	m := make(map[string]map[string]string)
+	if d.Since != 0 {
+		for _, st := range t.Strings {
+			m[st.String] = st.Translations
+		}
+	}
+	for _, st := range d.Strings {
+		trans := m[st.String]
+		if trans == nil {
+			trans = make(map[string]string)
+			m[st.String] = trans
+		}
+		for lang, s := range st.Translations {
+			if s == "" {
+				delete(trans, lang)
+			} else {
+				trans[lang] = s
+			}
+		}
+	}
+	t.Strings = t.Strings[:0]
+	for s, trans := range m {
+		if len(trans) > 0 {
+			t.Strings = append(t.Strings, &StringTranslations{String: s, Translations: trans})
+		}
+	}
+	sort.Slice(t.Strings, func(i, j int) bool {
+		return t.Strings[i].String < t.Strings[j].String
+	})
+	t.App = d.App
+	t.updateRaw()
+}
+
+// updateRaw sets Raw and Sha1 of t from its strings, in the format of
+// /dltrans. Like the server, it sorts lines of translations of a string
+func (t *Translations) updateRaw() {
+	var body bytes.Buffer
+	for _, st := range t.Strings {
+		fmt.Fprintf(&body, ":%s\n", st.String)
+		lines := make([]string, 0, len(st.Translations))
+		for lang, s := range st.Translations {
+			lines = append(lines, lang+":"+s+"\n")
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			body.WriteString(line)
+		}
+	}
+	t.Sha1 = fmt.Sprintf("%x", sha1.Sum(body.Bytes()))
+	t.Raw = append([]byte(fmt.Sprintf("AppTranslator: %s\n%s\n", t.App, t.Sha1)), body.Bytes()...)
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testSha1 = "0123456789abcdef0123456789abcdef01234567"
+
+func TestParseTranslations(t *testing.T) {
+	s := "AppTranslator: Test\n" + testSha1 + "\n:foo\npl:fuu\nde:fü: x\n:bar\n:line\\n2\npl:linia\\n2\n"
+	tr, err := ParseTranslations([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.App != "Test" || tr.Sha1 != testSha1 || !tr.Changed || len(tr.Strings) != 3 {
+		t.Fatalf("unexpected %#v", tr)
+	}
+	if tr.Strings[0].Translations["de"] != "fü: x" || len(tr.Strings[1].Translations) != 0 {
+		t.Fatalf("unexpected translations %#v", tr.Strings)
+	}
+	if tr.Strings[2].String != `line\n2` || tr.Strings[2].Translations["pl"] != `linia\n2` {
+		t.Fatalf("escaped newlines should be kept %#v", tr.Strings[2])
+	}
+	if langs := tr.Langs(); strings.Join(langs, ",") != "de,pl" {
+		t.Fatalf("unexpected langs %v", langs)
+	}
+
+	tr, err = ParseTranslations([]byte("AppTranslator: Test\nNo change\n"))
+	if err != nil || tr.Changed {
+		t.Fatalf("unexpected %#v, %v", tr, err)
+	}
+	invalid := []string{
+		"",
+		"foo\n" + testSha1,
+		"AppTranslator: Test\nError: no app",
+		"AppTranslator: Test\nabc",
+		"AppTranslator: Test\n" + testSha1 + "\npl:no string",
+	}
+	for _, s := range invalid {
+		if _, err = ParseTranslations([]byte(s)); err == nil {
+			t.Fatalf("parsing %q should fail", s)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	var uploaded, sha1Arg string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/uploadstrings":
+			uploaded = r.FormValue("strings")
+			fmt.Fprintf(w, "Ok")
+		case "/dltrans":
+			fmt.Fprintf(w, "AppTranslator: %s\n", r.FormValue("app"))
+			sha1Arg = r.FormValue("sha1")
+			if sha1Arg == testSha1 {
+				fmt.Fprintf(w, "No change\n")
+				return
+			}
+			fmt.Fprintf(w, "%s\n:foo\npl:fuu\n", testSha1)
+		case "/api/v1/apps/Test/langs":
+			fmt.Fprintf(w, `{"items": [{"code": "af"}]}`)
+		case "/api/v1/apps/Test/langs/af/strings":
+			if r.FormValue("page") == "1" {
+				fmt.Fprintf(w, `{"total": 3, "items": [{"string": "foo"}, {"string": "bar"}]}`)
+			} else {
+				fmt.Fprintf(w, `{"total": 3, "items": [{"string": "baz"}]}`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "Test", "tok")
+	if _, err := c.UploadStrings([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != "AppTranslator strings\nfoo\nbar" {
+		t.Fatalf("unexpected upload %q", uploaded)
+	}
+	if _, err := c.UploadStrings([]string{"foo\nbar"}); err == nil {
+		t.Fatalf("strings with newlines can't be uploaded")
+	}
+
+	tr, err := c.Download("")
+	if err != nil || !tr.Changed || tr.Strings[0].Translations["pl"] != "fuu" {
+		t.Fatalf("unexpected %#v, %v", tr, err)
+	}
+	if sha1Arg != "" {
+		t.Fatalf("download without sha1 sent sha1 %q", sha1Arg)
+	}
+	if tr, err = c.Download(tr.Sha1); err != nil || tr.Changed {
+		t.Fatalf("unexpected %#v, %v", tr, err)
+	}
+
+	strs, err := c.Strings()
+	if err != nil || strings.Join(strs, ",") != "bar,baz,foo" {
+		t.Fatalf("unexpected %v, %v", strs, err)
+	}
+	added, removed := Diff(strs, []string{"foo", "new"})
+	if strings.Join(added, ",") != "new" || strings.Join(removed, ",") != "bar,baz" {
+		t.Fatalf("unexpected diff %v %v", added, removed)
+	}
+
+	c = New(srv.URL, "Test", "bad")
+	if _, err = c.Download(""); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c = New(srv.URL, "Other", "tok")
+	if _, err = c.Strings(); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestExtractStrings(t *testing.T) {
+	src := `s = _TR("foo"); x = T("go") + _TRN("with \"quote\"") + _TR("foo")`
+	res := []*regexp.Regexp{
+		regexp.MustCompile(`\bT\("([^"]*)"\)`),
+		regexp.MustCompile(`_TRN?\("((?:[^"\\]|\\.)*)"\)`),
+	}
+	strs, err := ExtractStrings(strings.NewReader(src), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strs, "|"); got != `foo|go|with \"quote\"|foo` {
+		t.Fatalf("unexpected %s", got)
+	}
+}
+
+func TestTranslationsDelta(t *testing.T) {
+	tr, err := ParseTranslations([]byte("AppTranslator: Test\n" + testSha1 + "\n:bar\nde:bär\n:foo\npl:fuu\nde:fü\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ParseTranslationsDelta([]byte("AppTranslator: Test\nRevision: 12\nSince: 7\n:bar\nde:\n:baz\npl:bazz\n:foo\npl:fuu2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.App != "Test" || d.Revision != 12 || d.Since != 7 || len(d.Strings) != 3 {
+		t.Fatalf("unexpected %#v", d)
+	}
+	d.Apply(tr)
+	var got []string
+	for _, st := range tr.Strings {
+		for _, lang := range []string{"de", "pl"} {
+			if trans, ok := st.Translations[lang]; ok {
+				got = append(got, st.String+":"+lang+":"+trans)
+			}
+		}
+	}
+	if s := strings.Join(got, " "); s != "baz:pl:bazz foo:de:fü foo:pl:fuu2" {
+		t.Fatalf("unexpected translations after apply %s", s)
+	}
+	exp := ":baz\npl:bazz\n:foo\nde:fü\npl:fuu2\n"
+	if string(tr.Raw) != "AppTranslator: Test\n"+tr.Sha1+"\n"+exp || tr.Sha1 != fmt.Sprintf("%x", sha1.Sum([]byte(exp))) {
+		t.Fatalf("unexpected raw after apply %q", tr.Raw)
+	}
+
+	// delta since 0 replaces everything
+	d, err = ParseTranslationsDelta([]byte("AppTranslator: Test\nRevision: 3\nSince: 0\n:x\npl:y\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Apply(tr)
+	if len(tr.Strings) != 1 || tr.Strings[0].Translations["pl"] != "y" {
+		t.Fatalf("unexpected translations after apply %#v", tr.Strings)
+	}
+
+	for _, s := range []string{"", "AppTranslator: Test\nRevision: x\nSince: 0\n", "AppTranslator: Test\nRevision: 1\nSince: 0\npl:y\n"} {
+		if _, err = ParseTranslationsDelta([]byte(s)); err == nil {
+			t.Fatalf("parsing %q should fail", s)
+		}
+	}
+}
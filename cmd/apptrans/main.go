@@ -0,0 +1,426 @@
+// This code is under BSD license. See license-bsd.txt
+
+// apptrans is a command-line client for AppTranslator. It extracts strings
+// to translate from source code (see package extract), uploads them and
+// downloads translations.
+//
+//	apptrans extract [-marker name]... [-format upload|json] files or dirs...
+//	apptrans upload -app name [-dry-run] [-strings file | [-marker name]... files or dirs...]
+//	apptrans download -app name -out file [-format txt|json|csv|c]
+//
+// API token is taken from -token flag or APPTRANS_TOKEN env variable.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kjk/apptranslator/client"
+	"github.com/kjk/apptranslator/ctrans"
+	"github.com/kjk/apptranslator/extract"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// formatter serializes downloaded translations. It returns content of
+// files to write, keyed by path. out is the value of -out flag
+type formatter func(t *client.Translations, out string) (map[string][]byte, error)
+
+// outputFormats maps name of -format to its formatter
+var outputFormats = map[string]formatter{
+	"txt":  singleFile(formatTxt),
+	"json": singleFile(formatJSON),
+	"csv":  singleFile(formatCSV),
+	"c":    formatC,
+}
+
+// singleFile returns formatter for formats that write only -out
+func singleFile(fn func(*client.Translations) ([]byte, error)) formatter {
+	return func(t *client.Translations, out string) (map[string][]byte, error) {
+		d, err := fn(t)
+		return map[string][]byte{out: d}, err
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  apptrans extract [-marker name]... [-format upload|json] files or dirs...
+  apptrans upload -app name [-dry-run] [-strings file | [-marker name]... files or dirs...]
+  apptrans download -app name -out file [-format txt|json|csv|c]
+run 'apptrans <command> -h' for options of a command
+`)
+	os.Exit(2)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func fatalIfErr(err error) {
+	if err != nil {
+		fatalf("%s", err)
+	}
+}
+
+// extractFlags are flags of commands that extract strings
+type extractFlags struct {
+	markers  stringList
+	patterns stringList
+	exts     *string
+}
+
+func addExtractFlags(fs *flag.FlagSet) *extractFlags {
+	f := &extractFlags{}
+	fs.Var(&f.markers, "marker", "function or macro marking strings to translate, as `[syntax:]name` where syntax is c, go or js (can be repeated, default c:_TR c:_TRN go:T js:t)")
+	fs.Var(&f.patterns, "pattern", "instead of markers use a regexp matching a string to translate, its first group is the string (can be repeated)")
+	f.exts = fs.String("ext", "", "comma-separated extensions of files to scan in directories (default all supported)")
+	return f
+}
+
+func (f *extractFlags) extract(paths []string) ([]*extract.String, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files or directories to extract strings from")
+	}
+	exts := make(map[string]bool)
+	if *f.exts != "" {
+		for _, ext := range strings.Split(*f.exts, ",") {
+			exts[strings.TrimSpace(ext)] = true
+		}
+	} else {
+		for _, syn := range extract.Syntaxes {
+			for _, ext := range syn.Exts {
+				exts[ext] = true
+			}
+		}
+	}
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			// files given explicitly are always scanned
+			if err == nil && !fi.IsDir() && (p == path || exts[filepath.Ext(p)]) {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f.patterns) > 0 {
+		return extractWithPatterns(files, f.patterns)
+	}
+
+	e := extract.New()
+	for _, m := range f.markers {
+		syntaxes := extract.Syntaxes
+		if parts := strings.SplitN(m, ":", 2); len(parts) == 2 {
+			syn := extract.SyntaxByName(parts[0])
+			if syn == nil {
+				return nil, fmt.Errorf("unknown syntax in -marker %q", m)
+			}
+			syntaxes, m = []*extract.Syntax{syn}, parts[1]
+		}
+		for _, syn := range syntaxes {
+			e.Markers[syn.Name] = append(e.Markers[syn.Name], m)
+		}
+	}
+	for _, path := range files {
+		if err := e.AddFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return e.Strings(), nil
+}
+
+// extractWithPatterns returns unique strings matched by regexps, sorted
+func extractWithPatterns(files []string, patterns []string) ([]*extract.String, error) {
+	var res []*regexp.Regexp
+	for _, s := range patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", s, err)
+		}
+		res = append(res, re)
+	}
+	seen := make(map[string]bool)
+	var strs []*extract.String
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := client.ExtractStrings(f, res)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		for _, s := range found {
+			if !seen[s] {
+				seen[s] = true
+				strs = append(strs, &extract.String{Text: s})
+			}
+		}
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		return strs[i].Text < strs[j].Text
+	})
+	return strs, nil
+}
+
+// readStringsFile reads strings in the format of /uploadstrings
+func readStringsFile(path string) ([]string, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.Replace(string(d), "\r\n", "\n", -1), "\n")
+	if lines[0] != "AppTranslator strings" {
+		return nil, fmt.Errorf("%s: first line should be 'AppTranslator strings'", path)
+	}
+	var res []string
+	for _, s := range lines[1:] {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+// serverFlags are flags of commands that talk to the server
+type serverFlags struct {
+	server, app, token *string
+}
+
+func addServerFlags(fs *flag.FlagSet) *serverFlags {
+	return &serverFlags{
+		server: fs.String("server", client.DefaultServer, "url of AppTranslator server"),
+		app:    fs.String("app", "", "name of the app"),
+		token:  fs.String("token", os.Getenv("APPTRANS_TOKEN"), "API token of the app (default $APPTRANS_TOKEN)"),
+	}
+}
+
+func (f *serverFlags) client() *client.Client {
+	if *f.app == "" {
+		fatalf("missing -app")
+	}
+	return client.New(*f.server, *f.app, *f.token)
+}
+
+func cmdExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	ef := addExtractFlags(fs)
+	format := fs.String("format", "upload", "upload (the format of /uploadstrings) or json (with locations and notes)")
+	out := fs.String("out", "", "file to write strings to (default stdout)")
+	fs.Parse(args)
+	strs, err := ef.extract(fs.Args())
+	fatalIfErr(err)
+
+	var buf bytes.Buffer
+	switch *format {
+	case "upload":
+		err = extract.WriteUpload(&buf, strs)
+	case "json":
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(strs)
+	default:
+		fatalf("unknown -format %q", *format)
+	}
+	fatalIfErr(err)
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	fatalIfErr(ioutil.WriteFile(*out, buf.Bytes(), 0644))
+}
+
+func cmdUpload(args []string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	sf := addServerFlags(fs)
+	ef := addExtractFlags(fs)
+	stringsFile := fs.String("strings", "", "file with strings to upload, as printed by extract")
+	dryRun := fs.Bool("dry-run", false, "show strings that would be added and removed, don't upload")
+	fs.Parse(args)
+	c := sf.client()
+
+	var strs []string
+	var err error
+	if *stringsFile != "" {
+		strs, err = readStringsFile(*stringsFile)
+	} else {
+		var found []*extract.String
+		found, err = ef.extract(fs.Args())
+		for _, s := range found {
+			strs = append(strs, s.Text)
+		}
+	}
+	fatalIfErr(err)
+
+	if *dryRun {
+		cur, err := c.Strings()
+		fatalIfErr(err)
+		added, removed := client.Diff(cur, strs)
+		for _, s := range added {
+			fmt.Printf("+ %s\n", s)
+		}
+		for _, s := range removed {
+			fmt.Printf("- %s\n", s)
+		}
+		fmt.Printf("%d strings, %d added, %d removed\n", len(strs), len(added), len(removed))
+		return
+	}
+	msg, err := c.UploadStrings(strs)
+	fatalIfErr(err)
+	fmt.Println(msg)
+}
+
+func formatTxt(t *client.Translations) ([]byte, error) {
+	return t.Raw, nil
+}
+
+func formatJSON(t *client.Translations) ([]byte, error) {
+	v := struct {
+		App  string `json:"app"`
+		Sha1 string `json:"sha1"`
+		// maps string to language code to translation
+		Strings map[string]map[string]string `json:"strings"`
+	}{t.App, t.Sha1, make(map[string]map[string]string)}
+	for _, st := range t.Strings {
+		v.Strings[st.String] = st.Translations
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// formatCSV writes a row for every string with a column for every language
+func formatCSV(t *client.Translations) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	langs := t.Langs()
+	w.Write(append([]string{"string"}, langs...))
+	for _, st := range t.Strings {
+		rec := []string{st.String}
+		for _, lang := range langs {
+			rec = append(rec, st.Translations[lang])
+		}
+		w.Write(rec)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// formatC writes C source to -out and a header next to it, with the same
+// name and .h extension
+func formatC(t *client.Translations, out string) (map[string][]byte, error) {
+	headerPath := strings.TrimSuffix(out, filepath.Ext(out)) + ".h"
+	if headerPath == out {
+		return nil, fmt.Errorf("-out of c format can't have .h extension")
+	}
+	h, c, err := ctrans.Generate(t, &ctrans.Options{Header: filepath.Base(headerPath)})
+	return map[string][]byte{out: c, headerPath: h}, err
+}
+
+// readLastDownload returns revision and translations of the last download
+// written by writeLastDownload. Revision is 0 if there's no valid file
+func readLastDownload(path string) (int, *client.Translations) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, &client.Translations{}
+	}
+	parts := bytes.SplitN(d, []byte("\n"), 2)
+	rev, err := strconv.Atoi(string(parts[0]))
+	if err != nil || len(parts) != 2 {
+		return 0, &client.Translations{}
+	}
+	t, err := client.ParseTranslations(parts[1])
+	if err != nil {
+		return 0, &client.Translations{}
+	}
+	return rev, t
+}
+
+// writeLastDownload writes revision on the first line followed by
+// translations in the format of /dltrans
+func writeLastDownload(path string, rev int, t *client.Translations) error {
+	d := append([]byte(fmt.Sprintf("%d\n", rev)), t.Raw...)
+	return ioutil.WriteFile(path, d, 0644)
+}
+
+func cmdDownload(args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	sf := addServerFlags(fs)
+	out := fs.String("out", "", "file to write translations to")
+	format := fs.String("format", "txt", "format of -out: txt, json, csv or c (C source and header)")
+	force := fs.Bool("force", false, "download all translations, not only changes since the last download")
+	fs.Parse(args)
+	c := sf.client()
+	if *out == "" {
+		fatalf("missing -out")
+	}
+	formatFn := outputFormats[*format]
+	if formatFn == nil {
+		fatalf("unknown -format %q", *format)
+	}
+
+	// revision and translations of the last download are stored next to
+	// the output, so that we only download what changed since then
+	lastFile := *out + ".apptrans"
+	rev, t := 0, &client.Translations{}
+	if _, err := os.Stat(*out); err == nil && !*force {
+		rev, t = readLastDownload(lastFile)
+	}
+	delta, err := c.DownloadDelta(rev)
+	fatalIfErr(err)
+	// with Since 0 (the first download or the server doesn't know our
+	// revision) the delta is a full download and replaces what we have
+	if delta.Since != 0 && len(delta.Strings) == 0 {
+		fatalIfErr(writeLastDownload(lastFile, delta.Revision, t))
+		fmt.Printf("translations didn't change since the last download\n")
+		return
+	}
+	delta.Apply(t)
+	files, err := formatFn(t, *out)
+	fatalIfErr(err)
+	for path, d := range files {
+		fatalIfErr(ioutil.WriteFile(path, d, 0644))
+	}
+	fatalIfErr(writeLastDownload(lastFile, delta.Revision, t))
+	fmt.Printf("wrote %d strings in %d languages to %s\n", len(t.Strings), len(t.Langs()), *out)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "extract":
+		cmdExtract(args)
+	case "upload":
+		cmdUpload(args)
+	case "download":
+		cmdDownload(args)
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,200 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// responses smaller than that (when we know the size) are not worth
+// compressing
+const compressMinSize = 512
+
+// quality 5 is a good trade-off between speed and size for dynamic content
+const brotliQuality = 5
+
+// brotli.Writer.Reset() resets the quality and drops the buffers, so only
+// gzip writers are worth reusing
+var gzipWriters = sync.Pool{New: func() interface{} {
+	return gzip.NewWriter(nil)
+}}
+
+// acceptedEncoding returns the best compression we support that is accepted
+// by the client ("br" or "gzip") or "" for none
+func acceptedEncoding(r *http.Request) string {
+	gzipOk, brOk := false, false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		enc, q := part, 1.0
+		if i := strings.Index(part, ";"); i != -1 {
+			enc = strings.TrimSpace(part[:i])
+			param := strings.Replace(part[i+1:], " ", "", -1)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch strings.ToLower(enc) {
+		case "br":
+			brOk = true
+		case "gzip":
+			gzipOk = true
+		}
+	}
+	if brOk {
+		return "br"
+	}
+	if gzipOk {
+		return "gzip"
+	}
+	return ""
+}
+
+// isCompressibleType returns true for text-based content types. Images are
+// already compressed and event streams must be sent as they are written
+func isCompressibleType(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	if i := strings.Index(ct, ";"); i != -1 {
+		ct = ct[:i]
+	}
+	ct = strings.TrimSpace(ct)
+	if ct == "text/event-stream" {
+		return false
+	}
+	if strings.HasPrefix(ct, "text/") {
+		return true
+	}
+	switch ct {
+	case "application/json", "application/javascript", "application/xml",
+		"application/atom+xml", "application/rss+xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressResponseWriter compresses the response if the content type
+// (known after the handler sets the headers) is worth compressing
+type compressResponseWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	encoding string
+	// nil if the response is not compressed
+	cw          io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) shouldCompress(code int) bool {
+	h := w.Header()
+	if w.r.Method == "HEAD" || code < 200 || code == http.StatusNoContent ||
+		code == http.StatusPartialContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || !isCompressibleType(h.Get("Content-Type")) {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < compressMinSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldCompress(code) {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		// the compressed response is not byte-for-byte the same as
+		// the uncompressed, so its ETag can only be weak
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if w.encoding == "br" {
+			w.cw = brotli.NewWriterLevel(w.ResponseWriter, brotliQuality)
+		} else {
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.cw = gw
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is needed by streaming handlers (like live events)
+func (w *compressResponseWriter) Flush() {
+	switch cw := w.cw.(type) {
+	case *gzip.Writer:
+		cw.Flush()
+	case *brotli.Writer:
+		cw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// SetWriteDeadline lets streaming handlers extend server's WriteTimeout
+func (w *compressResponseWriter) SetWriteDeadline(t time.Time) error {
+	if dl, ok := w.ResponseWriter.(writeDeadliner); ok {
+		return dl.SetWriteDeadline(t)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *compressResponseWriter) close() {
+	if w.cw == nil {
+		return
+	}
+	w.cw.Close()
+	if gw, ok := w.cw.(*gzip.Writer); ok {
+		gw.Reset(nil)
+		gzipWriters.Put(gw)
+	}
+	w.cw = nil
+}
+
+// makeCompressHandler compresses responses of h with brotli or gzip,
+// depending on what the client accepts
+func makeCompressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r)
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: w, r: r, encoding: encoding}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "br",
+		"br;q=0, gzip;q=0.5":     "gzip",
+		"deflate":                "",
+		"GZIP;q=1.0, br; q=0":    "gzip",
+		"identity, *;q=0, br;q=": "",
+	}
+	for ae, exp := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", ae)
+		if got := acceptedEncoding(r); got != exp {
+			t.Errorf("acceptedEncoding(%q) is %q, exp: %q", ae, got, exp)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	body := strings.Repeat("translation ", 200)
+	h := makeCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/small":
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "small")
+			return
+		default:
+			w.Header().Set("ETag", `"x"`)
+		}
+		io.WriteString(w, body)
+	}))
+	get := func(path, ae string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", ae)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: missing Vary header", path)
+		}
+		return rr
+	}
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for enc, decode := range decoders {
+		rr := get("/", enc)
+		if rr.Header().Get("Content-Encoding") != enc || rr.Header().Get("ETag") != `W/"x"` {
+			t.Fatalf("unexpected headers %v", rr.Header())
+		}
+		if rr.Body.Len() >= len(body) {
+			t.Fatalf("%s: body wasn't compressed", enc)
+		}
+		r, err := decode(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := ioutil.ReadAll(r)
+		if err != nil || string(d) != body {
+			t.Fatalf("%s: body didn't decompress, %v", enc, err)
+		}
+	}
+	for _, path := range []string{"/png", "/small"} {
+		if rr := get(path, "gzip"); rr.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s shouldn't be compressed", path)
+		}
+	}
+	if rr := get("/", ""); rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != body {
+		t.Fatalf("response shouldn't be compressed")
+	}
+}
@@ -0,0 +1,242 @@
+// This code is under BSD license. See license-bsd.txt
+
+// Package ctrans generates C source with translations of an app, to be
+// compiled into the app like SumatraPDF does.
+//
+// For every language there's a blob compressed with zlib (use uncompress()
+// to decompress it). The blob is translations of all strings, in the order
+// of the strings array, each terminated with 0. A string that is not
+// translated has an empty translation. The first language is the language of
+// original strings and has no blob.
+//
+// The output only depends on translations, so it can be committed and
+// diffed.
+package ctrans
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/kjk/apptranslator/client"
+	"github.com/kjk/apptranslator/extract"
+	"github.com/kjk/apptranslator/store"
+)
+
+// Options change names in generated code
+type Options struct {
+	// name of the header file, #included by the source file
+	Header string
+	// prefix of C names e.g. with "Trans" the array of strings is
+	// gTransStrings and the number of strings is TRANS_STRINGS_COUNT
+	Prefix string
+	// code and name of the language of original strings
+	SourceLang     string
+	SourceLangName string
+}
+
+// DefaultOptions are used for options that are not set
+var DefaultOptions = Options{
+	Header:         "translations.h",
+	Prefix:         "Trans",
+	SourceLang:     "en",
+	SourceLangName: "English",
+}
+
+func (o *Options) withDefaults() Options {
+	res := DefaultOptions
+	if o != nil {
+		if o.Header != "" {
+			res.Header = o.Header
+		}
+		if o.Prefix != "" {
+			res.Prefix = o.Prefix
+		}
+		if o.SourceLang != "" {
+			res.SourceLang = o.SourceLang
+			res.SourceLangName = o.SourceLangName
+		}
+	}
+	return res
+}
+
+// langNameNative returns name of a language in that language
+func langNameNative(code string) string {
+	for _, lang := range store.Languages {
+		if lang.Code == code {
+			return lang.NameNative
+		}
+	}
+	return code
+}
+
+// Quote returns s as a C string literal. Non-ASCII characters are escaped
+// so that the file compiles the same regardless of compiler's source charset
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c == '?' && i+1 < len(s) && s[i+1] == '?':
+			// avoid trigraphs
+			sb.WriteString(`?\`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, `\%03o`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func compress(d []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	w.Write(d)
+	w.Close()
+	return buf.Bytes()
+}
+
+// writeBytes writes d as a C array initializer
+func writeBytes(w *bytes.Buffer, d []byte) {
+	for i, c := range d {
+		if i%16 == 0 {
+			w.WriteString("\n   ")
+		}
+		fmt.Fprintf(w, " 0x%02x,", c)
+	}
+	w.WriteString("\n")
+}
+
+// Generate returns C header and source with translations t. Strings and
+// translations are C-escaped, like they're uploaded and downloaded. Only
+// strings that have at least one translation are downloaded, so an app
+// should show strings that are not in the strings array untranslated
+func Generate(t *client.Translations, opts *Options) (header []byte, source []byte, err error) {
+	o := opts.withDefaults()
+	langs := t.Langs()
+	strs := make([]string, len(t.Strings))
+	// maps language to translations as stored in the blob
+	blobs := make(map[string]*bytes.Buffer)
+	for _, lang := range langs {
+		blobs[lang] = &bytes.Buffer{}
+	}
+	for i, st := range t.Strings {
+		if strs[i], err = extract.Unescape(st.String); err != nil {
+			return nil, nil, fmt.Errorf("invalid string: %s", err)
+		}
+		for _, lang := range langs {
+			trans, ok := st.Translations[lang]
+			if ok {
+				if trans, err = extract.Unescape(trans); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s translation of %q: %s", lang, st.String, err)
+				}
+				if strings.IndexByte(trans, 0) >= 0 {
+					return nil, nil, fmt.Errorf("%s translation of %q has a 0 byte", lang, st.String)
+				}
+			}
+			blobs[lang].WriteString(trans)
+			blobs[lang].WriteByte(0)
+		}
+	}
+
+	prefix := strings.ToUpper(o.Prefix)
+	name := func(s string) string {
+		return "g" + o.Prefix + s
+	}
+	comment := fmt.Sprintf("// Generated by apptrans from translations of %s. DO NOT EDIT.\n", t.App)
+	if t.Sha1 != "" {
+		comment += fmt.Sprintf("// Translations sha1: %s\n", t.Sha1)
+	}
+	guard := "APPTRANS_" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, filepath.Base(o.Header))
+	nLangs := len(langs) + 1
+
+	var h bytes.Buffer
+	h.WriteString(comment)
+	fmt.Fprintf(&h, "\n#ifndef %s\n#define %s\n\n", guard, guard)
+	fmt.Fprintf(&h, "#define %s_LANGS_COUNT %d\n", prefix, nLangs)
+	fmt.Fprintf(&h, "#define %s_STRINGS_COUNT %d\n\n", prefix, len(strs))
+	fmt.Fprintf(&h, "// codes and native names of languages, the first is the language of strings\n")
+	fmt.Fprintf(&h, "extern const char *%s[%s_LANGS_COUNT];\n", name("LangCodes"), prefix)
+	fmt.Fprintf(&h, "extern const char *%s[%s_LANGS_COUNT];\n\n", name("LangNames"), prefix)
+	fmt.Fprintf(&h, "// strings that have translations, sorted\n")
+	fmt.Fprintf(&h, "extern const char *%s[];\n\n", name("Strings"))
+	fmt.Fprintf(&h, "// per language: %s_STRINGS_COUNT 0-terminated translations (empty if\n", prefix)
+	fmt.Fprintf(&h, "// not translated), compressed with zlib. NULL for the first language\n")
+	fmt.Fprintf(&h, "extern const unsigned char *%s[%s_LANGS_COUNT];\n", name("Data"), prefix)
+	fmt.Fprintf(&h, "extern const unsigned int %s[%s_LANGS_COUNT];\n", name("DataSize"), prefix)
+	fmt.Fprintf(&h, "extern const unsigned int %s[%s_LANGS_COUNT];\n", name("DataUncompressedSize"), prefix)
+	fmt.Fprintf(&h, "\n#endif\n")
+
+	var c bytes.Buffer
+	c.WriteString(comment)
+	fmt.Fprintf(&c, "\n#include <stddef.h>\n#include %s\n", Quote(filepath.Base(o.Header)))
+
+	fmt.Fprintf(&c, "\nconst char *%s[%s_LANGS_COUNT] = {\n", name("LangCodes"), prefix)
+	fmt.Fprintf(&c, "    %s,\n", Quote(o.SourceLang))
+	for _, lang := range langs {
+		fmt.Fprintf(&c, "    %s,\n", Quote(lang))
+	}
+	fmt.Fprintf(&c, "};\n\nconst char *%s[%s_LANGS_COUNT] = {\n", name("LangNames"), prefix)
+	fmt.Fprintf(&c, "    %s,\n", Quote(o.SourceLangName))
+	for _, lang := range langs {
+		fmt.Fprintf(&c, "    %s,\n", Quote(langNameNative(lang)))
+	}
+	fmt.Fprintf(&c, "};\n\nconst char *%s[] = {\n", name("Strings"))
+	for _, s := range strs {
+		fmt.Fprintf(&c, "    %s,\n", Quote(s))
+	}
+	if len(strs) == 0 {
+		// an empty initializer list isn't valid C
+		fmt.Fprintf(&c, "    NULL,\n")
+	}
+	c.WriteString("};\n")
+
+	var sizes, uncompressedSizes []string
+	for _, lang := range langs {
+		d := blobs[lang].Bytes()
+		compressed := compress(d)
+		sizes = append(sizes, fmt.Sprintf("%d", len(compressed)))
+		uncompressedSizes = append(uncompressedSizes, fmt.Sprintf("%d", len(d)))
+		fmt.Fprintf(&c, "\n// %s\nstatic const unsigned char %s[%d] = {", lang, langDataName(name("Data"), lang), len(compressed))
+		writeBytes(&c, compressed)
+		c.WriteString("};\n")
+	}
+
+	fmt.Fprintf(&c, "\nconst unsigned char *%s[%s_LANGS_COUNT] = {\n    NULL,\n", name("Data"), prefix)
+	for _, lang := range langs {
+		fmt.Fprintf(&c, "    %s,\n", langDataName(name("Data"), lang))
+	}
+	fmt.Fprintf(&c, "};\n\nconst unsigned int %s[%s_LANGS_COUNT] = {\n    0,\n", name("DataSize"), prefix)
+	for _, s := range sizes {
+		fmt.Fprintf(&c, "    %s,\n", s)
+	}
+	fmt.Fprintf(&c, "};\n\nconst unsigned int %s[%s_LANGS_COUNT] = {\n    0,\n", name("DataUncompressedSize"), prefix)
+	for _, s := range uncompressedSizes {
+		fmt.Fprintf(&c, "    %s,\n", s)
+	}
+	c.WriteString("};\n")
+	return h.Bytes(), c.Bytes(), nil
+}
+
+// langDataName returns name of the array with data of lang e.g. gTransData_ca_xv
+func langDataName(prefix, lang string) string {
+	return prefix + "_" + strings.Replace(lang, "-", "_", -1)
+}
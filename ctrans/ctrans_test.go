@@ -0,0 +1,108 @@
+package ctrans
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kjk/apptranslator/client"
+)
+
+const testTranslations = `AppTranslator: Test App
+0123456789abcdef0123456789abcdef01234567
+:&Open
+de:Ö&ffnen
+pl:&Otwórz
+:Page %d\nof %d
+pl:Strona %d\nz %d
+:Really??
+ca-xv:De veres??
+`
+
+// decodeData returns translations of lang in the generated source
+func decodeData(t *testing.T, src, lang string) []string {
+	re := regexp.MustCompile(`(?s)static const unsigned char gTransData_` + lang + `\[\d+\] = \{(.*?)\};`)
+	m := re.FindStringSubmatch(src)
+	if m == nil {
+		t.Fatalf("no data of %s in %s", lang, src)
+	}
+	var d []byte
+	for _, s := range strings.Fields(strings.Replace(m[1], ",", " ", -1)) {
+		n, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d = append(d, byte(n))
+	}
+	r, err := zlib.NewReader(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(d), "\x00")
+}
+
+func TestGenerate(t *testing.T) {
+	tr, err := client.ParseTranslations([]byte(testTranslations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, c, err := Generate(tr, &Options{Header: "Translations_txt.h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, src := string(h), string(c)
+	for _, s := range []string{
+		"#ifndef APPTRANS_TRANSLATIONS_TXT_H",
+		"#define TRANS_LANGS_COUNT 4\n",
+		"#define TRANS_STRINGS_COUNT 3\n",
+		"extern const unsigned char *gTransData[TRANS_LANGS_COUNT];",
+	} {
+		if !strings.Contains(header, s) {
+			t.Fatalf("%q not in header %s", s, header)
+		}
+	}
+	for _, s := range []string{
+		"// Translations sha1: 0123456789abcdef0123456789abcdef01234567\n",
+		"#include \"Translations_txt.h\"\n",
+		"    \"en\",\n    \"ca-xv\",\n    \"de\",\n    \"pl\",\n",
+		"    \"English\",\n    \"Catal\\303\\240-Valenci\\303\\240\",\n    \"Deutsch\",\n    \"Polski\",\n",
+		"    \"&Open\",\n    \"Page %d\\nof %d\",\n    \"Really?\\?\",\n",
+		"gTransData_ca_xv[",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("%q not in source %s", s, src)
+		}
+	}
+	// untranslated strings have empty translation, the last one is
+	// followed by 0
+	if got := decodeData(t, src, "pl"); strings.Join(got, "|") != "&Otwórz|Strona %d\nz %d||" {
+		t.Fatalf("unexpected pl translations %q", got)
+	}
+	if got := decodeData(t, src, "ca_xv"); strings.Join(got, "|") != "||De veres??|" {
+		t.Fatalf("unexpected ca-xv translations %q", got)
+	}
+
+	h2, c2, _ := Generate(tr, &Options{Header: "Translations_txt.h"})
+	if !bytes.Equal(h, h2) || !bytes.Equal(c, c2) {
+		t.Fatalf("output should be deterministic")
+	}
+
+	tr.Strings[0].Translations["pl"] = `bad\q`
+	if _, _, err = Generate(tr, nil); err == nil {
+		t.Fatalf("invalid escape should fail")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if s := Quote("a\"b\\c\n\td\x01é??="); s != `"a\"b\\c\n\td\001\303\251?\?="` {
+		t.Fatalf("unexpected %s", s)
+	}
+}
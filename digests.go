@@ -0,0 +1,400 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/kjk/apptranslator/store"
+)
+
+// Email digests. Users subscribe to a language of an app and get a daily
+// or weekly email with strings that are new and untranslated and edits
+// made by others. Admins can subscribe to a digest of uploads and flagged
+// edits of an app. Digests need BaseURL in config for links.
+
+// how often we check which digests are due
+const digestCheckInterval = time.Hour
+
+// we don't list more items of each kind in a digest
+const digestMaxItems = 50
+
+// verifiedEmail returns email of user's local account if it's verified
+func verifiedEmail(user string) string {
+	provider, name := splitUserIdentity(user)
+	if provider != localProviderName {
+		return ""
+	}
+	if acc := userStore.GetAccount(name); acc != nil && acc.Verified {
+		return acc.Email
+	}
+	return ""
+}
+
+// digestURL returns absolute url of path. Digests are sent outside of
+// requests, so they use BaseURL
+func digestURL(path string) string {
+	return absoluteURL(nil, path)
+}
+
+func unsubscribeURL(sub *store.Subscription) string {
+	return digestURL("/unsubscribe?id=" + url.QueryEscape(sub.ID))
+}
+
+func subscriptionName(sub *store.Subscription) string {
+	if sub.Lang == "" {
+		return fmt.Sprintf("%s admin", sub.App)
+	}
+	return fmt.Sprintf("%s %s", sub.App, store.LangNameByCode(sub.Lang))
+}
+
+// writeList writes up to digestMaxItems items
+func writeList(b *bytes.Buffer, items []string) {
+	for i, s := range items {
+		if i == digestMaxItems {
+			fmt.Fprintf(b, "  ... and %d more\n", len(items)-digestMaxItems)
+			break
+		}
+		fmt.Fprintf(b, "  %s\n", s)
+	}
+	b.WriteString("\n")
+}
+
+// buildLangDigest returns body of a digest of changes in sub.Lang since
+// a given time or "" if nothing changed
+func buildLangDigest(app *App, sub *store.Subscription, since time.Time) string {
+	li := findLangInfo(app.store.LangInfos(), sub.Lang)
+	if li == nil {
+		return ""
+	}
+	untranslated := make(map[string]bool)
+	for _, tr := range li.ActiveStrings {
+		if !tr.IsTranslated() {
+			untranslated[tr.String] = true
+		}
+	}
+	var newStrings []string
+	for _, added := range app.store.RecentlyAddedStrings(digestMaxItems) {
+		if !added.Time.After(since) {
+			break
+		}
+		for _, s := range added.Strings {
+			if untranslated[s] {
+				newStrings = append(newStrings, s)
+				// a string can be added more than once
+				delete(untranslated, s)
+			}
+		}
+	}
+	var edits []string
+	for _, e := range app.store.EditsForLang(sub.Lang, -1) {
+		if !e.Time.After(since) {
+			break
+		}
+		if e.User != sub.User {
+			edits = append(edits, fmt.Sprintf("%s: '%s' as '%s'", e.User, e.Text, e.Translation))
+		}
+	}
+	if len(newStrings) == 0 && len(edits) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	if len(newStrings) > 0 {
+		fmt.Fprintf(&b, "New strings to translate:\n\n")
+		writeList(&b, newStrings)
+	}
+	if len(edits) > 0 {
+		fmt.Fprintf(&b, "Translations by others:\n\n")
+		writeList(&b, edits)
+	}
+	fmt.Fprintf(&b, "%d strings are not translated. Translate them at:\n%s\n",
+		li.UntranslatedCount(), digestURL(fmt.Sprintf("/app/%s/%s/translate", url.PathEscape(app.Name), sub.Lang)))
+	return b.String()
+}
+
+// buildAdminDigest returns body of a digest of uploads and flagged edits
+// since a given time or "" if there were none
+func buildAdminDigest(app *App, since time.Time) string {
+	var uploads []string
+	for _, up := range app.store.RecentUploads(digestMaxItems) {
+		if !up.Time.After(since) {
+			break
+		}
+		uploads = append(uploads, fmt.Sprintf("%s %s by %s (%s)", up.Time.UTC().Format("2006-01-02 15:04"), up.What, up.By, up.Details))
+	}
+	var flags []string
+	for _, f := range massEdits.recentFlags() {
+		if f.App == app.Name && f.Time.After(since) {
+			flags = append(flags, fmt.Sprintf("%s %s overwrote %d translations in %s", f.Time.UTC().Format("2006-01-02 15:04"), f.User, f.Count, f.Lang))
+		}
+	}
+	if len(uploads) == 0 && len(flags) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	if len(uploads) > 0 {
+		fmt.Fprintf(&b, "Uploads:\n\n")
+		writeList(&b, uploads)
+	}
+	if len(flags) > 0 {
+		fmt.Fprintf(&b, "Users flagged for overwriting many translations:\n\n")
+		writeList(&b, flags)
+	}
+	fmt.Fprintf(&b, "See %s\n", digestURL("/admin"))
+	return b.String()
+}
+
+// sendDigest sends a digest of changes since the last one if there were
+// any. Returns false if it wasn't sent and should be retried
+func sendDigest(sub *store.Subscription) bool {
+	app := findApp(sub.App)
+	if app == nil {
+		return true
+	}
+	var body string
+	if sub.Lang == "" {
+		if !userIsAdmin(app, sub.User) {
+			return true
+		}
+		body = buildAdminDigest(app, sub.Since())
+	} else {
+		body = buildLangDigest(app, sub, sub.Since())
+	}
+	if body == "" {
+		return true
+	}
+	unsubscribe := unsubscribeURL(sub)
+	body = fmt.Sprintf("Changes in %s since %s:\n\n%s\n--\nYou get this %s digest because you subscribed on AppTranslator.\nUnsubscribe: %s\n",
+		subscriptionName(sub), sub.Since().UTC().Format("2006-01-02 15:04 MST"), body, sub.Frequency, unsubscribe)
+	subject := fmt.Sprintf("AppTranslator %s digest: %s", sub.Frequency, subscriptionName(sub))
+	err := mailSender.SendMail(sub.Email, subject, body,
+		mailHeader{"List-Unsubscribe", "<" + unsubscribe + ">"},
+		mailHeader{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"})
+	if err != nil {
+		logger.Errorf("Failed to send %s digest to %s: %s", subscriptionName(sub), sub.Email, err)
+		return false
+	}
+	return true
+}
+
+// sendDigests sends digests that are due at time now
+func sendDigests(now time.Time) {
+	for _, sub := range userStore.Subscriptions("") {
+		if !sub.IsDue(now) || !sendDigest(sub) {
+			continue
+		}
+		if err := userStore.MarkSubscriptionSent(sub.ID, now); err != nil {
+			logger.Errorf("MarkSubscriptionSent() failed with %s", err)
+		}
+	}
+}
+
+func digestLoop() {
+	for {
+		sendDigests(time.Now())
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+// ModelSubscriptions describes /subscriptions page
+type ModelSubscriptions struct {
+	PageTitle     string
+	User          string
+	RedirectUrl   string
+	CsrfToken     string
+	Message       string
+	Subscriptions []*store.Subscription
+	// app and language of a new subscription, if given in the url
+	App      *App
+	Lang     string
+	LangName string
+	Email    string
+}
+
+func redirectToSubscriptions(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/subscriptions?msg="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// url: /subscriptions[?app=${app}&lang=${lang}][&msg=${msg}]
+// lists user's subscriptions. If app is given, shows a form for subscribing
+// to a digest of lang or, if lang is not given, an admin digest
+func handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user := decodeUserFromCookie(r)
+	if user == "" {
+		http.Redirect(w, r, "/login?redirect="+url.QueryEscape(r.URL.String()), http.StatusFound)
+		return
+	}
+	model := &ModelSubscriptions{
+		PageTitle:     "Email digests",
+		User:          user,
+		RedirectUrl:   r.URL.String(),
+		CsrfToken:     csrfToken(r),
+		Message:       r.FormValue("msg"),
+		Subscriptions: userStore.Subscriptions(user),
+		Email:         verifiedEmail(user),
+	}
+	if appName := strings.TrimSpace(r.FormValue("app")); appName != "" {
+		if model.App = getAppArg(w, r); model.App == nil {
+			return
+		}
+		model.Lang = strings.TrimSpace(r.FormValue("lang"))
+		if model.Lang != "" && !store.IsValidLangCode(model.Lang) {
+			httpErrorf(w, "Language %q is not valid", model.Lang)
+			return
+		}
+		model.LangName = store.LangNameByCode(model.Lang)
+	}
+	ExecTemplate(w, tmplSubscriptions, model)
+}
+
+func sendSubscriptionConfirmation(sub *store.Subscription) error {
+	link := digestURL("/subscribe/confirm?id=" + url.QueryEscape(sub.ID))
+	body := fmt.Sprintf(`Hello %s,
+
+Please confirm that you want to get %s email digests of %s changes on
+AppTranslator by opening this link:
+
+%s
+
+If you didn't ask for it, ignore this email.
+`, sub.User, sub.Frequency, subscriptionName(sub), link)
+	return mailSender.SendMail(sub.Email, "Confirm your AppTranslator subscription", body)
+}
+
+// url: POST /subscribe
+// app, lang ("" for admin digest), frequency, email
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if user == "" {
+		httpErrorf(w, "You must be logged in to subscribe")
+		return
+	}
+	if !checkRateLimit(w, r, user) {
+		return
+	}
+	app := getAppArg(w, r)
+	if app == nil {
+		return
+	}
+	lang := strings.TrimSpace(r.FormValue("lang"))
+	if lang == "" && !userIsAdmin(app, user) {
+		httpErrorf(w, "You're not an admin of %s", app.Name)
+		return
+	}
+	if lang != "" && !store.IsValidLangCode(lang) {
+		httpErrorf(w, "Language %q is not valid", lang)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	// no need to confirm an email we already know belongs to the user
+	confirmed := email != "" && strings.EqualFold(email, verifiedEmail(user))
+	if !confirmed {
+		if !emailRx.MatchString(email) {
+			httpErrorf(w, "Invalid email address %q", email)
+			return
+		}
+		// links in emails can't be built from Host header, which can be
+		// spoofed
+		if config.BaseURL == "" {
+			httpErrorf(w, "Can't send confirmation email, only the verified email of your account can be used")
+			return
+		}
+		// don't let users flood an address with confirmation emails
+		if !emailLimiter.allow(strings.ToLower(email), time.Now()) {
+			logger.Noticef("Rate limited confirmation emails to %s, requested by %s", email, user)
+			writeTooManyRequests(w, "Too many confirmation emails sent to this address, try again later")
+			return
+		}
+	}
+	id := fmt.Sprintf("%x", securecookie.GenerateRandomKey(16))
+	sub, err := userStore.CreateSubscription(id, user, email, app.Name, lang, r.FormValue("frequency"), confirmed)
+	if err != nil {
+		httpErrorf(w, "Failed to subscribe: %s", err)
+		return
+	}
+	logger.Noticef("%s subscribed to %s %s digest", user, sub.Frequency, subscriptionName(sub))
+	if confirmed {
+		redirectToSubscriptions(w, r, fmt.Sprintf("Subscribed to %s digest", subscriptionName(sub)))
+		return
+	}
+	if err = sendSubscriptionConfirmation(sub); err != nil {
+		logger.Errorf("Failed to send subscription confirmation to %s: %s", sub.Email, err)
+		httpErrorf(w, "Failed to send confirmation email")
+		return
+	}
+	redirectToSubscriptions(w, r, fmt.Sprintf("Sent confirmation email to %s", sub.Email))
+}
+
+// ModelUnsubscribe describes /unsubscribe and /subscribe/confirm pages
+type ModelUnsubscribe struct {
+	PageTitle    string
+	Subscription *store.Subscription
+	Name         string
+	// true after unsubscribing or confirming
+	Done bool
+}
+
+// url: GET, POST /subscribe/confirm?id=${id}
+// Like /unsubscribe, GET shows a page with a button and POST confirms, so
+// that link scanners of mail providers don't confirm subscriptions
+func handleSubscribeConfirm(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	sub := userStore.GetSubscription(id)
+	if sub == nil {
+		httpErrorf(w, "Subscription doesn't exist, maybe you've unsubscribed")
+		return
+	}
+	model := &ModelUnsubscribe{
+		PageTitle:    "Confirm subscription",
+		Subscription: sub,
+		Name:         subscriptionName(sub),
+		Done:         sub.Confirmed,
+	}
+	if r.Method == "POST" && !sub.Confirmed {
+		var err error
+		if sub, err = userStore.ConfirmSubscription(id); err != nil {
+			httpErrorf(w, "Failed to confirm subscription: %s", err)
+			return
+		}
+		logger.Noticef("%s confirmed %s digest", sub.User, model.Name)
+		model.Subscription = sub
+		model.Done = true
+	}
+	ExecTemplate(w, tmplSubscribeConfirm, model)
+}
+
+// url: GET, POST /unsubscribe?id=${id}
+// The id is secret, so it doesn't need CSRF token or being logged in. GET
+// shows a page with a single button (so that link scanners of mail
+// providers don't unsubscribe), POST unsubscribes. Mail clients that support
+// List-Unsubscribe-Post (RFC 8058) POST directly.
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	sub := userStore.GetSubscription(id)
+	if sub == nil {
+		httpErrorf(w, "Subscription doesn't exist, maybe you've already unsubscribed")
+		return
+	}
+	model := &ModelUnsubscribe{
+		PageTitle:    "Unsubscribe",
+		Subscription: sub,
+		Name:         subscriptionName(sub),
+	}
+	if r.Method == "POST" {
+		if err := userStore.DeleteSubscription(id); err != nil {
+			httpErrorf(w, "Failed to unsubscribe: %s", err)
+			return
+		}
+		logger.Noticef("%s unsubscribed from %s digest", sub.User, model.Name)
+		model.Done = true
+	}
+	ExecTemplate(w, tmplUnsubscribe, model)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigests(t *testing.T) {
+	defer setupTestUserStore(t, "digeststest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "DigestTest", "digestsapptest.dat")
+	defer cleanup()
+	config.BaseURL = "https://example.org"
+	defer func() { config.BaseURL = "" }()
+	m := &stubMailer{}
+	mailSender = m
+
+	lang, err := userStore.CreateSubscription("s1", "alice", "alice@example.org", app.Name, "pl", "daily", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := userStore.CreateSubscription("s2", "kjk", "kjk@example.org", app.Name, "", "weekly", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = userStore.CreateSubscription("s3", "bob", "bob@example.org", app.Name, "pl", "daily", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = app.store.UpdateStringsList([]string{"foo", "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteUpload("kjk", "strings", "2 strings"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteNewTranslation("foo", "fu", "pl", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteNewTranslation("foo", "fuu", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// not due yet
+	sendDigests(time.Now())
+	if len(m.Sent) != 0 {
+		t.Fatalf("sent %d digests before they're due", len(m.Sent))
+	}
+
+	now := time.Now().Add(25 * time.Hour)
+	sendDigests(now)
+	if len(m.Sent) != 1 {
+		t.Fatalf("sent %d digests, expected 1", len(m.Sent))
+	}
+	msg := m.Sent[0]
+	if msg.To != lang.Email {
+		t.Fatalf("digest sent to %s", msg.To)
+	}
+	if !strings.Contains(msg.Body, "bar") || !strings.Contains(msg.Body, "carol: 'foo' as 'fu'") {
+		t.Fatalf("unexpected digest body:\n%s", msg.Body)
+	}
+	// own edits are not included and "foo" is translated
+	if strings.Contains(msg.Body, "fuu") || strings.Contains(msg.Body, "  foo\n") {
+		t.Fatalf("unexpected digest body:\n%s", msg.Body)
+	}
+	unsubscribe := ""
+	for _, hdr := range msg.Headers {
+		if hdr.Name == "List-Unsubscribe" {
+			unsubscribe = strings.Trim(hdr.Value, "<>")
+		}
+	}
+	if unsubscribe != "https://example.org/unsubscribe?id=s1" {
+		t.Fatalf("List-Unsubscribe is %q", unsubscribe)
+	}
+	if sub := userStore.GetSubscription("s1"); !sub.LastSent.Equal(now) {
+		t.Fatalf("LastSent is %s, expected %s", sub.LastSent, now)
+	}
+
+	// nothing new since the last one
+	sendDigests(now.Add(25 * time.Hour))
+	if len(m.Sent) != 1 {
+		t.Fatalf("sent %d digests, expected 1", len(m.Sent))
+	}
+
+	sendDigests(now.Add(8 * 24 * time.Hour))
+	if len(m.Sent) != 2 || m.Sent[1].To != admin.Email {
+		t.Fatalf("admin digest not sent: %#v", m.Sent)
+	}
+	if !strings.Contains(m.Sent[1].Body, "strings by kjk (2 strings)") {
+		t.Fatalf("unexpected admin digest body:\n%s", m.Sent[1].Body)
+	}
+
+	// GET only shows a page, POST unsubscribes
+	u, _ := url.Parse(unsubscribe)
+	rr := getURL(h, u.RequestURI())
+	if rr.Code != 200 || userStore.GetSubscription("s1") == nil {
+		t.Fatalf("GET /unsubscribe returned %d", rr.Code)
+	}
+	rr = postForm(h, "/unsubscribe", url.Values{"id": {"s1"}}, nil)
+	if rr.Code != 200 || userStore.GetSubscription("s1") != nil {
+		t.Fatalf("POST /unsubscribe returned %d, %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	defer setupTestUserStore(t, "subscribetest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "SubscribeTest", "subscribeapptest.dat")
+	defer cleanup()
+	m := &stubMailer{}
+	mailSender = m
+	config.BaseURL = "https://example.org"
+	emailLimiter = newRateLimiterPer(1, time.Hour, 1)
+	defer func() {
+		config.BaseURL = ""
+		emailLimiter = nil
+	}()
+
+	cookies := loginTestUser(t, "alice", false)
+	vals := url.Values{
+		"csrf":      {csrfFromCookies(cookies)},
+		"app":       {app.Name},
+		"lang":      {"pl"},
+		"frequency": {"weekly"},
+		"email":     {"alice@example.org"},
+	}
+	rr := postForm(h, "/subscribe", vals, cookies)
+	if rr.Code != 302 {
+		t.Fatalf("/subscribe returned %d, %s", rr.Code, rr.Body.String())
+	}
+	subs := userStore.Subscriptions("alice")
+	if len(subs) != 1 || subs[0].Confirmed {
+		t.Fatalf("unexpected subscriptions %#v", subs)
+	}
+	if !strings.Contains(m.Sent[0].Body, "https://example.org/subscribe/confirm?") {
+		t.Fatalf("confirmation link should use BaseURL: %s", m.Sent[0].Body)
+	}
+	// GET only shows a page, POST confirms
+	rr = getURL(h, lastMailLink(t, m, "alice@example.org"))
+	if rr.Code != 200 || userStore.GetSubscription(subs[0].ID).Confirmed {
+		t.Fatalf("GET /subscribe/confirm returned %d, %s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(h, "/subscribe/confirm", url.Values{"id": {subs[0].ID}}, nil)
+	if rr.Code != 200 || !userStore.GetSubscription(subs[0].ID).Confirmed {
+		t.Fatalf("confirming returned %d, %s", rr.Code, rr.Body.String())
+	}
+
+	// confirmation emails to an address are rate limited
+	vals.Set("email", "alice@example.org")
+	if rr = postForm(h, "/subscribe", vals, cookies); rr.Code != http.StatusTooManyRequests || len(m.Sent) != 1 {
+		t.Fatalf("/subscribe returned %d and sent %d emails", rr.Code, len(m.Sent))
+	}
+	vals.Set("email", "not an email")
+	if rr = postForm(h, "/subscribe", vals, cookies); rr.Code != http.StatusBadRequest {
+		t.Fatalf("/subscribe with invalid email returned %d", rr.Code)
+	}
+	vals.Set("email", "alice@example.org")
+
+	// only admins can subscribe to the admin digest
+	vals.Set("lang", "")
+	rr = postForm(h, "/subscribe", vals, cookies)
+	if rr.Code == 302 || len(userStore.Subscriptions("alice")) != 1 {
+		t.Fatalf("non-admin subscribed to admin digest")
+	}
+}
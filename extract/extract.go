@@ -0,0 +1,607 @@
+// This code is under BSD license. See license-bsd.txt
+
+// Package extract finds translatable strings in C/C++, Go and JavaScript
+// source code.
+//
+// A translatable string is a string literal that is the first argument of
+// a marker i.e. a function or macro like _TR("Open") in C, T("Open") in Go
+// or t('Open') in JavaScript. A comment right above the marker (or before it
+// on the same line) is a note for translators.
+package extract
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Syntax describes lexical rules of a programming language that matter for
+// extracting strings
+type Syntax struct {
+	Name string
+	// extensions of source files, with a dot
+	Exts []string
+	// characters that start and end a string literal
+	Quotes string
+	// characters that start and end a raw string literal, without escapes
+	RawQuotes string
+	// if true, adjacent string literals are concatenated like in C
+	Concat bool
+	// markers used if Extractor doesn't set any for this syntax
+	DefaultMarkers []string
+}
+
+var (
+	// C is for C and C++. Char literals are skipped as strings
+	C = &Syntax{
+		Name:           "c",
+		Exts:           []string{".c", ".cc", ".cpp", ".cxx", ".h", ".hpp"},
+		Quotes:         `"'`,
+		Concat:         true,
+		DefaultMarkers: []string{"_TR", "_TRN"},
+	}
+	// Go source code
+	Go = &Syntax{
+		Name:           "go",
+		Exts:           []string{".go"},
+		Quotes:         `"'`,
+		RawQuotes:      "`",
+		DefaultMarkers: []string{"T"},
+	}
+	// JS is for JavaScript. Template literals are only extracted if they
+	// have no substitutions
+	JS = &Syntax{
+		Name:           "js",
+		Exts:           []string{".js", ".jsx", ".mjs", ".ts", ".tsx"},
+		Quotes:         "\"'`",
+		DefaultMarkers: []string{"t"},
+	}
+
+	// Syntaxes are all supported syntaxes
+	Syntaxes = []*Syntax{C, Go, JS}
+)
+
+// SyntaxForFile returns syntax of a file based on its extension or nil
+func SyntaxForFile(path string) *Syntax {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, syn := range Syntaxes {
+		for _, e := range syn.Exts {
+			if e == ext {
+				return syn
+			}
+		}
+	}
+	return nil
+}
+
+// SyntaxByName returns syntax with a given name or nil
+func SyntaxByName(name string) *Syntax {
+	for _, syn := range Syntaxes {
+		if syn.Name == name {
+			return syn
+		}
+	}
+	return nil
+}
+
+// Location is where a string was found
+type Location struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// String is a translatable string with all places it was found at
+type String struct {
+	// C-escaped, as uploaded to the server (e.g. a newline is \n)
+	Text      string     `json:"string"`
+	Locations []Location `json:"locations"`
+	// notes for translators, from comments
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Error is a problem with source code at a given location
+type Error struct {
+	Location
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Location, e.Msg)
+}
+
+// Extractor collects strings from many files
+type Extractor struct {
+	// maps syntax name to names of markers. If not set for a syntax, its
+	// DefaultMarkers are used
+	Markers map[string][]string
+
+	strs map[string]*String
+}
+
+// New returns an extractor using default markers
+func New() *Extractor {
+	return &Extractor{
+		Markers: make(map[string][]string),
+		strs:    make(map[string]*String),
+	}
+}
+
+func (e *Extractor) markers(syn *Syntax) []string {
+	if m, ok := e.Markers[syn.Name]; ok {
+		return m
+	}
+	return syn.DefaultMarkers
+}
+
+// AddFile extracts strings from a file. Files with unknown extension are
+// ignored
+func (e *Extractor) AddFile(path string) error {
+	syn := SyntaxForFile(path)
+	if syn == nil {
+		return nil
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return e.Add(path, src, syn)
+}
+
+// Add extracts strings from src. path is only used for locations
+func (e *Extractor) Add(path string, src []byte, syn *Syntax) error {
+	found, err := Extract(path, src, syn, e.markers(syn))
+	if err != nil {
+		return err
+	}
+	for _, f := range found {
+		s := e.strs[f.Text]
+		if s == nil {
+			s = &String{Text: f.Text}
+			e.strs[f.Text] = s
+		}
+		s.Locations = append(s.Locations, f.Locations...)
+	outer:
+		for _, note := range f.Notes {
+			for _, n := range s.Notes {
+				if n == note {
+					continue outer
+				}
+			}
+			s.Notes = append(s.Notes, note)
+		}
+	}
+	return nil
+}
+
+// Strings returns strings found so far, sorted
+func (e *Extractor) Strings() []*String {
+	res := make([]*String, 0, len(e.strs))
+	for _, s := range e.strs {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+// WriteUpload writes strings in the format expected by /uploadstrings.
+// There's no newline at the end because the server rejects empty lines
+func WriteUpload(w io.Writer, strs []*String) error {
+	_, err := io.WriteString(w, "AppTranslator strings")
+	for _, s := range strs {
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n"+s.Text)
+	}
+	return err
+}
+
+// Extract returns strings marked by markers in src, in order of appearance.
+// Each string has one location
+func Extract(path string, src []byte, syn *Syntax, markers []string) ([]*String, error) {
+	isMarker := make(map[string]bool)
+	for _, m := range markers {
+		isMarker[m] = true
+	}
+	l := &lexer{src: src, syn: syn, path: path, line: 1}
+	var res []*String
+	// the last comment that is alone on its line(s). It's a note for
+	// markers on the line it ends on or on the next line
+	var note string
+	noteEnd := -1
+	noteIsLineComment := false
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.kind {
+		case tokEOF:
+			return res, nil
+		case tokComment:
+			if !tok.ownLine {
+				// a comment after code is about that code
+				note = ""
+			} else if tok.lineComment && noteIsLineComment && noteEnd == tok.line-1 {
+				// consecutive // comments are one note
+				note += "\n" + tok.text
+			} else {
+				note = tok.text
+			}
+			noteEnd, noteIsLineComment = l.line, tok.lineComment
+		case tokIdent:
+			if !isMarker[tok.text] {
+				continue
+			}
+			s, err := l.markerArg()
+			if err != nil {
+				return nil, err
+			}
+			if s == nil {
+				continue
+			}
+			if note != "" && (noteEnd == tok.line-1 || noteEnd == tok.line) {
+				s.Notes = []string{note}
+			}
+			res = append(res, s)
+		}
+	}
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokComment
+	tokOther
+)
+
+type token struct {
+	kind int
+	text string
+	line int
+	// for comments: true if only whitespace precedes it on its line
+	ownLine     bool
+	lineComment bool
+	// for strings: false for JS template literals with substitutions
+	constant bool
+}
+
+type lexer struct {
+	src  []byte
+	pos  int
+	line int
+	syn  *Syntax
+	path string
+	// line of the last token that isn't a comment
+	lastTokLine int
+}
+
+func (l *lexer) errorf(line int, format string, args ...interface{}) error {
+	return &Error{Location{l.path, line}, fmt.Sprintf(format, args...)}
+}
+
+func isIdentChar(c byte, first bool) bool {
+	if c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80 {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\n':
+			l.line++
+		case ' ', '\t', '\r', '\f', '\v':
+		default:
+			return
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) startsWith(s string) bool {
+	return strings.HasPrefix(string(l.src[l.pos:min(l.pos+len(s), len(l.src))]), s)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (l *lexer) next() (*token, error) {
+	l.skipSpace()
+	ownLine := l.lastTokLine != l.line
+	tok := &token{line: l.line}
+	if l.pos >= len(l.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case l.startsWith("//"):
+		end := l.pos
+		for end < len(l.src) && l.src[end] != '\n' {
+			end++
+		}
+		tok.kind, tok.ownLine, tok.lineComment = tokComment, ownLine, true
+		tok.text = strings.TrimSpace(strings.TrimLeft(string(l.src[l.pos+2:end]), "/"))
+		l.pos = end
+		return tok, nil
+	case l.startsWith("/*"):
+		end := strings.Index(string(l.src[l.pos+2:]), "*/")
+		if end < 0 {
+			return nil, l.errorf(tok.line, "unterminated comment")
+		}
+		text := string(l.src[l.pos+2 : l.pos+2+end])
+		l.line += strings.Count(text, "\n")
+		l.pos += end + 4
+		tok.kind, tok.ownLine = tokComment, ownLine
+		tok.text = cleanBlockComment(text)
+		return tok, nil
+	case strings.IndexByte(l.syn.Quotes, c) >= 0 || strings.IndexByte(l.syn.RawQuotes, c) >= 0:
+		return l.stringLit(tok)
+	case isIdentChar(c, true):
+		start := l.pos
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		tok.kind, tok.text = tokIdent, string(l.src[start:l.pos])
+	default:
+		l.pos++
+		tok.kind, tok.text = tokOther, string(c)
+	}
+	l.lastTokLine = tok.line
+	return tok, nil
+}
+
+// cleanBlockComment removes leading * of lines in /* */ comments
+func cleanBlockComment(s string) string {
+	lines := strings.Split(s, "\n")
+	var res []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimLeft(line, "*"))
+		if line != "" {
+			res = append(res, line)
+		}
+	}
+	return strings.Join(res, "\n")
+}
+
+// stringLit parses a string literal starting at l.pos into tok.text, with
+// escapes decoded
+func (l *lexer) stringLit(tok *token) (*token, error) {
+	l.lastTokLine = tok.line
+	quote := l.src[l.pos]
+	raw := strings.IndexByte(l.syn.RawQuotes, quote) >= 0
+	l.pos++
+	tok.kind, tok.constant = tokString, true
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return nil, l.errorf(tok.line, "unterminated string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			tok.text = sb.String()
+			return tok, nil
+		case c == '\n':
+			// only raw strings and JS template literals can span lines
+			if !raw && quote != '`' {
+				if l.syn == JS {
+					// most likely a quote in a regexp literal like /'/,
+					// which we don't parse
+					tok.constant = false
+					return tok, nil
+				}
+				return nil, l.errorf(tok.line, "newline in string")
+			}
+			l.line++
+			sb.WriteByte(c)
+			l.pos++
+		case c == '\\' && !raw:
+			if err := l.escape(&sb); err != nil {
+				return nil, err
+			}
+		case c == '$' && quote == '`' && l.syn == JS && l.startsWith("${"):
+			tok.constant = false
+			sb.WriteByte(c)
+			l.pos++
+		case c == '\r' && raw:
+			// Go drops carriage returns in raw strings
+			l.pos++
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+var simpleEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '\'': '\'', '"': '"', '?': '?', '`': '`', '0': 0,
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// escape decodes an escape sequence at l.pos
+func (l *lexer) escape(sb *strings.Builder) error {
+	start := l.pos
+	l.pos++
+	if l.pos >= len(l.src) {
+		return l.errorf(l.line, "unterminated string")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	// reads up to max digits for which ok is true
+	digits := func(max int, ok func(byte) bool) string {
+		s := l.pos
+		for l.pos < len(l.src) && l.pos-s < max && ok(l.src[l.pos]) {
+			l.pos++
+		}
+		return string(l.src[s:l.pos])
+	}
+	isOctal := func(c byte) bool { return c >= '0' && c <= '7' }
+	switch {
+	case c == '\n':
+		// line continuation
+		l.line++
+	case c >= '0' && c <= '7':
+		l.pos--
+		n, _ := strconv.ParseUint(digits(3, isOctal), 8, 32)
+		sb.WriteByte(byte(n))
+	case c == 'x':
+		// in C hex escapes have any number of digits, we only support
+		// 2 like Go and JavaScript
+		d := digits(2, isHex)
+		if d == "" {
+			return l.errorf(l.line, "invalid escape %q", l.src[start:l.pos])
+		}
+		n, _ := strconv.ParseUint(d, 16, 32)
+		sb.WriteByte(byte(n))
+	case c == 'u' || c == 'U':
+		var d string
+		if c == 'u' && l.syn == JS && l.pos < len(l.src) && l.src[l.pos] == '{' {
+			l.pos++
+			d = digits(6, isHex)
+			if l.pos >= len(l.src) || l.src[l.pos] != '}' {
+				return l.errorf(l.line, "invalid escape %q", l.src[start:l.pos])
+			}
+			l.pos++
+		} else {
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if d = digits(n, isHex); len(d) != n {
+				return l.errorf(l.line, "invalid escape %q", l.src[start:l.pos])
+			}
+		}
+		r, _ := strconv.ParseUint(d, 16, 32)
+		if !utf8.ValidRune(rune(r)) {
+			return l.errorf(l.line, "invalid escape %q", l.src[start:l.pos])
+		}
+		sb.WriteRune(rune(r))
+	default:
+		e, ok := simpleEscapes[c]
+		if !ok {
+			if l.syn != JS {
+				return l.errorf(l.line, "invalid escape %q", l.src[start:l.pos])
+			}
+			// in JavaScript unknown escapes are the character itself
+			e = c
+		}
+		sb.WriteByte(e)
+	}
+	return nil
+}
+
+// markerArg parses arguments of a marker and returns its string or nil
+// if the first argument isn't a constant string e.g. in the definition of
+// a marker
+func (l *lexer) markerArg() (*String, error) {
+	tok, err := l.nextCode()
+	if err != nil || tok.kind != tokOther || tok.text != "(" {
+		return nil, err
+	}
+	tok, err = l.nextCode()
+	if err != nil || tok.kind != tokString || !tok.constant || tok.text == "" {
+		return nil, err
+	}
+	s := &String{Locations: []Location{{l.path, tok.line}}}
+	text := tok.text
+	for {
+		tok, err = l.nextCode()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokString && l.syn.Concat {
+			text += tok.text
+			continue
+		}
+		if tok.kind == tokOther && (tok.text == ")" || tok.text == ",") {
+			break
+		}
+		// an expression like _TR("a" + b)
+		return nil, nil
+	}
+	s.Text = Escape(text)
+	return s, nil
+}
+
+// nextCode returns the next token that isn't a comment
+func (l *lexer) nextCode() (*token, error) {
+	for {
+		tok, err := l.next()
+		if err != nil || tok.kind != tokComment {
+			return tok, err
+		}
+	}
+}
+
+// Escape returns s as it would be written in a C string literal, which is
+// how strings are uploaded to the server (one per line)
+func Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				// octal because in C hex escapes consume all following
+				// hex digits
+				fmt.Fprintf(&sb, `\%03o`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Unescape decodes escape sequences of a C string literal, the reverse of
+// Escape
+func Unescape(s string) (string, error) {
+	l := &lexer{src: []byte(s), syn: C, line: 1}
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		if l.src[l.pos] != '\\' {
+			sb.WriteByte(l.src[l.pos])
+			l.pos++
+			continue
+		}
+		if err := l.escape(&sb); err != nil {
+			return "", fmt.Errorf("%q: %s", s, err.(*Error).Msg)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,148 @@
+package extract
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func extractTest(t *testing.T, src string, syn *Syntax) []*String {
+	strs, err := Extract("test", []byte(src), syn, syn.DefaultMarkers)
+	if err != nil {
+		t.Fatalf("extracting from %q failed with %s", src, err)
+	}
+	return strs
+}
+
+func texts(strs []*String) string {
+	var res []string
+	for _, s := range strs {
+		res = append(res, s.Text)
+	}
+	return strings.Join(res, "|")
+}
+
+func TestExtractC(t *testing.T) {
+	src := `#define _TR(x) Trans::GetTranslation(x)
+// Translators: the File menu
+AppendMenu(m, _TR("&File"));
+/* comment with _TR("not a string") */
+char *s = "_TR(\"not marked\")";
+char c = '"';
+x = _TRN("Line\n" "two\t\"quoted\" \x41\101\\"); // not a note
+_TR("C:\\path");
+
+_TR( /* inline */ "after comment" );
+_TR(str); _TR("a" + b);
+`
+	strs := extractTest(t, src, C)
+	exp := `&File|Line\ntwo\t\"quoted\" AA\\|C:\\path|after comment`
+	if got := texts(strs); got != exp {
+		t.Fatalf("\n%s !=\n%s", got, exp)
+	}
+	if l := strs[0].Locations[0]; l.Line != 3 || l.String() != "test:3" {
+		t.Fatalf("unexpected location %v", l)
+	}
+	if len(strs[0].Notes) != 1 || strs[0].Notes[0] != "Translators: the File menu" {
+		t.Fatalf("unexpected notes %#v", strs[0].Notes)
+	}
+	for _, s := range strs[1:] {
+		if len(s.Notes) != 0 {
+			t.Fatalf("%q shouldn't have notes %#v", s.Text, s.Notes)
+		}
+	}
+	if strs[3].Locations[0].Line != 10 {
+		t.Fatalf("unexpected location %v", strs[3].Locations)
+	}
+}
+
+func TestExtractGo(t *testing.T) {
+	src := "package foo\n" +
+		"// shown in\n// the title\nfmt.Println(T(\"Hello, \\u00e9\"), T(`raw\\n\nline`))\n" +
+		"/*\n * block\n * note\n */\nT(\"x\")\n" +
+		"var r = '\\''\nT(\"after rune\")\n"
+	strs := extractTest(t, src, Go)
+	exp := `Hello, é|raw\\n\nline|x|after rune`
+	if got := texts(strs); got != exp {
+		t.Fatalf("\n%s !=\n%s", got, exp)
+	}
+	if strs[0].Notes[0] != "shown in\nthe title" || strs[1].Notes[0] != "shown in\nthe title" {
+		t.Fatalf("unexpected notes %#v", strs[0].Notes)
+	}
+	if strs[2].Notes[0] != "block\nnote" || strs[2].Locations[0].Line != 10 {
+		t.Fatalf("unexpected %#v", strs[2])
+	}
+}
+
+func TestExtractJS(t *testing.T) {
+	src := "i18n.t('it\\'s'); t(\"dq\"); t(`tmpl`); t(`${n} files`);\n" +
+		"var re = /'/;\nt('\\u{1F600} \\z');\nlet t2 = t;\n"
+	strs := extractTest(t, src, JS)
+	exp := "it's|dq|tmpl|\U0001F600 z"
+	if got := texts(strs); got != exp {
+		t.Fatalf("\n%s !=\n%s", got, exp)
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	bad := []string{
+		`_TR("unterminated`,
+		"_TR(\"new\nline\")",
+		`_TR("\q")`,
+		`_TR("\x")`,
+		`/* unterminated`,
+	}
+	for _, src := range bad {
+		_, err := Extract("bad.c", []byte(src), C, C.DefaultMarkers)
+		if err == nil || !strings.HasPrefix(err.Error(), "bad.c:1: ") {
+			t.Fatalf("extracting %q should fail, got %v", src, err)
+		}
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	e := New()
+	e.Markers["go"] = []string{"Tr"}
+	if err := e.Add("a.c", []byte("// note\n_TR(\"b\"); _TR(\"a\");"), C); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add("b.go", []byte("T(\"c\"); Tr(\"b\")"), Go); err != nil {
+		t.Fatal(err)
+	}
+	strs := e.Strings()
+	if got := texts(strs); got != "a|b" {
+		t.Fatalf("unexpected %s", got)
+	}
+	if len(strs[1].Locations) != 2 || strs[1].Locations[1].File != "b.go" || len(strs[1].Notes) != 1 {
+		t.Fatalf("unexpected %#v", strs[1])
+	}
+	var buf bytes.Buffer
+	if err := WriteUpload(&buf, strs); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "AppTranslator strings\na\nb" {
+		t.Fatalf("unexpected %q", buf.String())
+	}
+	if SyntaxForFile("foo/Bar.CPP") != C || SyntaxForFile("x.py") != nil || SyntaxByName("js") != JS {
+		t.Fatalf("invalid syntax detection")
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if s := Escape("a\"b\\c\n\x01\x7f"); s != `a\"b\\c\n\001\177` {
+		t.Fatalf("unexpected %s", s)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	s, err := Unescape(`a\"b\\c\n\001\x41é`)
+	if err != nil || s != "a\"b\\c\n\x01Aé" {
+		t.Fatalf("unexpected %q, %v", s, err)
+	}
+	if s, err = Unescape(Escape("x\ty\r\x7f")); err != nil || s != "x\ty\r\x7f" {
+		t.Fatalf("unexpected %q, %v", s, err)
+	}
+	if _, err = Unescape(`bad\q`); err == nil || err.Error() != `"bad\\q": invalid escape "\\q"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/kjk/apptranslator
 go 1.11
 
 require (
+	github.com/andybalholm/brotli v0.0.0-20190621154722-5f990b63d2d6
 	github.com/crowdmob/goamz v0.0.0-20150128194925-3a06871fe9fc
 	github.com/garyburd/go-oauth v0.0.0-20180319155456-bca2e7f09a17
 	github.com/gorilla/mux v1.7.1
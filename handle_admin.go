@@ -0,0 +1,260 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+// RoleDisplay describes a role shown on the admin page
+type RoleDisplay struct {
+	User      string
+	Role      string
+	Scope     string
+	GrantedBy string
+	Time      time.Time
+	CanRevoke bool
+}
+
+// ModelAdmin describes /admin page
+type ModelAdmin struct {
+	PageTitle     string
+	User          string
+	RedirectUrl   string
+	CsrfToken     string
+	Message       string
+	IsGlobalAdmin bool
+	// apps the user can manage
+	Apps  []*App
+	Langs []store.Lang
+	Roles []*RoleDisplay
+	// from config.json, can't be changed on the admin page
+	ConfigAdmins []string
+	// active sessions, only shown to global admins
+	Sessions []*store.Session
+	// users that overwrote many translations, only shown to global admins
+	MassEditFlags []*MassEditFlag
+}
+
+func buildModelAdmin(user string) *ModelAdmin {
+	model := &ModelAdmin{
+		PageTitle:     "AppTranslator admin",
+		User:          user,
+		IsGlobalAdmin: userIsGlobalAdmin(user),
+		Langs:         store.Languages[:],
+		ConfigAdmins:  config.Admins,
+	}
+	for _, app := range appState.Apps {
+		if userIsAdmin(app, user) {
+			model.Apps = append(model.Apps, app)
+		}
+	}
+	for _, r := range userStore.Roles() {
+		rd := &RoleDisplay{
+			User:      r.User,
+			Role:      r.Role,
+			Scope:     r.Scope,
+			GrantedBy: r.GrantedBy,
+			Time:      r.Time,
+			CanRevoke: userCanManageRole(user, r.Role, r.Scope),
+		}
+		model.Roles = append(model.Roles, rd)
+	}
+	if model.IsGlobalAdmin {
+		model.Sessions = userStore.ActiveSessions(sessionsCreatedAfter())
+		model.MassEditFlags = massEdits.recentFlags()
+	}
+	return model
+}
+
+// url: /admin?msg=${msg}
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	user := decodeUserFromCookie(r)
+	if !userCanSeeAdminPage(user) {
+		httpErrorf(w, "You're not an admin")
+		return
+	}
+	model := buildModelAdmin(user)
+	model.Message = r.FormValue("msg")
+	model.RedirectUrl = r.URL.String()
+	model.CsrfToken = csrfToken(r)
+	ExecTemplate(w, tmplAdmin, model)
+}
+
+// roleScopeArg builds role's scope from app and lang arguments
+func roleScopeArg(r *http.Request, role string) (string, error) {
+	switch role {
+	case store.RoleAppAdmin:
+		appName := strings.TrimSpace(r.FormValue("app"))
+		if findApp(appName) == nil {
+			return "", fmt.Errorf("application %q doesn't exist", appName)
+		}
+		return appName, nil
+	case store.RoleMaintainer:
+		appName := strings.TrimSpace(r.FormValue("app"))
+		app := findApp(appName)
+		if app == nil {
+			return "", fmt.Errorf("application %q doesn't exist", appName)
+		}
+		lang := strings.TrimSpace(r.FormValue("lang"))
+		if !store.IsValidLangCode(lang) {
+			return "", fmt.Errorf("invalid lang code %q", lang)
+		}
+		return maintainerScope(app, lang), nil
+	}
+	return "", nil
+}
+
+// url: POST /admin/role
+// action - grant or revoke
+// user, role
+// app, lang - for appadmin and maintainer roles
+// scope - for revoke, instead of app and lang
+func handleAdminRole(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	user := decodeUserFromCookie(r)
+	action := r.FormValue("action")
+	role := strings.TrimSpace(r.FormValue("role"))
+	who := normalizeUserIdentity(r.FormValue("user"))
+	if who == "" {
+		httpErrorf(w, "Missing user")
+		return
+	}
+	if !store.IsValidRole(role) {
+		httpErrorf(w, "Invalid role %q", role)
+		return
+	}
+	scope := r.FormValue("scope")
+	if _, ok := r.Form["scope"]; !ok {
+		var err error
+		if scope, err = roleScopeArg(r, role); err != nil {
+			httpErrorf(w, "%s", err)
+			return
+		}
+	}
+	if !userCanManageRole(user, role, scope) {
+		logger.Noticef("User %q tried to %s role %s/%s of %s", user, action, role, scope, who)
+		httpErrorf(w, "You're not allowed to manage role %s", role)
+		return
+	}
+	var err error
+	var msg string
+	switch action {
+	case "grant":
+		err = userStore.GrantRole(user, who, role, scope)
+		// banning can also hide all the edits
+		if err == nil && role == store.RoleBanned && boolArg(r, "hide") {
+			err = userStore.GrantRole(user, who, store.RoleHidden, scope)
+		}
+		msg = fmt.Sprintf("Granted %s %s to %s", role, scope, who)
+	case "revoke":
+		err = userStore.RevokeRole(user, who, role, scope)
+		// un-banning also un-hides
+		if err == nil && role == store.RoleBanned {
+			err = userStore.RevokeRole(user, who, store.RoleHidden, scope)
+		}
+		msg = fmt.Sprintf("Revoked %s %s from %s", role, scope, who)
+	default:
+		httpErrorf(w, "Invalid action %q", action)
+		return
+	}
+	if err != nil {
+		logger.Errorf("handleAdminRole(): %s failed with %s", action, err)
+		httpErrorf(w, "Failed to %s role: %s", action, err)
+		return
+	}
+	if role == store.RoleBanned || role == store.RoleHidden {
+		syncHiddenUsers()
+	}
+	logger.Noticef("%s: %s", user, msg)
+	http.Redirect(w, r, "/admin?msg="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// url: POST /admin/sessions
+// action - revoke (a session given by id) or revokeall (all sessions of user)
+func handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if !userIsGlobalAdmin(user) {
+		httpErrorf(w, "You're not allowed to revoke sessions")
+		return
+	}
+	var msg string
+	switch action := r.FormValue("action"); action {
+	case "revoke":
+		id := r.FormValue("id")
+		if err := userStore.RevokeSession(id); err != nil {
+			httpErrorf(w, "Failed to revoke session: %s", err)
+			return
+		}
+		msg = "Revoked a session"
+	case "revokeall":
+		who := normalizeUserIdentity(r.FormValue("user"))
+		n, err := userStore.RevokeUserSessions(who)
+		if err != nil {
+			httpErrorf(w, "Failed to revoke sessions: %s", err)
+			return
+		}
+		msg = fmt.Sprintf("Revoked %d sessions of %s", n, who)
+	default:
+		httpErrorf(w, "Invalid action %q", action)
+		return
+	}
+	logger.Noticef("%s: %s", user, msg)
+	http.Redirect(w, r, "/admin?msg="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// url: POST /reviewtranslation?app=${app}&lang=${lang}&string=${string}
+func handleReviewTranslation(w http.ResponseWriter, r *http.Request) {
+	handleMaintainerAction(w, r, "review")
+}
+
+// url: POST /reverttranslation?app=${app}&lang=${lang}&string=${string}
+func handleRevertTranslation(w http.ResponseWriter, r *http.Request) {
+	handleMaintainerAction(w, r, "revert")
+}
+
+func handleMaintainerAction(w http.ResponseWriter, r *http.Request, action string) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, langCode := getAppLangArg(w, r)
+	if app == nil {
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if !userIsMaintainer(app, langCode, user) || !userCanTranslate(user) {
+		httpErrorf(w, "User %q is not a maintainer of %s", user, store.LangNameByCode(langCode))
+		return
+	}
+	if !checkRateLimit(w, r, user) {
+		return
+	}
+	str := r.FormValue("string")
+	var msg string
+	var err error
+	if action == "review" {
+		err = app.store.WriteReview(str, langCode, user)
+		msg = fmt.Sprintf("Approved translation of %q", str)
+	} else {
+		var trans string
+		trans, err = revertTranslation(app, str, langCode, user)
+		msg = fmt.Sprintf("Reverted translation of %q to %q", str, trans)
+	}
+	if err != nil {
+		httpErrorf(w, "Failed to %s translation: %s", action, err)
+		return
+	}
+	logger.Noticef("%s: %s in %s/%s", user, msg, app.Name, langCode)
+	url := fmt.Sprintf("/app/%s/%s?msg=%s", app.Name, langCode, url.QueryEscape(msg))
+	http.Redirect(w, r, url, http.StatusFound)
+}
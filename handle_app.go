@@ -26,6 +26,7 @@ type ModelApp struct {
 	LoggedUser   string
 	UserIsAdmin  bool
 	RedirectUrl  string
+	CsrfToken    string
 }
 
 // for sorting by count of translations
@@ -96,5 +97,6 @@ func handleApp(w http.ResponseWriter, r *http.Request) {
 	model.SortedByName = sortedByName
 
 	model.RedirectUrl = r.URL.String()
+	model.CsrfToken = csrfToken(r)
 	ExecTemplate(w, tmplApp, model)
 }
@@ -3,6 +3,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/kjk/apptranslator/store"
 
@@ -14,19 +15,63 @@ type ModelAppTranslations struct {
 	LangInfo             *store.LangInfo
 	User                 string
 	UserIsAdmin          bool
+	UserIsMaintainer     bool
+	UserIsBanned         bool
 	StringsCount         int
 	TransProgressPercent int
 	RedirectUrl          string
+	CsrfToken            string
 	Message              string
+	// language shown next to source strings as a reference, can be nil
+	RefLangInfo *store.LangInfo
+	// maps source string to its current translation in the reference language
+	RefTrans map[string]string
+	AllLangs []store.Lang
+	// maps source string to number of comments about it
+	CommentCounts map[string]int
+	// maps source string to names of screenshots that show it
+	Screenshots map[string][]string
+	// maps source string to the latest review of its translation
+	Reviews map[string]*store.Review
 }
 
-func buildModelAppTranslations(app *App, langCode, user string) *ModelAppTranslations {
+// name of user preference that remembers reference language to be shown
+// when translating langCode
+func refLangPrefName(langCode string) string {
+	return "reflang-" + langCode
+}
+
+func buildModelAppTranslations(app *App, langCode, refLangCode, user string) *ModelAppTranslations {
 	model := &ModelAppTranslations{
-		App:         app,
-		User:        user,
-		UserIsAdmin: userIsAdmin(app, user)}
+		App:              app,
+		User:             user,
+		UserIsAdmin:      userIsAdmin(app, user),
+		UserIsMaintainer: userIsMaintainer(app, langCode, user),
+		UserIsBanned:     userIsBanned(user),
+		Reviews:          app.store.Reviews(langCode),
+	}
 
 	modelApp := buildModelApp(app, user, false)
+	model.AllLangs = store.Languages[:]
+	model.CommentCounts = app.store.CommentCounts(langCode)
+	model.Screenshots = app.store.ScreenshotsByString()
+	for _, langInfo := range modelApp.Langs {
+		if langInfo.Code != refLangCode {
+			continue
+		}
+		model.RefLangInfo = langInfo
+		model.RefTrans = make(map[string]string)
+		for _, tr := range langInfo.ActiveStrings {
+			if tr.IsTranslated() {
+				model.RefTrans[tr.String] = tr.Current()
+			}
+		}
+		for _, tr := range langInfo.UnusedStrings {
+			if tr.IsTranslated() {
+				model.RefTrans[tr.String] = tr.Current()
+			}
+		}
+	}
 	for _, langInfo := range modelApp.Langs {
 		if langInfo.Code != langCode {
 			continue
@@ -46,7 +91,7 @@ func buildModelAppTranslations(app *App, langCode, user string) *ModelAppTransla
 	panic("buildModelAppTranslations() failed")
 }
 
-// url: /app/{appname}/{lang}?msg=${msg}
+// url: /app/{appname}/{lang}?msg=${msg}&ref=${refLang}
 func handleAppTranslations(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	appName := vars["appname"]
@@ -62,9 +107,28 @@ func handleAppTranslations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	msg := r.FormValue("msg")
+	user := decodeUserFromCookie(r)
+	// ref=none turns off showing the reference language
+	refLangCode := strings.TrimSpace(r.FormValue("ref"))
+	if refLangCode == "none" || refLangCode == langCode {
+		refLangCode = ""
+	} else if refLangCode != "" && !store.IsValidLangCode(refLangCode) {
+		httpErrorf(w, "Invalid reference language: %q", refLangCode)
+		return
+	}
+	if user != "" {
+		if _, ok := r.Form["ref"]; ok {
+			if err := userStore.SetPref(user, refLangPrefName(langCode), refLangCode); err != nil {
+				logger.Errorf("SetPref() failed with %s", err)
+			}
+		} else {
+			refLangCode = userStore.GetPref(user, refLangPrefName(langCode))
+		}
+	}
 	//fmt.Printf("handleAppTranslations() appName=%s, lang=%s\n", app.Name, langCode)
-	model := buildModelAppTranslations(app, langCode, decodeUserFromCookie(r))
+	model := buildModelAppTranslations(app, langCode, refLangCode, user)
 	model.Message = msg
 	model.RedirectUrl = r.URL.String()
+	model.CsrfToken = csrfToken(r)
 	ExecTemplate(w, tmplAppTrans, model)
 }
@@ -0,0 +1,124 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+const maxRecentComments = 50
+
+type ModelComments struct {
+	App         *App
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	// String is empty when showing recent comments for the whole app
+	String   string
+	Lang     string
+	LangName string
+	Comments []store.Comment
+}
+
+func commentsURL(app *App, str, lang string) string {
+	q := url.Values{}
+	q.Set("string", str)
+	if lang != "" {
+		q.Set("lang", lang)
+	}
+	return fmt.Sprintf("/app/%s/comments?%s", app.Name, q.Encode())
+}
+
+// url: /app/{appname}/comments[?string=${string}&lang=${lang}]
+func handleComments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appName := vars["appname"]
+	app := findApp(appName)
+	if app == nil {
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	lang := strings.TrimSpace(r.FormValue("lang"))
+	if lang != "" && !store.IsValidLangCode(lang) {
+		httpErrorf(w, "Invalid language: %q", lang)
+		return
+	}
+	str := r.FormValue("string")
+	model := &ModelComments{
+		App:         app,
+		User:        decodeUserFromCookie(r),
+		RedirectUrl: r.URL.String(),
+		CsrfToken:   csrfToken(r),
+		String:      str,
+		Lang:        lang,
+	}
+	if lang != "" {
+		model.LangName = store.LangNameByCode(lang)
+	}
+	if str == "" {
+		model.PageTitle = fmt.Sprintf("Recent comments about %s strings", app.Name)
+		model.Comments = app.store.RecentComments(maxRecentComments)
+	} else {
+		model.PageTitle = fmt.Sprintf("Discussion of %s string", app.Name)
+		model.Comments = app.store.CommentsFor(str, lang)
+	}
+	ExecTemplate(w, tmplComments, model)
+}
+
+// url: POST /addcomment
+// args: app, string, lang, text and alllangs (if set, the comment is about
+// all languages)
+func handleAddComment(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app := getAppArg(w, r)
+	if app == nil {
+		return
+	}
+	lang := strings.TrimSpace(r.FormValue("lang"))
+	if lang != "" && !store.IsValidLangCode(lang) {
+		httpErrorf(w, "Invalid lang code %q", lang)
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if user == "" {
+		httpErrorf(w, "User doesn't exist")
+		return
+	}
+	if !userCanTranslate(user) {
+		httpErrorf(w, "User %s is not allowed to comment", user)
+		return
+	}
+	if !checkRateLimit(w, r, user) {
+		return
+	}
+	str := r.FormValue("string")
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" {
+		httpErrorf(w, "Comment cannot be empty")
+		return
+	}
+	commentLang := lang
+	if boolArg(r, "alllangs") {
+		commentLang = ""
+	}
+	if err := app.store.WriteComment(str, commentLang, user, text); err != nil {
+		httpErrorf(w, "Failed to add a comment %q", err)
+		return
+	}
+	logger.Noticef("%s commented on %q in %s/%s", user, str, app.Name, commentLang)
+	fireWebhooks(app, store.EventCommentPosted, map[string]string{
+		"string": str,
+		"lang":   commentLang,
+		"user":   user,
+		"text":   text,
+	})
+	http.Redirect(w, r, commentsURL(app, str, lang), http.StatusFound)
+}
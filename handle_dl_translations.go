@@ -7,7 +7,10 @@ import (
 	"io"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/kjk/apptranslator/store"
 )
 
 // LangTrans describes translation for a given language
@@ -73,7 +76,9 @@ func translationsForApp(app *App) []byte {
 	return w.Bytes()
 }
 
-// url: /dltrans?app=$app&sha1=$sha1
+// url: /dltrans?app=$app[&sha1=$sha1]
+// Doesn't need authentication but if API token is given, it must have
+// download scope.
 // Returns plain/text response in the format designed for easy parsing:
 /*
 AppTranslator: $appName
@@ -84,6 +89,9 @@ pl:translation for pl language
 :string 2
 pl:translation for pl language
 */
+// If $sha1 is the same as sha1 of current translations, the second line is
+// "No change" and there are no translations. Clients can also skip $sha1
+// and use standard ETag/If-None-Match or If-Modified-Since headers.
 func handleDownloadTranslations(w http.ResponseWriter, r *http.Request) {
 	appName := strings.TrimSpace(r.FormValue("app"))
 	sha1In := strings.TrimSpace(r.FormValue("sha1"))
@@ -92,18 +100,30 @@ func handleDownloadTranslations(w http.ResponseWriter, r *http.Request) {
 		httpErrorf(w, "Application %q doesn't exist", appName)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	io.WriteString(w, fmt.Sprintf("AppTranslator: %s\n", app.Name))
-	if len(sha1In) != 40 {
-		io.WriteString(w, "Error: no sha1 provided\n")
+	if bearerToken(r) != "" && authAPIRequest(w, r, app, store.ScopeDownload) == "" {
+		return
+	}
+	if sha1In != "" && len(sha1In) != 40 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, fmt.Sprintf("AppTranslator: %s\n", app.Name))
+		io.WriteString(w, "Error: invalid sha1\n")
 		return
 	}
+	// with sha1 the response is "No change" or everything
+	notModified := false
+	if sha1In == "" {
+		notModified = checkAppNotModified(w, r, app, "dltrans")
+	} else {
+		notModified = checkAppETagNotModified(w, r, app, "dltrans", sha1In)
+	}
+	if notModified {
+		logger.Noticef("Translations download for %s, not modified", appName)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, fmt.Sprintf("AppTranslator: %s\n", app.Name))
 	b := translationsForApp(app)
 	sha1 := sha1HexOfBytes(b)
-	sha2 := sha1HexOfBytes(b)
-	if sha1 != sha2 {
-		logger.Errorf("sha1 != sha2 (%s != %s)", sha1, sha2)
-	}
 	if sha1 == sha1In {
 		io.WriteString(w, "No change\n")
 		logger.Noticef("Translations download for %s with sha1 %s, didn't change", appName, sha1In)
@@ -113,3 +133,60 @@ func handleDownloadTranslations(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 	logger.Noticef("Translations download for %s with sha1 %s, our sha1 %s", appName, sha1In, sha1)
 }
+
+// url: /dltransdelta?app=$app&rev=$rev
+// Returns translations that changed since revision $rev, which is returned
+// by the previous call (use 0 for the first download). Authentication is the
+// same as for /dltrans. Format:
+/*
+AppTranslator: $appName
+Revision: $currentRevision
+Since: $rev
+:string 1
+pl:new translation for pl language
+de:
+*/
+// An empty translation means the string is no longer translated (or no
+// longer used). If $rev is invalid (e.g. newer than the current revision
+// because the server was restored from a backup), Since is 0 and the result
+// has all translations, which replace what the client has.
+func handleDownloadTranslationsDelta(w http.ResponseWriter, r *http.Request) {
+	appName := strings.TrimSpace(r.FormValue("app"))
+	app := findApp(appName)
+	if app == nil {
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	if bearerToken(r) != "" && authAPIRequest(w, r, app, store.ScopeDownload) == "" {
+		return
+	}
+	since, err := strconv.Atoi(strings.TrimSpace(r.FormValue("rev")))
+	if err != nil {
+		since = 0
+	}
+	if checkAppETagNotModified(w, r, app, "dltransdelta", since) {
+		return
+	}
+	changes, rev, err := app.store.TranslationsDelta(since)
+	if err != nil {
+		since = 0
+		changes, rev, err = app.store.TranslationsDelta(since)
+	}
+	if err != nil {
+		httpErrorf(w, "TranslationsDelta() failed with %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "AppTranslator: %s\nRevision: %d\nSince: %d\n", app.Name, rev, since)
+	prev := ""
+	for i, c := range changes {
+		if i == 0 || c.String != prev {
+			fmt.Fprintf(&b, ":%s\n", c.String)
+			prev = c.String
+		}
+		fmt.Fprintf(&b, "%s:%s\n", c.Lang, escapeTrans(c.Translation))
+	}
+	w.Write(b.Bytes())
+	logger.Noticef("Translations delta for %s since %d, revision %d, %d changes", appName, since, rev, len(changes))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kjk/apptranslator/client"
+)
+
+func downloadDelta(t *testing.T, rev string) *client.TranslationsDelta {
+	req := httptest.NewRequest("GET", "/dltransdelta?app=TestApp&rev="+rev, nil)
+	rr := httptest.NewRecorder()
+	handleDownloadTranslationsDelta(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("/dltransdelta returned %d, %s", rr.Code, rr.Body.String())
+	}
+	d, err := client.ParseTranslationsDelta(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDownloadTranslationsDelta(t *testing.T) {
+	logger = NewServerLogger(16, 16, false)
+	app, cleanup := setupTestApp(t, "TestApp", "dltranstest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range [][]string{{"foo", "fuu\nx"}, {"bar", "baar"}} {
+		if err := app.store.WriteNewTranslation(tr[0], tr[1], "pl", "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := downloadDelta(t, "0")
+	if d.Revision != 3 || d.Since != 0 || len(d.Strings) != 2 || d.Strings[1].Translations["pl"] != `fuu\nx` {
+		t.Fatalf("unexpected %#v", d)
+	}
+	// applying deltas gives the same result as the full download
+	trans := &client.Translations{}
+	d.Apply(trans)
+	checkSameAsDownload := func() {
+		rr := getURL(http.HandlerFunc(handleDownloadTranslations), "/dltrans?app=TestApp")
+		if got := string(trans.Raw); got != rr.Body.String() {
+			t.Fatalf("applied deltas are %q, /dltrans returned %q", got, rr.Body.String())
+		}
+	}
+	checkSameAsDownload()
+
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	d = downloadDelta(t, "3")
+	if d.Revision != 4 || d.Since != 3 || len(d.Strings) != 1 || d.Strings[0].String != "bar" {
+		t.Fatalf("unexpected %#v", d)
+	}
+	if tr, ok := d.Strings[0].Translations["pl"]; !ok || tr != "" {
+		t.Fatalf("removed translation should be empty %#v", d.Strings[0])
+	}
+	d.Apply(trans)
+	checkSameAsDownload()
+	if d = downloadDelta(t, "4"); d.Since != 4 || len(d.Strings) != 0 {
+		t.Fatalf("unexpected %#v", d)
+	}
+	// revision from the future gets everything
+	if d = downloadDelta(t, "100"); d.Since != 0 || len(d.Strings) != 1 {
+		t.Fatalf("unexpected %#v", d)
+	}
+}
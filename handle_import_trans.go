@@ -0,0 +1,133 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+// ImportedTranslation is a translation parsed from /importtrans data
+type ImportedTranslation struct {
+	String string
+	Lang   string
+	Trans  string
+}
+
+// reverse of escapeTrans
+func unescapeTrans(s string) string {
+	s = strings.Replace(s, "\\n", "\n", -1)
+	s = strings.Replace(s, "\\r", "\r", -1)
+	return s
+}
+
+func parseImportedTranslations(s string) ([]ImportedTranslation, error) {
+	s = normalizeNewlines(strings.Replace(s, "\r\n", "\n", -1))
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) < 2 {
+		return nil, errors.New("not enough lines")
+	}
+	if lines[0] != "AppTranslator translations" {
+		return nil, errors.New("First line is not 'AppTranslator translations'")
+	}
+	var res []ImportedTranslation
+	str := ""
+	for i, l := range lines[1:] {
+		lineNo := i + 2
+		if strings.HasPrefix(l, ":") {
+			str = l[1:]
+			if str == "" {
+				return nil, &CantParseError{"empty string", lineNo}
+			}
+			continue
+		}
+		parts := strings.SplitN(l, ":", 2)
+		if str == "" || len(parts) != 2 {
+			return nil, &CantParseError{fmt.Sprintf("unexpected line %q", l), lineNo}
+		}
+		if !store.IsValidLangCode(parts[0]) {
+			return nil, &CantParseError{fmt.Sprintf("invalid language %q", parts[0]), lineNo}
+		}
+		if parts[1] == "" {
+			return nil, &CantParseError{"empty translation", lineNo}
+		}
+		res = append(res, ImportedTranslation{String: str, Lang: parts[0], Trans: unescapeTrans(parts[1])})
+	}
+	return res, nil
+}
+
+// url: POST /importtrans?app=$appName
+// Needs API token with import scope. Imports existing translations e.g. when
+// moving an app to AppTranslator. Strings that are not used by the app or
+// that already have a translation are skipped.
+// POST data is "translations" argument in the same format as returned by
+// /dltrans, but with a different first line and without sha1:
+/*
+AppTranslator translations
+:string 1
+cv:translation for cv language
+pl:translation for pl language
+:string 2
+pl:translation for pl language
+*/
+func handleImportTranslations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	appName := strings.TrimSpace(r.FormValue("app"))
+	app := findApp(appName)
+	if app == nil {
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	uploader := authAPIRequest(w, r, app, store.ScopeImport)
+	if uploader == "" {
+		return
+	}
+	translations, err := parseImportedTranslations(r.FormValue("translations"))
+	if err != nil {
+		logger.Noticef("parseImportedTranslations() failed with %s", err)
+		httpErrorf(w, "Error parsing translations: %s", err)
+		return
+	}
+	// maps language to current translations
+	current := make(map[string]map[string]string)
+	imported, skipped := 0, 0
+	for _, tr := range translations {
+		cur, ok := current[tr.Lang]
+		if !ok {
+			cur = make(map[string]string)
+			if li := findLangInfo(app.store.LangInfos(), tr.Lang); li != nil {
+				cur = currentTranslations(li)
+			}
+			current[tr.Lang] = cur
+		}
+		if cur[tr.String] != "" {
+			skipped++
+			continue
+		}
+		err = writeTranslation(app, tr.String, tr.Trans, tr.Lang, uploader)
+		if err == errInactiveString {
+			skipped++
+			continue
+		}
+		if err != nil {
+			logger.Errorf("writeTranslation() failed with %s", err)
+			http.Error(w, fmt.Sprintf("Failed to import translation of %q", tr.String), http.StatusInternalServerError)
+			return
+		}
+		cur[tr.String] = tr.Trans
+		imported++
+	}
+	details := fmt.Sprintf("%d translations", imported)
+	if err = app.store.WriteUpload(uploader, "translations", details); err != nil {
+		logger.Errorf("WriteUpload() failed with %s", err)
+	}
+	msg := fmt.Sprintf("Imported %d translations, skipped %d\n", imported, skipped)
+	logger.Noticef("%s: %s", app.Name, strings.TrimSpace(msg))
+	w.Write([]byte(msg))
+}
@@ -0,0 +1,88 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+func importTranslations(h http.Handler, app, token, translations string) *httptest.ResponseRecorder {
+	vals := url.Values{"app": {app}, "translations": {translations}}
+	req := httptest.NewRequest("POST", "/importtrans", strings.NewReader(vals.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseImportedTranslations(t *testing.T) {
+	got, err := parseImportedTranslations("AppTranslator translations\r\n:foo\r\npl:fu\\nfu\r\nde:f:u\r\n:bar\r\npl:bu\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []ImportedTranslation{{"foo", "pl", "fu\nfu"}, {"foo", "de", "f:u"}, {"bar", "pl", "bu"}}
+	if len(got) != len(exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("got %#v, expected %#v", got[i], exp[i])
+		}
+	}
+	invalid := []string{
+		"",
+		"AppTranslator strings\n:foo\npl:fu",
+		"AppTranslator translations\npl:fu",
+		"AppTranslator translations\n:foo\nxx:fu",
+		"AppTranslator translations\n:foo\npl:",
+		"AppTranslator translations\n:foo\nfu",
+	}
+	for _, s := range invalid {
+		if _, err := parseImportedTranslations(s); err == nil {
+			t.Errorf("parsing %q should fail", s)
+		}
+	}
+}
+
+func TestImportTranslations(t *testing.T) {
+	defer setupTestUserStore(t, "importtranstest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "importtransapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteNewTranslation("bar", "bu", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	tok, token := createTestToken(t, "TestApp", "import", store.ScopeImport)
+	_, dlToken := createTestToken(t, "TestApp", "dl", store.ScopeDownload)
+
+	data := "AppTranslator translations\n:foo\nde:fo\npl:fu\n:bar\npl:other\n:baz\npl:bazz\n"
+	if rr := importTranslations(h, "TestApp", dlToken, data); rr.Code != http.StatusForbidden {
+		t.Fatalf("import with download token returned %d", rr.Code)
+	}
+	if rr := importTranslations(h, "TestApp", token, "AppTranslator strings\nfoo"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("import of invalid data returned %d", rr.Code)
+	}
+	rr := importTranslations(h, "TestApp", token, data)
+	if rr.Code != 200 || rr.Body.String() != "Imported 2 translations, skipped 2\n" {
+		t.Fatalf("import returned %d, %q", rr.Code, rr.Body.String())
+	}
+	// existing translations are not overwritten, unused strings are skipped
+	pl := currentTranslations(findLangInfo(app.store.LangInfos(), "pl"))
+	de := currentTranslations(findLangInfo(app.store.LangInfos(), "de"))
+	if pl["foo"] != "fu" || pl["bar"] != "bu" || de["foo"] != "fo" || len(pl) != 2 {
+		t.Fatalf("unexpected translations pl: %v, de: %v", pl, de)
+	}
+	uploads := app.store.RecentUploads(1)
+	if len(uploads) != 1 || uploads[0].By != apiTokenUploader(tok) || uploads[0].What != "translations" {
+		t.Fatalf("unexpected uploads %#v", uploads)
+	}
+}
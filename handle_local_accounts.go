@@ -0,0 +1,447 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	localProviderName = "local"
+
+	minPasswordLen = 8
+	// bcrypt ignores bytes past 72
+	maxPasswordLen = 72
+
+	verifyTokenMaxAge = 60 * 60 * 24 * 3
+	resetTokenMaxAge  = 60 * 60 * 2
+)
+
+var (
+	accountNameRx = regexp.MustCompile(`^[a-zA-Z0-9_.-]{2,32}$`)
+	emailRx       = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+	// used to make login of a non-existent account take as much time as
+	// login of an existing one
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+)
+
+// localProvider logs in with a user name and a password, for users who
+// don't want to use OAuth providers
+type localProvider struct{}
+
+func (p *localProvider) Name() string {
+	return localProviderName
+}
+
+func (p *localProvider) DisplayName() string {
+	return "Email and password"
+}
+
+// StartLogin returns url of our own login page
+func (p *localProvider) StartLogin(w http.ResponseWriter, r *http.Request, redirect string) (string, error) {
+	return "/local/login?redirect=" + url.QueryEscape(redirect), nil
+}
+
+// FinishLogin is not used because login form is handled by handleLocalLogin
+func (p *localProvider) FinishLogin(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	return "", "", errors.New("not supported")
+}
+
+func localAccountsEnabled() bool {
+	return findLoginProvider(localProviderName) != nil
+}
+
+func hashPassword(password string) (string, error) {
+	d, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(d), err
+}
+
+func validatePassword(password, password2 string) error {
+	if password != password2 {
+		return errors.New("Passwords don't match")
+	}
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("Password must have at least %d characters", minPasswordLen)
+	}
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("Password can't have more than %d characters", maxPasswordLen)
+	}
+	return nil
+}
+
+// checkLocalLogin returns account name if name (or email) and password are
+// valid
+func checkLocalLogin(nameOrEmail, password string) (string, error) {
+	acc := userStore.GetAccount(nameOrEmail)
+	if acc == nil {
+		acc = userStore.AccountByEmail(nameOrEmail)
+	}
+	if acc == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", errors.New("Invalid user name or password")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(acc.PasswordHash), []byte(password)) != nil {
+		return "", errors.New("Invalid user name or password")
+	}
+	if !acc.Verified {
+		return "", errors.New("Email address has not been verified yet. Check your email for a verification link")
+	}
+	return acc.Name, nil
+}
+
+// Verification and password reset tokens are not stored on the server. They
+// are encrypted and signed with cookie keys and expire after MaxAge. Reset
+// token includes hash of the current password so that it can only be used
+// once
+func accountTokenCodec(maxAge int) *securecookie.SecureCookie {
+	return securecookie.New(cookieAuthKey, cookieEncrKey).MaxAge(maxAge)
+}
+
+func newVerifyToken(acc string, email string) (string, error) {
+	val := map[string]string{"name": acc, "email": email}
+	return accountTokenCodec(verifyTokenMaxAge).Encode("verify", val)
+}
+
+func newResetToken(acc string, passwordHash string) (string, error) {
+	val := map[string]string{"name": acc, "pwd": sha1HexOfBytes([]byte(passwordHash))}
+	return accountTokenCodec(resetTokenMaxAge).Encode("reset", val)
+}
+
+// decodeVerifyToken returns name of the account whose email was verified
+func decodeVerifyToken(token string) (string, error) {
+	val := make(map[string]string)
+	if err := accountTokenCodec(verifyTokenMaxAge).Decode("verify", token, &val); err != nil {
+		return "", errors.New("Invalid or expired verification link")
+	}
+	acc := userStore.GetAccount(val["name"])
+	if acc == nil || acc.Email != val["email"] {
+		return "", errors.New("Invalid verification link")
+	}
+	return acc.Name, nil
+}
+
+// decodeResetToken returns name of the account whose password is reset
+func decodeResetToken(token string) (string, error) {
+	val := make(map[string]string)
+	if err := accountTokenCodec(resetTokenMaxAge).Decode("reset", token, &val); err != nil {
+		return "", errors.New("Invalid or expired password reset link")
+	}
+	acc := userStore.GetAccount(val["name"])
+	if acc == nil || sha1HexOfBytes([]byte(acc.PasswordHash)) != val["pwd"] {
+		return "", errors.New("Password reset link has already been used")
+	}
+	return acc.Name, nil
+}
+
+// accountEmailURL returns absolute url of path for links in emails. Host
+// header of the request can be spoofed, so only BaseURL is used
+func accountEmailURL(path string) (string, error) {
+	if config.BaseURL == "" {
+		return "", errors.New("BaseURL must be set in config to send emails")
+	}
+	return absoluteURL(nil, path), nil
+}
+
+func sendVerificationEmail(acc string, email string) error {
+	token, err := newVerifyToken(acc, email)
+	if err != nil {
+		return err
+	}
+	link, err := accountEmailURL("/local/verify?token=" + url.QueryEscape(token))
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`Hello %s,
+
+Please confirm your email address by opening this link:
+
+%s
+
+If you didn't create an AppTranslator account, ignore this email.
+`, acc, link)
+	return mailSender.SendMail(email, "Confirm your AppTranslator account", body)
+}
+
+func sendPasswordResetEmail(acc string, email string, passwordHash string) error {
+	token, err := newResetToken(acc, passwordHash)
+	if err != nil {
+		return err
+	}
+	link, err := accountEmailURL("/local/reset?token=" + url.QueryEscape(token))
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`Hello %s,
+
+To set a new password for your AppTranslator account, open this link:
+
+%s
+
+The link is valid for 2 hours. If you didn't ask for a password reset,
+ignore this email.
+`, acc, link)
+	return mailSender.SendMail(email, "Reset your AppTranslator password", body)
+}
+
+// ModelLocalAccount describes pages for logging in, signing up and
+// resetting password of local accounts
+type ModelLocalAccount struct {
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	// CSRF token of the forms, user is usually not logged in
+	FormCsrfToken string
+	// redirect after logging in
+	Redirect string
+	// login, signup, forgot, reset or message
+	Mode    string
+	Message string
+	Error   string
+	Token   string
+	Name    string
+	Email   string
+}
+
+func serveLocalAccountPage(w http.ResponseWriter, r *http.Request, model *ModelLocalAccount) {
+	model.User = decodeUserFromCookie(r)
+	model.RedirectUrl = r.URL.String()
+	model.CsrfToken = csrfToken(r)
+	model.FormCsrfToken = preSessionCSRFToken(w, r)
+	if model.PageTitle == "" {
+		model.PageTitle = "AppTranslator account"
+	}
+	ExecTemplate(w, tmplLocalAccount, model)
+}
+
+func serveLocalAccountMessage(w http.ResponseWriter, r *http.Request, msg string) {
+	serveLocalAccountPage(w, r, &ModelLocalAccount{Mode: "message", Message: msg})
+}
+
+func localRedirectArg(r *http.Request) string {
+	return safeRedirect(r.FormValue("redirect"))
+}
+
+// url: GET, POST /local/login?redirect=$redirect
+func handleLocalLogin(w http.ResponseWriter, r *http.Request) {
+	if !localAccountsEnabled() {
+		http404(w, r)
+		return
+	}
+	model := &ModelLocalAccount{
+		PageTitle: "Log in",
+		Mode:      "login",
+		Redirect:  localRedirectArg(r),
+	}
+	if r.Method != "POST" {
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	if !checkPreSessionRequest(w, r) {
+		return
+	}
+	if !checkAuthLockout(w, r) {
+		return
+	}
+	model.Name = strings.TrimSpace(r.FormValue("name"))
+	name, err := checkLocalLogin(model.Name, r.FormValue("password"))
+	if err != nil {
+		logger.Noticef("Failed local login of %q: %s", model.Name, err)
+		recordAuthFailure(r)
+		model.Error = err.Error()
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	logInUser(w, r, userIdentity(localProviderName, name), localProviderName, model.Redirect)
+}
+
+// url: GET, POST /local/signup?redirect=$redirect
+func handleLocalSignup(w http.ResponseWriter, r *http.Request) {
+	if !localAccountsEnabled() {
+		http404(w, r)
+		return
+	}
+	model := &ModelLocalAccount{
+		PageTitle: "Create account",
+		Mode:      "signup",
+		Redirect:  localRedirectArg(r),
+	}
+	if r.Method != "POST" {
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	if !checkPreSessionRequest(w, r) {
+		return
+	}
+	// limit how many emails can be sent
+	if !checkRateLimit(w, r, "") {
+		return
+	}
+	model.Name = strings.TrimSpace(r.FormValue("name"))
+	model.Email = strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+	var err error
+	if !accountNameRx.MatchString(model.Name) {
+		err = errors.New("User name must have 2 to 32 characters: letters, digits, '_', '.' or '-'")
+	} else if !emailRx.MatchString(model.Email) {
+		err = errors.New("Invalid email address")
+	} else if userStore.GetAccount(model.Name) != nil {
+		err = errors.New("User name is already taken")
+	} else if userStore.AccountByEmail(model.Email) != nil {
+		err = errors.New("There already is an account with this email. Use 'forgot password' to recover it")
+	} else {
+		err = validatePassword(password, r.FormValue("password2"))
+	}
+	if err != nil {
+		model.Error = err.Error()
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = userStore.CreateAccount(model.Name, model.Email, hash)
+	}
+	if err != nil {
+		logger.Errorf("Failed to create account %q: %s", model.Name, err)
+		model.Error = "Failed to create account: " + err.Error()
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	logger.Noticef("Created local account %q", model.Name)
+	if err = sendVerificationEmail(model.Name, model.Email); err != nil {
+		logger.Errorf("Failed to send verification email to %s: %s", model.Email, err)
+		serveLocalAccountMessage(w, r, "Account created but we failed to send a verification email. Please contact the administrator.")
+		return
+	}
+	serveLocalAccountMessage(w, r, fmt.Sprintf("Account created. We've sent an email to %s with a link to confirm your address.", model.Email))
+}
+
+// url: GET /local/verify?token=$token
+func handleLocalVerify(w http.ResponseWriter, r *http.Request) {
+	if !localAccountsEnabled() {
+		http404(w, r)
+		return
+	}
+	name, err := decodeVerifyToken(r.FormValue("token"))
+	if err == nil {
+		err = userStore.SetAccountVerified(name)
+	}
+	if err != nil {
+		serveLocalAccountMessage(w, r, err.Error())
+		return
+	}
+	logger.Noticef("Verified email of local account %q", name)
+	serveLocalAccountPage(w, r, &ModelLocalAccount{
+		PageTitle: "Log in",
+		Mode:      "login",
+		Redirect:  "/",
+		Message:   "Your email address has been confirmed. You can log in now.",
+		Name:      name,
+	})
+}
+
+// url: GET, POST /local/forgot
+func handleLocalForgot(w http.ResponseWriter, r *http.Request) {
+	if !localAccountsEnabled() {
+		http404(w, r)
+		return
+	}
+	model := &ModelLocalAccount{
+		PageTitle: "Forgot password",
+		Mode:      "forgot",
+	}
+	if r.Method != "POST" {
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	if !checkPreSessionRequest(w, r) {
+		return
+	}
+	// limit how many emails can be sent
+	if !checkRateLimit(w, r, "") {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if acc := userStore.AccountByEmail(email); acc != nil {
+		var err error
+		if acc.Verified {
+			err = sendPasswordResetEmail(acc.Name, acc.Email, acc.PasswordHash)
+		} else {
+			// resetting password also proves ownership of the email so
+			// it's simpler to re-send verification email
+			err = sendVerificationEmail(acc.Name, acc.Email)
+		}
+		if err != nil {
+			logger.Errorf("Failed to send email to %s: %s", acc.Email, err)
+		}
+	}
+	// same message whether the account exists or not, to not reveal
+	// which emails have accounts
+	serveLocalAccountMessage(w, r, fmt.Sprintf("If there's an account for %s, we've sent it an email with further instructions.", email))
+}
+
+// url: GET, POST /local/reset?token=$token
+func handleLocalReset(w http.ResponseWriter, r *http.Request) {
+	if !localAccountsEnabled() {
+		http404(w, r)
+		return
+	}
+	token := r.FormValue("token")
+	name, err := decodeResetToken(token)
+	if err != nil {
+		serveLocalAccountMessage(w, r, err.Error())
+		return
+	}
+	model := &ModelLocalAccount{
+		PageTitle: "Set new password",
+		Mode:      "reset",
+		Token:     token,
+		Name:      name,
+	}
+	if r.Method != "POST" {
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	if !checkPreSessionRequest(w, r) {
+		return
+	}
+	if !checkRateLimit(w, r, "") {
+		return
+	}
+	password := r.FormValue("password")
+	if err = validatePassword(password, r.FormValue("password2")); err != nil {
+		model.Error = err.Error()
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = userStore.SetAccountPassword(name, hash)
+	}
+	if err != nil {
+		logger.Errorf("Failed to set password of %q: %s", name, err)
+		model.Error = "Failed to set password: " + err.Error()
+		serveLocalAccountPage(w, r, model)
+		return
+	}
+	logger.Noticef("Reset password of local account %q", name)
+	// someone else might know the old password
+	if _, err = userStore.RevokeUserSessions(canonicalUser(userIdentity(localProviderName, name))); err != nil {
+		logger.Errorf("RevokeUserSessions() failed with %s", err)
+	}
+	serveLocalAccountPage(w, r, &ModelLocalAccount{
+		PageTitle: "Log in",
+		Mode:      "login",
+		Redirect:  "/",
+		Message:   "Your password has been changed. You can log in now.",
+		Name:      name,
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	mailLinkRx = regexp.MustCompile(`https?://[^\s]+`)
+	formCsrfRx = regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`)
+)
+
+func postForm(h http.Handler, path string, vals url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(vals.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// postLocalForm submits a form of local accounts pages, like a browser
+// would after loading the page
+func postLocalForm(t *testing.T, h http.Handler, path string, vals url.Values) *httptest.ResponseRecorder {
+	rr := getURL(h, "/local/login")
+	m := formCsrfRx.FindStringSubmatch(rr.Body.String())
+	if m == nil {
+		t.Fatalf("no csrf token in %s", rr.Body.String())
+	}
+	vals.Set("csrf", m[1])
+	return postForm(h, path, vals, rr.Result().Cookies())
+}
+
+func getURL(h http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// returns the link from the last email sent to a given address
+func lastMailLink(t *testing.T, m *stubMailer, to string) string {
+	for i := len(m.Sent) - 1; i >= 0; i-- {
+		if m.Sent[i].To == to {
+			link := mailLinkRx.FindString(m.Sent[i].Body)
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return u.RequestURI()
+		}
+	}
+	t.Fatalf("no email sent to %s", to)
+	return ""
+}
+
+func userFromResponse(rr *httptest.ResponseRecorder) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return decodeUserFromCookie(req)
+}
+
+func TestLocalAccounts(t *testing.T) {
+	defer setupTestUserStore(t, "localaccountstest.dat")()
+
+	h := setupLoginTest(t, nil, nil)
+	config.LocalAccounts = true
+	config.BaseURL = "https://example.org"
+	defer func() { config.BaseURL = "" }()
+	if err := initLoginProviders(); err != nil {
+		t.Fatal(err)
+	}
+	m := &stubMailer{}
+	mailSender = m
+
+	login := func(name, password string) *httptest.ResponseRecorder {
+		vals := url.Values{"name": {name}, "password": {password}, "redirect": {"/app/foo"}}
+		return postLocalForm(t, h, "/local/login", vals)
+	}
+
+	signup := url.Values{
+		"name":      {"alice"},
+		"email":     {"alice@example.com"},
+		"password":  {"password1"},
+		"password2": {"password1"},
+	}
+	rr := postLocalForm(t, h, "/local/signup", signup)
+	if rr.Code != 200 || len(m.Sent) != 1 {
+		t.Fatalf("signup returned %d and sent %d emails", rr.Code, len(m.Sent))
+	}
+	// links in emails don't depend on (possibly spoofed) Host header
+	if !strings.Contains(m.Sent[0].Body, "https://example.org/local/verify?") {
+		t.Fatalf("verification link should use BaseURL: %s", m.Sent[0].Body)
+	}
+	rr = postLocalForm(t, h, "/local/signup", signup)
+	if !strings.Contains(rr.Body.String(), "already taken") {
+		t.Fatalf("duplicate signup should fail")
+	}
+
+	// can't log in before verifying email
+	rr = login("alice", "password1")
+	if rr.Code != 200 || userFromResponse(rr) != "" {
+		t.Fatalf("unverified user shouldn't be able to log in")
+	}
+	rr = getURL(h, lastMailLink(t, m, "alice@example.com"))
+	if rr.Code != 200 || !userStore.GetAccount("alice").Verified {
+		t.Fatalf("verification failed, code: %d", rr.Code)
+	}
+
+	rr = login("alice", "bad password")
+	if userFromResponse(rr) != "" {
+		t.Fatalf("login with bad password should fail")
+	}
+	rr = login("alice@example.com", "password1")
+	if rr.Code != 302 || userFromResponse(rr) != "local:alice" {
+		t.Fatalf("login failed, code: %d, user: %q", rr.Code, userFromResponse(rr))
+	}
+
+	// password reset
+	postLocalForm(t, h, "/local/forgot", url.Values{"email": {"ALICE@example.com"}})
+	resetURL := lastMailLink(t, m, "alice@example.com")
+	if !strings.HasPrefix(resetURL, "/local/reset?") {
+		t.Fatalf("unexpected reset link %s", resetURL)
+	}
+	newPwd := url.Values{"password": {"password2"}, "password2": {"password2"}}
+	rr = postLocalForm(t, h, resetURL, newPwd)
+	if !strings.Contains(rr.Body.String(), "password has been changed") {
+		t.Fatalf("password reset failed: %s", rr.Body.String())
+	}
+	// reset link can only be used once
+	rr = postLocalForm(t, h, resetURL, newPwd)
+	if !strings.Contains(rr.Body.String(), "already been used") {
+		t.Fatalf("reset link should only work once")
+	}
+	if userFromResponse(login("alice", "password1")) != "" {
+		t.Fatalf("old password should no longer work")
+	}
+	if userFromResponse(login("alice", "password2")) != "local:alice" {
+		t.Fatalf("login with new password failed")
+	}
+
+	// forms can't be submitted from other sites
+	vals := url.Values{"name": {"alice"}, "password": {"password2"}}
+	rr = postForm(h, "/local/login", vals, nil)
+	if rr.Code != 403 || userFromResponse(rr) != "" {
+		t.Fatalf("login without csrf token returned %d", rr.Code)
+	}
+	vals.Set("csrf", csrfTokenForNonce("attacker"))
+	rr = postForm(h, "/local/login", vals, getURL(h, "/local/login").Result().Cookies())
+	if rr.Code != 403 || userFromResponse(rr) != "" {
+		t.Fatalf("login with csrf token of other nonce returned %d", rr.Code)
+	}
+	sent := len(m.Sent)
+	rr = postForm(h, "/local/forgot", url.Values{"email": {"alice@example.com"}}, nil)
+	if rr.Code != 403 || len(m.Sent) != sent {
+		t.Fatalf("forgot password without csrf token returned %d", rr.Code)
+	}
+}
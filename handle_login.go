@@ -3,30 +3,45 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/garyburd/go-oauth/oauth"
+	"github.com/gorilla/mux"
 )
 
 type SecureCookieValue struct {
-	User        string
+	User string
+	// id of user's session in user store, set when User is set
+	SessionID   string
 	TwitterTemp string
+	// used by OAuth2 providers to prevent cross-site request forgery
+	LoginState string
+	// where to redirect after logging in with OAuth2 provider
+	LoginRedirect string
 }
 
-func setSecureCookie(w http.ResponseWriter, cookieVal *SecureCookieValue) {
+func setSecureCookie(w http.ResponseWriter, r *http.Request, cookieVal *SecureCookieValue) {
 	val := make(map[string]string)
 	val["user"] = cookieVal.User
+	val["session"] = cookieVal.SessionID
 	val["twittertemp"] = cookieVal.TwitterTemp
+	val["loginstate"] = cookieVal.LoginState
+	val["loginredirect"] = cookieVal.LoginRedirect
 	if encoded, err := secureCookie.Encode(cookieName, val); err == nil {
-		// TODO: set expiration (Expires    time.Time) long time in the future?
 		cookie := &http.Cookie{
-			Name:  cookieName,
-			Value: encoded,
-			Path:  "/",
+			Name:     cookieName,
+			Value:    encoded,
+			Path:     "/",
+			MaxAge:   int(sessionMaxAge / time.Second),
+			Secure:   isHTTPS(r),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, cookie)
 	} else {
@@ -34,16 +49,16 @@ func setSecureCookie(w http.ResponseWriter, cookieVal *SecureCookieValue) {
 	}
 }
 
-const WeekInSeconds = 60 * 60 * 24 * 7
-
-// to delete the cookie value (e.g. for logging out), we need to set an
-// invalid value
-func deleteSecureCookie(w http.ResponseWriter) {
+// deleteSecureCookie tells the browser to delete the cookie (e.g. for
+// logging out)
+func deleteSecureCookie(w http.ResponseWriter, r *http.Request) {
 	cookie := &http.Cookie{
-		Name:   cookieName,
-		Value:  "deleted",
-		MaxAge: WeekInSeconds,
-		Path:   "/",
+		Name:     cookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		Secure:   isHTTPS(r),
+		HttpOnly: true,
 	}
 	http.SetCookie(w, cookie)
 }
@@ -51,15 +66,11 @@ func deleteSecureCookie(w http.ResponseWriter) {
 func getSecureCookie(r *http.Request) *SecureCookieValue {
 	var ret *SecureCookieValue
 	if cookie, err := r.Cookie(cookieName); err == nil {
-		// detect a deleted cookie
-		if "deleted" == cookie.Value {
-			return nil
-		}
 		val := make(map[string]string)
 		if err = secureCookie.Decode(cookieName, cookie.Value, &val); err != nil {
-			// most likely expired cookie, so ignore. Ideally should delete the
-			// cookie, but that requires access to http.ResponseWriter, so not
-			// convenient for us
+			// most likely expired or deleted cookie, so ignore. Ideally should
+			// delete the cookie, but that requires access to http.ResponseWriter,
+			// so not convenient for us
 			//fmt.Printf("Error decoding cookie %s\n", err)
 			return nil
 		}
@@ -74,13 +85,19 @@ func getSecureCookie(r *http.Request) *SecureCookieValue {
 			fmt.Printf("Error decoding cookie, no 'twittertemp' field\n")
 			return nil
 		}
+		// not present in cookies created by older versions
+		ret.SessionID = val["session"]
+		ret.LoginState = val["loginstate"]
+		ret.LoginRedirect = val["loginredirect"]
 	}
 	return ret
 }
 
+// decodeUserFromCookie returns logged in user or "" if not logged in or
+// the session is no longer valid
 func decodeUserFromCookie(r *http.Request) string {
 	cookie := getSecureCookie(r)
-	if nil == cookie {
+	if getValidSession(cookie) == nil {
 		return ""
 	}
 	return cookie.User
@@ -113,27 +130,46 @@ func getTwitter(cred *oauth.Credentials, urlStr string, params url.Values, data
 	return json.Unmarshal(bodyData, data)
 }
 
-// url: GET /oauthtwittercb?redirect=$redirect
-func handleOauthTwitterCallback(w http.ResponseWriter, r *http.Request) {
-	//fmt.Printf("handleOauthTwitterCallback()\n")
-	redirect := strings.TrimSpace(r.FormValue("redirect"))
-	if redirect == "" {
-		httpErrorf(w, "Missing redirect value for /login")
-		return
+// twitterProvider logs in with Twitter using OAuth 1.0a
+type twitterProvider struct{}
+
+func (p *twitterProvider) Name() string {
+	return twitterProviderName
+}
+
+func (p *twitterProvider) DisplayName() string {
+	return "Twitter"
+}
+
+func (p *twitterProvider) StartLogin(w http.ResponseWriter, r *http.Request, redirect string) (string, error) {
+	q := url.Values{
+		"redirect": {redirect},
+	}.Encode()
+
+	cb := loginCallbackURL(r, "/oauthtwittercb") + "?" + q
+	//fmt.Printf("handleLogin: cb=%s\n", cb)
+	tempCred, err := oauthClient.RequestTemporaryCredentials(http.DefaultClient, cb, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting temp cred, %s", err)
 	}
+	cookie := &SecureCookieValue{TwitterTemp: tempCred.Secret}
+	setSecureCookie(w, r, cookie)
+	return oauthClient.AuthorizationURL(tempCred, nil), nil
+}
+
+func (p *twitterProvider) FinishLogin(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	redirect := strings.TrimSpace(r.FormValue("redirect"))
 	tempCred := oauth.Credentials{
 		Token: r.FormValue("oauth_token"),
 	}
 	tempCred.Secret = decodeTwitterTempFromCookie(r)
 	if "" == tempCred.Secret {
-		http.Error(w, "Error getting temp token secret from cookie, ", 500)
-		return
+		return "", redirect, errors.New("error getting temp token secret from cookie")
 	}
 	//fmt.Printf("  tempCred.Secret: %s\n", tempCred.Secret)
 	tokenCred, _, err := oauthClient.RequestToken(http.DefaultClient, &tempCred, r.FormValue("oauth_verifier"))
 	if err != nil {
-		http.Error(w, "Error getting request token, "+err.Error(), 500)
-		return
+		return "", redirect, fmt.Errorf("error getting request token, %s", err)
 	}
 
 	//fmt.Printf("  tokenCred.Token: %s\n", tokenCred.Token)
@@ -144,48 +180,175 @@ func handleOauthTwitterCallback(w http.ResponseWriter, r *http.Request) {
 		"https://api.twitter.com/1.1/account/verify_credentials.json",
 		nil,
 		&info); err != nil {
-		http.Error(w, "Error getting timeline, "+err.Error(), 500)
+		return "", redirect, fmt.Errorf("error getting timeline, %s", err)
+	}
+	user, ok := info["screen_name"].(string)
+	if !ok {
+		return "", redirect, errors.New("no screen_name in twitter response")
+	}
+	//fmt.Printf("  username: %s\n", user)
+	return user, redirect, nil
+}
+
+// validateBaseURL checks BaseURL from config, which can be empty
+func validateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("BaseURL %q is not valid: %s", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("BaseURL %q must be an absolute http or https url", baseURL)
+	}
+	return nil
+}
+
+// absoluteURL returns absolute url of a given path on this server. Uses
+// BaseURL from config if set, otherwise scheme and host of the request
+func absoluteURL(r *http.Request, path string) string {
+	if config.BaseURL != "" {
+		return strings.TrimRight(config.BaseURL, "/") + path
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// safeRedirect returns redirect if it's a path on this server or "/" if
+// it's not, so that our login and logout can't be used to redirect to
+// other sites
+func safeRedirect(redirect string) string {
+	redirect = strings.TrimSpace(redirect)
+	// "//host" and "/\host" are treated by browsers as urls of another host
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.Contains(redirect, "\\") {
+		return "/"
+	}
+	// browsers ignore tabs and newlines in urls
+	for _, c := range redirect {
+		if c < 0x20 || c == 0x7f {
+			return "/"
+		}
+	}
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+	return redirect
+}
+
+// loginCallbackURL returns absolute url of a login callback handler
+func loginCallbackURL(r *http.Request, path string) string {
+	return absoluteURL(r, path)
+}
+
+// logInUser starts a new session, sets the cookie of a logged in user and
+// redirects
+func logInUser(w http.ResponseWriter, r *http.Request, id string, providerName string, redirect string) {
+	redirect = safeRedirect(redirect)
+	user := canonicalUser(id)
+	sess, err := startSession(user)
+	if err != nil {
+		logger.Errorf("startSession() for %s failed with %s", user, err)
+		http.Error(w, fmt.Sprintf("Failed to log in: %s", err), 500)
 		return
 	}
-	if user, ok := info["screen_name"].(string); ok {
-		//fmt.Printf("  username: %s\n", user)
-		cookie := getSecureCookie(r)
-		cookie.User = user
-		setSecureCookie(w, cookie)
+	cookie := getSecureCookie(r)
+	if cookie == nil {
+		cookie = &SecureCookieValue{}
 	}
+	cookie.User = user
+	cookie.SessionID = sess.ID
+	// state can only be used once
+	cookie.LoginState = ""
+	cookie.LoginRedirect = ""
+	setSecureCookie(w, r, cookie)
+	logger.Noticef("User %s logged in with %s", user, providerName)
 	http.Redirect(w, r, redirect, 302)
 }
 
-// url: GET /login?redirect=$redirect
+func finishLogin(w http.ResponseWriter, r *http.Request, p loginProvider) {
+	name, redirect, err := p.FinishLogin(w, r)
+	if err != nil {
+		logger.Noticef("Failed to log in with %s: %s", p.Name(), err)
+		http.Error(w, fmt.Sprintf("Failed to log in with %s: %s", p.DisplayName(), err), 500)
+		return
+	}
+	logInUser(w, r, userIdentity(p.Name(), name), p.Name(), redirect)
+}
+
+// url: GET /oauthtwittercb?redirect=$redirect
+func handleOauthTwitterCallback(w http.ResponseWriter, r *http.Request) {
+	//fmt.Printf("handleOauthTwitterCallback()\n")
+	p := findLoginProvider(twitterProviderName)
+	if p == nil {
+		http404(w, r)
+		return
+	}
+	finishLogin(w, r, p)
+}
+
+// url: GET /oauthcb/{provider}?code=$code&state=$state
+func handleOauthCallback(w http.ResponseWriter, r *http.Request) {
+	p := findLoginProvider(mux.Vars(r)["provider"])
+	if p == nil {
+		http404(w, r)
+		return
+	}
+	finishLogin(w, r, p)
+}
+
+// url: GET /login?redirect=$redirect[&provider=$provider]
+// if there's more than one login provider and provider is not given, shows
+// a page to pick one
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	redirect := strings.TrimSpace(r.FormValue("redirect"))
 	if redirect == "" {
 		httpErrorf(w, "Missing redirect value for /login")
 		return
 	}
-	q := url.Values{
-		"redirect": {redirect},
-	}.Encode()
-
-	cb := "http://" + r.Host + "/oauthtwittercb" + "?" + q
-	//fmt.Printf("handleLogin: cb=%s\n", cb)
-	tempCred, err := oauthClient.RequestTemporaryCredentials(http.DefaultClient, cb, nil)
+	redirect = safeRedirect(redirect)
+	providerName := strings.TrimSpace(r.FormValue("provider"))
+	if providerName == "" {
+		if len(loginProviders) != 1 {
+			serveLoginChooser(w, r, redirect)
+			return
+		}
+		providerName = loginProviders[0].Name()
+	}
+	p := findLoginProvider(providerName)
+	if p == nil {
+		httpErrorf(w, "Unknown login provider %q", providerName)
+		return
+	}
+	authURL, err := p.StartLogin(w, r, redirect)
 	if err != nil {
-		http.Error(w, "Error getting temp cred, "+err.Error(), 500)
+		logger.Errorf("StartLogin() with %s failed with %s", p.Name(), err)
+		http.Error(w, fmt.Sprintf("Error logging in with %s, %s", p.DisplayName(), err), 500)
 		return
 	}
-	cookie := &SecureCookieValue{TwitterTemp: tempCred.Secret}
-	setSecureCookie(w, cookie)
-	http.Redirect(w, r, oauthClient.AuthorizationURL(tempCred, nil), 302)
+	http.Redirect(w, r, authURL, 302)
 }
 
-// url: GET /logout?redirect=$redirect
+// url: POST /logout?redirect=$redirect&csrf=$csrf
 func handleLogout(w http.ResponseWriter, r *http.Request) {
 	redirect := strings.TrimSpace(r.FormValue("redirect"))
 	if redirect == "" {
 		httpErrorf(w, "Missing redirect value for /logout")
 		return
 	}
-	deleteSecureCookie(w)
+	redirect = safeRedirect(redirect)
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	if sess := getValidSession(getSecureCookie(r)); sess != nil {
+		if err := userStore.RevokeSession(sess.ID); err != nil {
+			logger.Errorf("RevokeSession() failed with %s", err)
+		}
+	}
+	deleteSecureCookie(w, r)
 	http.Redirect(w, r, redirect, 302)
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		redirect, exp string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/app/foo", "/app/foo"},
+		{" /app/foo?msg=a%20b ", "/app/foo?msg=a%20b"},
+		{"/app/foo#bar", "/app/foo#bar"},
+		{"http://evil.example.com", "/"},
+		{"https://evil.example.com/app/foo", "/"},
+		{"//evil.example.com", "/"},
+		{"/\\evil.example.com", "/"},
+		{"/\t/evil.example.com", "/"},
+		{"/\n/evil.example.com", "/"},
+		{"javascript:alert(1)", "/"},
+		{"app/foo", "/"},
+	}
+	for _, test := range tests {
+		if got := safeRedirect(test.redirect); got != test.exp {
+			t.Errorf("safeRedirect(%q) = %q, expected %q", test.redirect, got, test.exp)
+		}
+	}
+}
+
+func TestExternalRedirectsRefused(t *testing.T) {
+	defer setupTestUserStore(t, "redirecttest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	config.LocalAccounts = true
+	if err := initLoginProviders(); err != nil {
+		t.Fatal(err)
+	}
+	evil := "https://evil.example.com/"
+
+	// with a single provider, /login goes straight to it
+	rr := getURL(h, "/login?redirect="+url.QueryEscape(evil))
+	if loc := rr.Header().Get("Location"); loc != "/local/login?redirect=%2F" {
+		t.Fatalf("/login redirected to %q", loc)
+	}
+
+	hash, err := hashPassword("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = userStore.CreateAccount("alice", "alice@example.com", hash); err != nil {
+		t.Fatal(err)
+	}
+	if err = userStore.SetAccountVerified("alice"); err != nil {
+		t.Fatal(err)
+	}
+	vals := url.Values{"name": {"alice"}, "password": {"password1"}, "redirect": {"//evil.example.com"}}
+	rr = postLocalForm(t, h, "/local/login", vals)
+	if rr.Code != 302 || rr.Header().Get("Location") != "/" {
+		t.Fatalf("/local/login returned %d and redirected to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	cookies := rr.Result().Cookies()
+	vals = url.Values{"redirect": {evil}, "csrf": {csrfFromCookies(cookies)}}
+	rr = postForm(h, "/logout", vals, cookies)
+	if rr.Code != 302 || rr.Header().Get("Location") != "/" {
+		t.Fatalf("/logout returned %d and redirected to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	defer func() { config.BaseURL = "" }()
+	config.BaseURL = ""
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "localhost:5000"
+	if u := absoluteURL(r, "/oauthcb/github"); u != "http://localhost:5000/oauthcb/github" {
+		t.Fatalf("unexpected url %q", u)
+	}
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if u := absoluteURL(r, "/oauthcb/github"); u != "https://localhost:5000/oauthcb/github" {
+		t.Fatalf("unexpected url %q", u)
+	}
+
+	// BaseURL takes precedence over possibly spoofed Host header
+	config.BaseURL = "https://www.apptranslator.org/"
+	r.Host = "evil.example.com"
+	if u := absoluteURL(r, "/local/verify"); u != "https://www.apptranslator.org/local/verify" {
+		t.Fatalf("unexpected url %q", u)
+	}
+
+	for _, s := range []string{"www.apptranslator.org", "ftp://apptranslator.org", "https://"} {
+		if validateBaseURL(s) == nil {
+			t.Errorf("BaseURL %q should be invalid", s)
+		}
+	}
+	if err := validateBaseURL("https://www.apptranslator.org"); err != nil {
+		t.Errorf("validateBaseURL() failed with %s", err)
+	}
+}
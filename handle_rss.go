@@ -2,10 +2,11 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,118 +14,238 @@ import (
 	atom "github.com/thomas11/atomgenerator"
 )
 
-const tmplRssAll = `
-Recent {{.AppName}} translations:
-<ul>
-{{range .Translations}}
-<li>'{{.User}}' translated '{{.Text}}' as '{{.Translation}}' in language {{.Lang}}</li>
-{{end}}
-</ul>
-`
+// Atom feeds. Entry ids are generated by atomgenerator from the link
+// (including the fragment) and the date, so every entry links to a unique
+// fragment, e.g. #e${editID}, which makes ids stable
 
-const tmplRssOneLang = `
-<p>Untranslated strings: {{.UntranslatedCount}}</p>
+const feedMaxEntries = 50
 
-<p>Recent {{.AppName}} translations for language {{.Lang}}
-<ul>
-{{range .Translations}}
-<li>'{{.User}}' translated '{{.Text}}' as '{{.Translation}}' in language {{.Lang}}</li>
-{{end}}
-</ul>
-</p>
-`
-
-var tRssAll = template.Must(template.New("rssall").Parse(tmplRssAll))
-var tRssForLang = template.Must(template.New("rssforlang").Parse(tmplRssOneLang))
+func appPath(app *App, rest ...string) string {
+	path := "/app/" + url.PathEscape(app.Name)
+	for _, s := range rest {
+		path += "/" + url.PathEscape(s)
+	}
+	return path
+}
 
-type RssModel struct {
-	AppName      string
-	Translations []store.Edit
-	// only valid for tmplRssOneLang
-	Lang              string
-	UntranslatedCount int
+// newFeed creates a feed whose link is url of the current request. updated
+// is the time of the newest entry or, if there are none, a fallback
+func newFeed(r *http.Request, title string, updated time.Time) *atom.Feed {
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	return &atom.Feed{
+		Title:   title,
+		Link:    absoluteURL(r, r.URL.RequestURI()),
+		PubDate: updated,
+	}
 }
 
-// returns "" on error
-func templateToString(t *template.Template, data interface{}) string {
-	var buf bytes.Buffer
-	err := t.Execute(&buf, data)
+func serveFeed(w http.ResponseWriter, feed *atom.Feed) {
+	d, err := feed.GenXml()
 	if err != nil {
-		logger.Errorf("Failed to execute template %q, error: %s", t.Name(), err)
-		return ""
+		logger.Errorf("GenXml() failed with %s", err)
+		http.Error(w, "Failed to generate XML feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(d)
+}
+
+func editEntry(r *http.Request, appName string, e *store.Edit) *atom.Entry {
+	title := fmt.Sprintf("%s translated '%s' into %s", e.User, strTruncate(e.Text, 42), store.LangNameByCode(e.Lang))
+	if e.Translation == "" {
+		title = fmt.Sprintf("%s removed %s translation of '%s'", e.User, store.LangNameByCode(e.Lang), strTruncate(e.Text, 42))
+	}
+	desc := fmt.Sprintf("<p>%s</p><p>%s</p>", template.HTMLEscapeString(e.Text), template.HTMLEscapeString(e.Translation))
+	path := fmt.Sprintf("/app/%s/%s#e%d", url.PathEscape(appName), e.Lang, e.ID)
+	entry := &atom.Entry{
+		Title:       title,
+		Link:        absoluteURL(r, path),
+		Description: desc,
+		PubDate:     e.Time,
 	}
-	return string(buf.Bytes())
+	entry.AddAuthor(atom.Author{Name: e.User})
+	return entry
 }
 
-func getRssAll(app *App) string {
-	edits := app.store.RecentEdits(10)
-	pubTime := time.Now()
+// url: /rss?app=$app[&lang=$lang]
+func serveEditsFeed(w http.ResponseWriter, r *http.Request, app *App, lang string) {
+	var edits []store.Edit
+	title := fmt.Sprintf("%s translations on AppTranslator", app.Name)
+	if lang == "" {
+		edits = app.store.RecentEdits(feedMaxEntries)
+	} else {
+		edits = app.store.EditsForLang(lang, feedMaxEntries)
+		title = fmt.Sprintf("%s %s translations on AppTranslator (%d untranslated)", app.Name, store.LangNameByCode(lang), app.store.UntranslatedForLang(lang))
+	}
+	_, updated := app.store.RevisionTime()
 	if len(edits) > 0 {
-		pubTime = edits[0].Time
+		updated = edits[0].Time
 	}
+	feed := newFeed(r, title, updated)
+	for i := range edits {
+		feed.AddEntry(editEntry(r, app.Name, &edits[i]))
+	}
+	serveFeed(w, feed)
+}
 
-	title := fmt.Sprintf("%s translations on AppTranslator.org", app.Name)
-	// TODO: technically should url-escape
-	link := fmt.Sprintf("http://www.apptranslator.org/rss?app=%s", app.Name)
-	feed := &atom.Feed{
-		Title:   title,
-		Link:    link,
-		PubDate: pubTime,
+// url: /rss?user=$user
+// edits by a user in all apps
+func serveUserFeed(w http.ResponseWriter, r *http.Request, user string) {
+	type appEdit struct {
+		app  string
+		edit store.Edit
 	}
-	model := &RssModel{AppName: app.Name, Translations: edits}
-	html := templateToString(tRssAll, model)
-	link = fmt.Sprintf("http://www.apptranslator.org/app/%s", app.Name)
-	e := &atom.Entry{
-		Title:       title,
-		Link:        link,
-		Description: html,
-		PubDate:     pubTime}
-	feed.AddEntry(e)
+	var edits []appEdit
+	for _, app := range appState.Apps {
+		appEdits := app.store.EditsByUser(user)
+		if len(appEdits) > feedMaxEntries {
+			appEdits = appEdits[:feedMaxEntries]
+		}
+		for _, e := range appEdits {
+			edits = append(edits, appEdit{app.Name, e})
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].edit.Time.After(edits[j].edit.Time)
+	})
+	if len(edits) > feedMaxEntries {
+		edits = edits[:feedMaxEntries]
+	}
+	var updated time.Time
+	if len(edits) > 0 {
+		updated = edits[0].edit.Time
+	}
+	feed := newFeed(r, fmt.Sprintf("Translations by %s on AppTranslator", user), updated)
+	for i := range edits {
+		feed.AddEntry(editEntry(r, edits[i].app, &edits[i].edit))
+	}
+	serveFeed(w, feed)
+}
 
-	s, err := feed.GenXml()
-	if err != nil {
-		return "Failed to generate XML feed"
+// url: /rss?app=$app&type=strings
+// strings that were added to the app, one entry per upload
+func serveNewStringsFeed(w http.ResponseWriter, r *http.Request, app *App) {
+	added := app.store.RecentlyAddedStrings(feedMaxEntries)
+	_, updated := app.store.RevisionTime()
+	if len(added) > 0 {
+		updated = added[0].Time
 	}
-	return string(s)
+	feed := newFeed(r, fmt.Sprintf("New %s strings on AppTranslator", app.Name), updated)
+	for _, a := range added {
+		var desc strings.Builder
+		desc.WriteString("<ul>")
+		for _, s := range a.Strings {
+			fmt.Fprintf(&desc, "<li>%s</li>", template.HTMLEscapeString(s))
+		}
+		desc.WriteString("</ul>")
+		title := fmt.Sprintf("%d new strings to translate", len(a.Strings))
+		if len(a.Strings) == 1 {
+			title = fmt.Sprintf("New string to translate: '%s'", strTruncate(a.Strings[0], 42))
+		}
+		feed.AddEntry(&atom.Entry{
+			Title:       title,
+			Link:        absoluteURL(r, fmt.Sprintf("%s#r%d", appPath(app), a.Revision)),
+			Description: desc.String(),
+			PubDate:     a.Time,
+		})
+	}
+	feed.AddAuthor(atom.Author{Name: app.Name})
+	serveFeed(w, feed)
 }
 
-func getRssForLang(app *App, lang string) string {
-	pubTime := time.Now()
-	edits := app.store.EditsForLang(lang, 10)
+// url: /rss?app=$app&lang=$lang&type=review
+// translations that maintainers haven't reviewed since they changed
+func serveReviewFeed(w http.ResponseWriter, r *http.Request, app *App, lang string) {
+	li := findLangInfo(app.store.LangInfos(), lang)
+	reviews := app.store.Reviews(lang)
+	pending := make(map[string]bool)
+	for _, tr := range li.ActiveStrings {
+		rv := reviews[tr.String]
+		if tr.IsTranslated() && (rv == nil || rv.Translation != tr.Current()) {
+			pending[tr.String] = true
+		}
+	}
+	// the latest edit of each pending string, newest first
+	var edits []store.Edit
+	for _, e := range app.store.EditsForLang(lang, -1) {
+		if pending[e.Text] {
+			edits = append(edits, e)
+			delete(pending, e.Text)
+			if len(edits) >= feedMaxEntries {
+				break
+			}
+		}
+	}
+	_, updated := app.store.RevisionTime()
 	if len(edits) > 0 {
-		pubTime = edits[0].Time
+		updated = edits[0].Time
 	}
-
-	title := fmt.Sprintf("%s %s translations on AppTranslator.org", app.Name, lang)
-	// TODO: technically should url-escape
-	link := fmt.Sprintf("http://www.apptranslator.org/rss?app=%s&lang=%s", app.Name, lang)
-	feed := &atom.Feed{
-		Title:   title,
-		Link:    link,
-		PubDate: pubTime,
+	title := fmt.Sprintf("%s %s translations to review on AppTranslator", app.Name, store.LangNameByCode(lang))
+	feed := newFeed(r, title, updated)
+	for i := range edits {
+		feed.AddEntry(editEntry(r, app.Name, &edits[i]))
 	}
+	serveFeed(w, feed)
+}
 
-	model := &RssModel{AppName: app.Name, Translations: edits}
-	model.UntranslatedCount = app.store.UntranslatedForLang(lang)
-	html := templateToString(tRssForLang, model)
-	title = fmt.Sprintf("%d missing %s %s translations", model.UntranslatedCount, app.Name, lang)
-	link = fmt.Sprintf("http://www.apptranslator.org/app/%s/%s", app.Name, lang)
-	e := &atom.Entry{
-		Title:       title,
-		Link:        link,
-		Description: html,
-		PubDate:     pubTime}
-	feed.AddEntry(e)
+// url: /rss?app=$app&type=comments
+func serveCommentsFeed(w http.ResponseWriter, r *http.Request, app *App) {
+	comments := app.store.RecentComments(feedMaxEntries)
+	var updated time.Time
+	if len(comments) > 0 {
+		updated = comments[0].Time
+	}
+	feed := newFeed(r, fmt.Sprintf("Comments about %s strings on AppTranslator", app.Name), updated)
+	for _, c := range comments {
+		lang := c.Lang
+		if lang == "" {
+			lang = "all languages"
+		}
+		q := url.Values{}
+		q.Set("string", c.String)
+		if c.Lang != "" {
+			q.Set("lang", c.Lang)
+		}
+		link := fmt.Sprintf("%s?%s#%d", appPath(app, "comments"), q.Encode(), c.Time.Unix())
+		e := &atom.Entry{
+			Title:       fmt.Sprintf("%s commented on '%s' (%s)", c.User, strTruncate(c.String, 42), lang),
+			Link:        absoluteURL(r, link),
+			Description: template.HTMLEscapeString(c.Text),
+			PubDate:     c.Time,
+		}
+		e.AddAuthor(atom.Author{Name: c.User})
+		feed.AddEntry(e)
+	}
+	serveFeed(w, feed)
+}
 
-	s, err := feed.GenXml()
-	if err != nil {
-		return "Failed to generate XML feed"
+// usersETag returns ETag for a feed that depends on all apps
+func usersETag() (string, time.Time) {
+	tag := "rssuser"
+	var modTime time.Time
+	for _, app := range appState.Apps {
+		rev, t := app.store.RevisionTime()
+		tag += fmt.Sprintf("-%d", rev)
+		if t.After(modTime) {
+			modTime = t
+		}
 	}
-	return string(s)
+	return `"` + tag + `"`, modTime
 }
 
-// url: /rss?app=$app[&lang=$lang]
+// url: /rss?app=$app[&lang=$lang][&type=comments|strings|review]
+// url: /rss?user=$user
 func handleRss(w http.ResponseWriter, r *http.Request) {
+	if user := strings.TrimSpace(r.FormValue("user")); user != "" {
+		etag, modTime := usersETag()
+		if checkNotModified(w, r, etag, modTime) {
+			return
+		}
+		serveUserFeed(w, r, user)
+		return
+	}
 	appName := strings.TrimSpace(r.FormValue("app"))
 	app := findApp(appName)
 	if app == nil {
@@ -132,16 +253,40 @@ func handleRss(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	lang := strings.TrimSpace(r.FormValue("lang"))
-	if 0 == len(lang) {
-		s := getRssAll(app)
-		w.Write([]byte(s))
-		return
-	}
-
-	if !store.IsValidLangCode(lang) {
+	if lang != "" && !store.IsValidLangCode(lang) {
 		httpErrorf(w, "Language %q is not valid", lang)
 		return
 	}
-
-	w.Write([]byte(getRssForLang(app, lang)))
+	switch strings.TrimSpace(r.FormValue("type")) {
+	case "comments":
+		// comments don't change the revision of translations
+		var modTime time.Time
+		if comments := app.store.RecentComments(1); len(comments) > 0 {
+			modTime = comments[0].Time
+		}
+		etag := fmt.Sprintf(`"rsscomments-%d"`, app.store.CommentsCount())
+		if checkNotModified(w, r, etag, modTime) {
+			return
+		}
+		serveCommentsFeed(w, r, app)
+	case "strings":
+		if checkAppNotModified(w, r, app, "rssstrings") {
+			return
+		}
+		serveNewStringsFeed(w, r, app)
+	case "review":
+		if lang == "" {
+			httpErrorf(w, "Language is required for review feed")
+			return
+		}
+		if checkAppNotModified(w, r, app, "rssreview", app.store.ReviewsCount()) {
+			return
+		}
+		serveReviewFeed(w, r, app, lang)
+	default:
+		if checkAppNotModified(w, r, app, "rss") {
+			return
+		}
+		serveEditsFeed(w, r, app, lang)
+	}
 }
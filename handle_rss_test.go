@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testFeed struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Entries []struct {
+		ID      string `xml:"id"`
+		Title   string `xml:"title"`
+		Updated string `xml:"updated"`
+		Link    struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func getFeed(t *testing.T, url string) *testFeed {
+	rr := httptest.NewRecorder()
+	handleRss(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != 200 || rr.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("%s returned %d %v %s", url, rr.Code, rr.Header(), rr.Body.String())
+	}
+	var feed testFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("%s: %s", url, err)
+	}
+	return &feed
+}
+
+func TestAtomFeeds(t *testing.T) {
+	prevBaseURL := config.BaseURL
+	config.BaseURL = "https://example.com"
+	defer func() { config.BaseURL = prevBaseURL }()
+	app, cleanup := setupTestApp(t, "TestApp", "rsstest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range [][]string{{"foo", "fuu", "pl"}, {"bar", "baar", "pl"}, {"foo", "fü", "de"}} {
+		if err := app.store.WriteNewTranslation(tr[0], tr[1], tr[2], "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed := getFeed(t, "/rss?app=TestApp")
+	if len(feed.Entries) != 3 || feed.ID != "https://example.com/rss?app=TestApp" {
+		t.Fatalf("unexpected feed %#v", feed)
+	}
+	e := feed.Entries[0]
+	if e.Title != "alice translated 'foo' into German" || e.Link.Href != "https://example.com/app/TestApp/de#e2" ||
+		!strings.HasPrefix(e.ID, "tag:example.com,") || !strings.HasSuffix(e.ID, ":/app/TestApp/de/e2") {
+		t.Fatalf("unexpected entry %#v", e)
+	}
+	if feed.Updated != e.Updated {
+		t.Fatalf("feed updated %s should be the time of the newest entry %s", feed.Updated, e.Updated)
+	}
+	// ids don't change when new edits are added
+	if err := app.store.WriteNewTranslation("bar", "bar2", "pl", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	feed = getFeed(t, "/rss?app=TestApp&lang=pl")
+	if len(feed.Entries) != 3 || feed.Entries[1].ID != strings.Replace(e.ID, "de/e2", "pl/e1", 1) {
+		t.Fatalf("unexpected feed %#v", feed)
+	}
+
+	if feed = getFeed(t, "/rss?user=bob"); len(feed.Entries) != 1 || feed.Entries[0].Title != "bob translated 'bar' into Polish" {
+		t.Fatalf("unexpected user feed %#v", feed)
+	}
+
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar", "baz"}); err != nil {
+		t.Fatal(err)
+	}
+	feed = getFeed(t, "/rss?app=TestApp&type=strings")
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "New string to translate: 'baz'" || feed.Entries[1].Title != "2 new strings to translate" {
+		t.Fatalf("unexpected strings feed %#v", feed)
+	}
+
+	if err := app.store.WriteReview("foo", "pl", "kjk"); err != nil {
+		t.Fatal(err)
+	}
+	feed = getFeed(t, "/rss?app=TestApp&lang=pl&type=review")
+	if len(feed.Entries) != 1 || !strings.HasSuffix(feed.Entries[0].Link.Href, "/app/TestApp/pl#e3") {
+		t.Fatalf("unexpected review feed %#v", feed)
+	}
+}
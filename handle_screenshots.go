@@ -0,0 +1,257 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+	"github.com/kjk/u"
+)
+
+const (
+	maxScreenshotSize = 8 * 1024 * 1024
+	// a small compressed file can decode to a huge image, so we check the
+	// dimensions before decoding
+	maxScreenshotPixels = 40 * 1000 * 1000
+	thumbnailMaxDx      = 240
+	thumbnailMaxDy      = 160
+)
+
+var screenshotFileNameRx = regexp.MustCompile(`^[0-9a-f]{40}(_thumb)?\.(png|jpg|gif)$`)
+
+// screenshots are stored next to translations.csv
+func (a *App) screenshotsDir() string {
+	return filepath.Join(getDataDir(), a.DataDir, "screenshots")
+}
+
+func thumbnailFileName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	return fileName[:len(fileName)-len(ext)] + "_thumb.png"
+}
+
+// makeThumbnail scales down img to fit within maxDx x maxDy by averaging
+// pixels of the source image
+func makeThumbnail(img image.Image, maxDx, maxDy int) image.Image {
+	b := img.Bounds()
+	dx, dy := b.Dx(), b.Dy()
+	if dx <= maxDx && dy <= maxDy {
+		return img
+	}
+	scale := float64(maxDx) / float64(dx)
+	if s := float64(maxDy) / float64(dy); s < scale {
+		scale = s
+	}
+	tdx := int(float64(dx) * scale)
+	tdy := int(float64(dy) * scale)
+	if tdx < 1 {
+		tdx = 1
+	}
+	if tdy < 1 {
+		tdy = 1
+	}
+	res := image.NewRGBA(image.Rect(0, 0, tdx, tdy))
+	for y := 0; y < tdy; y++ {
+		sy0 := b.Min.Y + y*dy/tdy
+		sy1 := b.Min.Y + (y+1)*dy/tdy
+		for x := 0; x < tdx; x++ {
+			sx0 := b.Min.X + x*dx/tdx
+			sx1 := b.Min.X + (x+1)*dx/tdx
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			c := color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			}
+			res.Set(x, y, c)
+		}
+	}
+	return res
+}
+
+// saves screenshot and its thumbnail in app's screenshots directory and
+// returns the name of the file. The name is sha1 of the content so
+// uploading the same screenshot again doesn't create a new file
+func saveScreenshot(app *App, d []byte) (string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(d))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %s", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxScreenshotPixels/cfg.Height {
+		return "", fmt.Errorf("image is too big (%dx%d)", cfg.Width, cfg.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(d))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %s", err)
+	}
+	ext := ""
+	switch format {
+	case "png":
+		ext = ".png"
+	case "jpeg":
+		ext = ".jpg"
+	case "gif":
+		ext = ".gif"
+	default:
+		return "", fmt.Errorf("unsupported image format %q", format)
+	}
+	dir := app.screenshotsDir()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	fileName := sha1HexOfBytes(d) + ext
+	path := filepath.Join(dir, fileName)
+	if !u.PathExists(path) {
+		if err = ioutil.WriteFile(path, d, 0644); err != nil {
+			return "", err
+		}
+	}
+	thumbPath := filepath.Join(dir, thumbnailFileName(fileName))
+	if !u.PathExists(thumbPath) {
+		var buf bytes.Buffer
+		thumb := makeThumbnail(img, thumbnailMaxDx, thumbnailMaxDy)
+		if err = png.Encode(&buf, thumb); err != nil {
+			return "", err
+		}
+		if err = ioutil.WriteFile(thumbPath, buf.Bytes(), 0644); err != nil {
+			return "", err
+		}
+	}
+	return fileName, nil
+}
+
+// returns strings identified either by their text (in strings argument, one
+// per line) or by their ids (in ids argument, comma-separated)
+func screenshotStringsArg(app *App, r *http.Request) ([]string, error) {
+	res := make([]string, 0)
+	s := normalizeNewlines(r.FormValue("strings"))
+	for _, str := range strings.Split(s, "\n") {
+		if str == "" {
+			continue
+		}
+		if _, ok := app.store.StringID(str); !ok {
+			return nil, fmt.Errorf("string %q doesn't exist", str)
+		}
+		res = append(res, str)
+	}
+	for _, idStr := range strings.Split(r.FormValue("ids"), ",") {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid string id", idStr)
+		}
+		str, ok := app.store.StringByID(id)
+		if !ok {
+			return nil, fmt.Errorf("string with id %d doesn't exist", id)
+		}
+		res = append(res, str)
+	}
+	return res, nil
+}
+
+// url: POST /uploadscreenshot?app=$appName
+// Needs API token with upload-strings scope
+// POST data is multipart/form-data with:
+// file    - png, jpeg or gif image
+// strings - strings shown in the screenshot, one per line
+// ids     - ids of strings shown in the screenshot, comma-separated
+// Returns the name under which the screenshot was saved
+func handleUploadScreenshot(w http.ResponseWriter, r *http.Request) {
+	// leave some space for other form fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxScreenshotSize+64*1024)
+	appName := strings.TrimSpace(r.FormValue("app"))
+	app := findApp(appName)
+	if app == nil {
+		logger.Noticef("Someone tried to upload screenshot for non-existing app %s", appName)
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	uploader := authAPIRequest(w, r, app, store.ScopeUploadStrings)
+	if uploader == "" {
+		return
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		httpErrorf(w, "Missing file: %s", err)
+		return
+	}
+	defer f.Close()
+	d, err := ioutil.ReadAll(f)
+	if err != nil {
+		httpErrorf(w, "Failed to read file: %s", err)
+		return
+	}
+	strs, err := screenshotStringsArg(app, r)
+	if err != nil {
+		httpErrorf(w, "%s", err)
+		return
+	}
+	if len(strs) == 0 {
+		httpErrorf(w, "No strings given for the screenshot")
+		return
+	}
+	fileName, err := saveScreenshot(app, d)
+	if err != nil {
+		logger.Errorf("saveScreenshot() failed with %s", err)
+		httpErrorf(w, "Failed to save screenshot: %s", err)
+		return
+	}
+	if err = app.store.WriteScreenshot(fileName, strs); err != nil {
+		httpErrorf(w, "Failed to save screenshot: %s", err)
+		return
+	}
+	if err = app.store.WriteUpload(uploader, "screenshot", fileName); err != nil {
+		logger.Errorf("WriteUpload() failed with %s", err)
+	}
+	logger.Noticef("handleUploadScreenshot(): uploaded %s showing %d strings for %s", fileName, len(strs), appName)
+	w.Write([]byte(fileName + "\n"))
+}
+
+// url: /screenshot/{appname}/{file}
+func handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appName := vars["appname"]
+	app := findApp(appName)
+	if app == nil {
+		http404(w, r)
+		return
+	}
+	fileName := vars["file"]
+	if !screenshotFileNameRx.MatchString(fileName) {
+		http404(w, r)
+		return
+	}
+	// file names are sha1 of the content, so they never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	serveFileFromDir(w, r, app.screenshotsDir(), fileName)
+}
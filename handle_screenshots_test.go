@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+// pngHeader returns the start of a png file that claims to be dx x dy
+func pngHeader(dx, dy uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := make([]byte, 0, 17)
+	chunk = append(chunk, "IHDR"...)
+	chunk = append(chunk, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[4:], dx)
+	binary.BigEndian.PutUint32(chunk[8:], dy)
+	// 8 bit RGBA, default compression, filter and no interlacing
+	chunk = append(chunk, 8, 6, 0, 0, 0)
+	binary.Write(&buf, binary.BigEndian, uint32(len(chunk)-4))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestSaveScreenshotTooBig(t *testing.T) {
+	app := &App{AppConfig: AppConfig{Name: "Test"}}
+	_, err := saveScreenshot(app, pngHeader(100000, 100000))
+	if err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Fatalf("huge image should be rejected before decoding, err: %v", err)
+	}
+	_, err = saveScreenshot(app, pngHeader(16, 16))
+	if err == nil || strings.Contains(err.Error(), "too big") {
+		t.Fatalf("small image should pass the size check, err: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+const (
+	maxSearchResults = 500
+	// days argument is clamped to that, so that computing the time doesn't
+	// overflow
+	maxSearchDays = 100 * 365
+)
+
+type ModelSearch struct {
+	App          *App
+	PageTitle    string
+	User         string
+	RedirectUrl  string
+	CsrfToken    string
+	Langs        []store.Lang
+	Query        string
+	Lang         string
+	WholeWord    bool
+	Untranslated bool
+	ChangedDays  int
+	ByUser       string
+	Unused       bool
+	Results      []*store.SearchResult
+	Truncated    bool
+	// true if the user submitted the search form
+	Searched bool
+}
+
+// changedSince returns the time days ago, with days clamped to
+// maxSearchDays
+func changedSince(days int, now time.Time) time.Time {
+	if days > maxSearchDays {
+		days = maxSearchDays
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+func boolArg(r *http.Request, name string) bool {
+	v := strings.TrimSpace(r.FormValue(name))
+	return v == "1" || v == "on" || v == "true"
+}
+
+// url: /app/{appname}/search?q=${q}&lang=${lang}&word=1&untranslated=1&days=${days}&user=${user}&unused=1
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appName := vars["appname"]
+	app := findApp(appName)
+	if app == nil {
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	langCode := strings.TrimSpace(r.FormValue("lang"))
+	if langCode != "" && !store.IsValidLangCode(langCode) {
+		httpErrorf(w, "Invalid language: %q", langCode)
+		return
+	}
+	days, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("days")))
+	model := &ModelSearch{
+		App:          app,
+		PageTitle:    fmt.Sprintf("Search %s translations", app.Name),
+		User:         decodeUserFromCookie(r),
+		RedirectUrl:  r.URL.String(),
+		CsrfToken:    csrfToken(r),
+		Langs:        store.Languages[:],
+		Query:        r.FormValue("q"),
+		Lang:         langCode,
+		WholeWord:    boolArg(r, "word"),
+		Untranslated: boolArg(r, "untranslated"),
+		ChangedDays:  days,
+		ByUser:       strings.TrimSpace(r.FormValue("user")),
+		Unused:       boolArg(r, "unused"),
+	}
+	model.Searched = len(r.URL.RawQuery) > 0
+	if model.Searched {
+		q := &store.SearchQuery{
+			Text:          model.Query,
+			WholeWord:     model.WholeWord,
+			Lang:          model.Lang,
+			Untranslated:  model.Untranslated,
+			User:          model.ByUser,
+			IncludeUnused: model.Unused,
+			Max:           maxSearchResults + 1,
+		}
+		if days > 0 {
+			q.ChangedSince = changedSince(days, time.Now())
+		}
+		model.Results = app.store.Search(q)
+		if len(model.Results) > maxSearchResults {
+			model.Results = model.Results[:maxSearchResults]
+			model.Truncated = true
+		}
+	}
+	ExecTemplate(w, tmplSearch, model)
+}
@@ -0,0 +1,20 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangedSince(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := changedSince(1, now); !got.Equal(now.AddDate(0, 0, -1)) {
+		t.Fatalf("changedSince(1) is %s", got)
+	}
+	exp := now.AddDate(0, 0, -maxSearchDays)
+	for _, days := range []int{maxSearchDays, 200000, 1 << 62} {
+		if got := changedSince(days, now); !got.Equal(exp) {
+			t.Fatalf("changedSince(%d) is %s, exp: %s", days, got, exp)
+		}
+	}
+}
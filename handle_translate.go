@@ -0,0 +1,341 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+const (
+	translateModeUntranslated = "untranslated"
+	translateModeReview       = "review"
+	// how many translations in other languages to show as context
+	maxOtherTranslations = 6
+	maxSuggestions       = 5
+	minSuggestionScore   = 40
+)
+
+// OtherTranslation is a translation of the string in another language
+type OtherTranslation struct {
+	Lang        string `json:"lang"`
+	LangName    string `json:"langName"`
+	Translation string `json:"translation"`
+}
+
+// TranslateItem is a single string shown in sequential translation mode
+type TranslateItem struct {
+	String      string             `json:"string"`
+	Current     string             `json:"current"`
+	History     []string           `json:"history"`
+	Ref         string             `json:"ref"`
+	Others      []OtherTranslation `json:"others"`
+	Suggestions []store.Suggestion `json:"suggestions"`
+	// why the translation needs a review, only in review mode
+	ReviewReason string `json:"reviewReason"`
+	// names of screenshot files showing the string
+	Screenshots []string `json:"screenshots"`
+}
+
+type ModelTranslate struct {
+	App         *App
+	LangInfo    *store.LangInfo
+	RefLangInfo *store.LangInfo
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	Mode        string
+	ItemsCount  int
+	// json-encoded []*TranslateItem
+	ItemsJSON string
+}
+
+// extract string formatting instructions (%s, %d etc.) from s.
+// Must match extractFormattingModifiers() in javascript
+func extractFormattingModifiers(s string) string {
+	res := make([]byte, 0)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+1 < len(s) {
+			i++
+			res = append(res, s[i])
+		}
+	}
+	return string(res)
+}
+
+// translationReviewReason returns a reason why a translation looks
+// suspicious or "" if it looks fine
+func translationReviewReason(s, trans string) string {
+	if trans == "" {
+		return ""
+	}
+	if extractFormattingModifiers(s) != extractFormattingModifiers(trans) {
+		return "string formatting directives (%s, %d etc.) don't match"
+	}
+	if strings.TrimSpace(trans) == "" {
+		return "translation is empty"
+	}
+	if s == trans && strings.IndexFunc(s, isLetter) != -1 {
+		return "translation is the same as the original"
+	}
+	if strings.HasSuffix(s, "\n") != strings.HasSuffix(trans, "\n") {
+		return "trailing newline doesn't match"
+	}
+	if strings.HasSuffix(s, "...") != strings.HasSuffix(trans, "...") {
+		return "trailing '...' doesn't match"
+	}
+	return ""
+}
+
+// invalidTranslationReason returns why trans can't be saved as a translation
+// of s or "" if it can
+func invalidTranslationReason(s, trans string) string {
+	if strings.TrimSpace(trans) == "" {
+		return "translation cannot be empty"
+	}
+	if extractFormattingModifiers(s) != extractFormattingModifiers(trans) {
+		return "string formatting directives (%s, %d etc.) must be in the same order"
+	}
+	return ""
+}
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func findLangInfo(langs []*store.LangInfo, langCode string) *store.LangInfo {
+	for _, li := range langs {
+		if li.Code == langCode {
+			return li
+		}
+	}
+	return nil
+}
+
+// maps source string to current translation
+func currentTranslations(li *store.LangInfo) map[string]string {
+	res := make(map[string]string)
+	for _, tr := range li.ActiveStrings {
+		if tr.IsTranslated() {
+			res[tr.String] = tr.Current()
+		}
+	}
+	return res
+}
+
+func buildTranslateItems(app *App, langs []*store.LangInfo, li *store.LangInfo, refLang *store.LangInfo, mode string) []*TranslateItem {
+	var refTrans map[string]string
+	if refLang != nil {
+		refTrans = currentTranslations(refLang)
+	}
+	// show other languages ordered by how complete they are
+	others := make([]*store.LangInfo, 0)
+	for _, other := range langs {
+		if other.Code != li.Code && other != refLang {
+			others = append(others, other)
+		}
+	}
+	sort.Sort(sort.Reverse(store.ByUntranslated{LangInfoSeq: others}))
+	othersTrans := make([]map[string]string, len(others))
+	for i, other := range others {
+		othersTrans[i] = currentTranslations(other)
+	}
+
+	screenshots := app.store.ScreenshotsByString()
+	items := make([]*TranslateItem, 0)
+	for _, tr := range li.ActiveStrings {
+		item := &TranslateItem{
+			String:      tr.String,
+			Current:     tr.Current(),
+			History:     tr.History(),
+			Ref:         refTrans[tr.String],
+			Screenshots: screenshots[tr.String],
+		}
+		if mode == translateModeReview {
+			item.ReviewReason = translationReviewReason(tr.String, tr.Current())
+			if item.ReviewReason == "" {
+				continue
+			}
+		} else if tr.IsTranslated() {
+			continue
+		}
+		for i, m := range othersTrans {
+			if len(item.Others) >= maxOtherTranslations {
+				break
+			}
+			if trans, ok := m[tr.String]; ok {
+				other := others[i]
+				ot := OtherTranslation{Lang: other.Code, LangName: other.Name, Translation: trans}
+				item.Others = append(item.Others, ot)
+			}
+		}
+		items = append(items, item)
+	}
+
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = item.String
+	}
+	suggestions := app.store.Suggest(li.Code, strs, maxSuggestions, minSuggestionScore)
+	for i, item := range items {
+		item.Suggestions = suggestions[i]
+	}
+	return items
+}
+
+// url: /app/{appname}/{lang}/translate?mode=${mode}
+func handleTranslate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appName := vars["appname"]
+	app := findApp(appName)
+	if app == nil {
+		httpErrorf(w, "Application %q doesn't exist", appName)
+		return
+	}
+	langCode := vars["lang"]
+	if !store.IsValidLangCode(langCode) {
+		httpErrorf(w, "Invalid language: %q", langCode)
+		return
+	}
+	mode := strings.TrimSpace(r.FormValue("mode"))
+	if mode == "" {
+		mode = translateModeUntranslated
+	}
+	if mode != translateModeUntranslated && mode != translateModeReview {
+		httpErrorf(w, "Invalid mode: %q", mode)
+		return
+	}
+	user := decodeUserFromCookie(r)
+	langs := app.store.LangInfos()
+	model := &ModelTranslate{
+		App:         app,
+		LangInfo:    findLangInfo(langs, langCode),
+		PageTitle:   fmt.Sprintf("Translate %s into %s", app.Name, store.LangNameByCode(langCode)),
+		User:        user,
+		RedirectUrl: r.URL.String(),
+		CsrfToken:   csrfToken(r),
+		Mode:        mode,
+	}
+	if user != "" {
+		refLangCode := userStore.GetPref(user, refLangPrefName(langCode))
+		model.RefLangInfo = findLangInfo(langs, refLangCode)
+	}
+	items := buildTranslateItems(app, langs, model.LangInfo, model.RefLangInfo, mode)
+	model.ItemsCount = len(items)
+	d, err := json.Marshal(items)
+	if err != nil {
+		logger.Errorf("json.Marshal() failed with %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	model.ItemsJSON = string(d)
+	ExecTemplate(w, tmplTranslate, model)
+}
+
+// errInactiveString is returned by writeTranslation for a string that is not
+// used by the app
+var errInactiveString = errors.New("string is not used by the app")
+
+// writeTranslation saves a translation and notifies webhooks and browsers
+// showing the language
+func writeTranslation(app *App, str, trans, lang, user string) error {
+	if !app.store.IsActiveString(str) {
+		return errInactiveString
+	}
+	c := newTranslationChange(app, lang)
+	if err := app.store.WriteNewTranslation(str, trans, lang, user); err != nil {
+		return err
+	}
+	c.fire(store.EventTranslationAdded, str, trans, user)
+	publishLiveEdit(app, lang, str, trans, user, false)
+	return nil
+}
+
+// revertTranslation reverts a translation and notifies webhooks and
+// browsers showing the language. Returns the translation after revert
+func revertTranslation(app *App, str, lang, user string) (string, error) {
+	c := newTranslationChange(app, lang)
+	trans, err := app.store.RevertTranslation(str, lang, user)
+	if err != nil {
+		return "", err
+	}
+	c.fire(store.EventTranslationReverted, str, trans, user)
+	publishLiveEdit(app, lang, str, trans, user, true)
+	return trans, nil
+}
+
+// SaveTranslationRequest is sent as JSON body to /savetranslation
+type SaveTranslationRequest struct {
+	App         string `json:"app"`
+	Lang        string `json:"lang"`
+	String      string `json:"string"`
+	Translation string `json:"translation"`
+}
+
+// url: POST /savetranslation
+// Body is json-encoded SaveTranslationRequest, CSRF token is sent in
+// X-CSRF-Token header. Returns json-encoded {"ok": true} or {"error": $msg}
+func handleSaveTranslation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		serveJSONError(w, http.StatusMethodNotAllowed, "must be POST")
+		return
+	}
+	if !isValidCSRFToken(r) {
+		serveJSONError(w, http.StatusForbidden, "invalid or missing CSRF token, please reload the page")
+		return
+	}
+	var req SaveTranslationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		serveJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %s", err))
+		return
+	}
+	app := findApp(req.App)
+	if app == nil {
+		serveJSONError(w, http.StatusNotFound, fmt.Sprintf("application %q doesn't exist", req.App))
+		return
+	}
+	if !store.IsValidLangCode(req.Lang) {
+		serveJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid lang code %q", req.Lang))
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if user == "" {
+		serveJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if !userCanTranslate(user) {
+		logger.Noticef("Rejected translation by banned user %s", user)
+		serveJSONError(w, http.StatusForbidden, "you're not allowed to edit translations")
+		return
+	}
+	if isRateLimited(r, user) {
+		w.Header().Set("Retry-After", "60")
+		serveJSONError(w, http.StatusTooManyRequests, "too many edits, please slow down")
+		return
+	}
+	str := strings.TrimSpace(req.String)
+	if msg := invalidTranslationReason(str, req.Translation); msg != "" {
+		serveJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+	checkOverwrite(app, str, req.Lang, user, req.Translation)
+	if err := writeTranslation(app, str, req.Translation, req.Lang, user); err != nil {
+		code := http.StatusInternalServerError
+		if err == errInactiveString {
+			code = http.StatusBadRequest
+		}
+		serveJSONError(w, code, fmt.Sprintf("failed to add a translation of %q: %s", str, err))
+		return
+	}
+	serveJSON(w, map[string]interface{}{
+		"ok":           true,
+		"untranslated": app.store.UntranslatedForLang(req.Lang),
+	})
+}
@@ -0,0 +1,53 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSaveTranslation(t *testing.T) {
+	defer setupTestUserStore(t, "translatetest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "translateapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	alice := loginTestUser(t, "alice", false)
+	save := func(str string) int {
+		body := `{"app": "TestApp", "lang": "pl", "string": "` + str + `", "translation": "fuu"}`
+		return apiRequest(h, "POST", "/savetranslation", body, alice).Code
+	}
+	if code := save("foo"); code != 200 {
+		t.Fatalf("saving translation returned %d", code)
+	}
+	if code := save("bar"); code != http.StatusBadRequest {
+		t.Fatalf("saving translation of unknown string returned %d", code)
+	}
+	if n := app.store.EditsCount(); n != 1 {
+		t.Fatalf("expected 1 edit, got %d", n)
+	}
+
+	// the form used by translation pages
+	edit := func(str string) int {
+		vals := url.Values{
+			"app":         {"TestApp"},
+			"lang":        {"de"},
+			"string":      {str},
+			"translation": {"fuu"},
+			"csrf":        {csrfFromCookies(alice)},
+		}
+		return postForm(h, "/edittranslation", vals, alice).Code
+	}
+	if code := edit("foo"); code != http.StatusFound {
+		t.Fatalf("editing translation returned %d", code)
+	}
+	if code := edit("bar"); code != http.StatusBadRequest {
+		t.Fatalf("editing translation of unknown string returned %d", code)
+	}
+	if n := app.store.EditsCount(); n != 2 || app.store.IsActiveString("bar") {
+		t.Fatalf("unknown string shouldn't be added, have %d edits", n)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/kjk/apptranslator/store"
 )
 
 type CantParseError struct {
@@ -40,7 +42,8 @@ func parseUploadedStrings(s string) ([]string, error) {
 	return lines, nil
 }
 
-// url: POST /uploadstrings?app=$appName&secret=$uploadSecret
+// url: POST /uploadstrings?app=$appName
+// Needs API token with upload-strings scope
 // POST data is in the format:
 /*
 AppTranslator strings
@@ -56,10 +59,8 @@ func handleUploadStrings(w http.ResponseWriter, r *http.Request) {
 		httpErrorf(w, "Application %q doesn't exist", appName)
 		return
 	}
-	secret := strings.TrimSpace(r.FormValue("secret"))
-	if secret != app.UploadSecret {
-		logger.Noticef("Someone tried to upload strings for %s with invalid secret %s", appName, secret)
-		httpErrorf(w, "Invalid secret for app %q", appName)
+	uploader := authAPIRequest(w, r, app, store.ScopeUploadStrings)
+	if uploader == "" {
 		return
 	}
 	s := r.FormValue("strings")
@@ -73,6 +74,15 @@ func handleUploadStrings(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			logger.Errorf("UpdateStringsList() failed with %s", err)
 		} else {
+			details := fmt.Sprintf("%d strings", len(newStrings))
+			if err = app.store.WriteUpload(uploader, "strings", details); err != nil {
+				logger.Errorf("WriteUpload() failed with %s", err)
+			}
+			fireWebhooks(app, store.EventStringsUploaded, map[string]interface{}{
+				"strings":  len(newStrings),
+				"uploader": uploader,
+			})
+			live.publishToApp(app, liveEventStrings, map[string]int{"strings": len(newStrings)})
 			msg := ""
 			if len(added) > 0 {
 				msg += fmt.Sprintf("New strings: %v\n", added)
@@ -4,8 +4,18 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+const (
+	userEditsPerPage = 50
+	// the activity heatmap shows that many weeks, like GitHub
+	heatmapWeeks    = 53
+	heatmapCellSize = 12
 )
 
 type EditByUser struct {
@@ -13,45 +23,197 @@ type EditByUser struct {
 	App         string
 	Text        string
 	Translation string
+	Time        time.Time
+	// false if the translation was changed by a later edit
+	Current bool
+}
+
+// UserAppStats describes contributions of a user to an app
+type UserAppStats struct {
+	App string
+	*store.UserStats
+}
+
+// UserLangStats is the number of edits of a user in a language
+type UserLangStats struct {
+	Code  string
+	Name  string
+	Edits int
+}
+
+// HeatmapCell is a day in the activity heatmap
+type HeatmapCell struct {
+	X, Y  int
+	Color string
+	Title string
 }
 
 type ModelUser struct {
-	Name            string
-	PageTitle       string
-	TranslatedCount int
-	Edits           []EditByUser
-	User            string // TODO: change to LoginName
-	RedirectUrl     string
+	Name      string
+	PageTitle string
+	// totals of all apps
+	Stats       store.UserStats
+	Overwritten int
+	Apps        []*UserAppStats
+	Langs       []*UserLangStats
+	Heatmap     []HeatmapCell
+	HeatmapSize [2]int
+	// a page of edits, newest first
+	Edits       []EditByUser
+	Page        int
+	PrevPage    int
+	NextPage    int
+	User        string // TODO: change to LoginName
+	RedirectUrl string
+	CsrfToken   string
 }
 
-func buildModelUser(user, loginName string) *ModelUser {
-	edits := make([]EditByUser, 0)
+func heatmapColor(edits int) string {
+	switch {
+	case edits == 0:
+		return "#ebedf0"
+	case edits < 3:
+		return "#c6e48b"
+	case edits < 10:
+		return "#7bc96f"
+	case edits < 30:
+		return "#239a3b"
+	}
+	return "#196127"
+}
+
+// buildHeatmap returns cells for days of the last heatmapWeeks weeks,
+// a column per week
+func buildHeatmap(days map[time.Time]int, now time.Time) []HeatmapCell {
+	today := now.UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, -7*(heatmapWeeks-1)-int(today.Weekday()))
+	res := make([]HeatmapCell, 0, heatmapWeeks*7)
+	for i, day := 0, start; !day.After(today); i, day = i+1, day.AddDate(0, 0, 1) {
+		n := days[day]
+		res = append(res, HeatmapCell{
+			X:     (i / 7) * heatmapCellSize,
+			Y:     (i % 7) * heatmapCellSize,
+			Color: heatmapColor(n),
+			Title: fmt.Sprintf("%d edits on %s", n, day.Format("2006-01-02")),
+		})
+	}
+	return res
+}
+
+// userEditsPage returns a page of edits by user in all apps, newest first,
+// and the total number of edits. Each app only returns as many of its
+// newest edits as can be on the page. page must not be past the last page
+func userEditsPage(user string, page int) ([]EditByUser, int) {
+	offset := (page - 1) * userEditsPerPage
+	var edits []EditByUser
+	total := 0
 	for _, app := range appState.Apps {
-		for _, edit := range app.store.EditsByUser(user) {
-			var e = EditByUser{
-				Lang:        edit.Lang,
+		appEdits, n := app.store.UserEdits(user, 0, offset+userEditsPerPage)
+		total += n
+		for _, e := range appEdits {
+			edits = append(edits, EditByUser{
+				Lang:        e.Lang,
 				App:         app.Name,
-				Text:        edit.Text,
-				Translation: edit.Translation,
-			}
-			edits = append(edits, e)
+				Text:        e.Text,
+				Translation: e.Translation,
+				Time:        e.Time,
+				Current:     e.Current,
+			})
 		}
 	}
-	return &ModelUser{
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Time.After(edits[j].Time)
+	})
+	if offset > len(edits) {
+		offset = len(edits)
+	}
+	edits = edits[offset:]
+	if len(edits) > userEditsPerPage {
+		edits = edits[:userEditsPerPage]
+	}
+	return edits, total
+}
+
+func buildModelUser(user, loginName string, page int) *ModelUser {
+	model := &ModelUser{
 		PageTitle: fmt.Sprintf("Translations by %s", user),
 		Name:      user,
 		User:      loginName,
-		Edits:     edits,
+		Page:      page,
+	}
+	total := &model.Stats
+	total.Days = make(map[time.Time]int)
+	langs := make(map[string]int)
+	for _, app := range appState.Apps {
+		st := app.store.UserStats(user)
+		if st.Edits == 0 {
+			continue
+		}
+		model.Apps = append(model.Apps, &UserAppStats{App: app.Name, UserStats: st})
+		total.Edits += st.Edits
+		total.Translations += st.Translations
+		total.Current += st.Current
+		if total.First.IsZero() || st.First.Before(total.First) {
+			total.First = st.First
+		}
+		if st.Last.After(total.Last) {
+			total.Last = st.Last
+		}
+		for day, n := range st.Days {
+			total.Days[day] += n
+		}
+		for lang, n := range st.Langs {
+			langs[lang] += n
+		}
 	}
+	model.Overwritten = total.Overwritten()
+	sort.SliceStable(model.Apps, func(i, j int) bool {
+		return model.Apps[i].Edits > model.Apps[j].Edits
+	})
+	for lang, n := range langs {
+		model.Langs = append(model.Langs, &UserLangStats{lang, store.LangNameByCode(lang), n})
+	}
+	sort.Slice(model.Langs, func(i, j int) bool {
+		a, b := model.Langs[i], model.Langs[j]
+		if a.Edits != b.Edits {
+			return a.Edits > b.Edits
+		}
+		return a.Name < b.Name
+	})
+	// clamp before multiplying by the page size, which could overflow
+	last := (total.Edits + userEditsPerPage - 1) / userEditsPerPage
+	if last < 1 {
+		last = 1
+	}
+	if page > last {
+		page = last
+	}
+	model.Page = page
+	model.Heatmap = buildHeatmap(total.Days, time.Now())
+	model.HeatmapSize = [2]int{heatmapWeeks * heatmapCellSize, 7 * heatmapCellSize}
+
+	edits, n := userEditsPage(user, page)
+	model.Edits = edits
+	if page > 1 {
+		model.PrevPage = page - 1
+	}
+	if page*userEditsPerPage < n {
+		model.NextPage = page + 1
+	}
+	return model
 }
 
-// url: /user/{user}
+// url: /user/{user}[?page=${page}]
 func handleUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userName := vars["user"]
+	page, err := intArg(r, "page", 1)
+	if err != nil || page < 1 {
+		page = 1
+	}
 
-	//fmt.Printf("handleUser() user=%s\n", userName)
-	model := buildModelUser(userName, decodeUserFromCookie(r))
+	model := buildModelUser(userName, decodeUserFromCookie(r), page)
 	model.RedirectUrl = r.URL.String()
+	model.CsrfToken = csrfToken(r)
 	ExecTemplate(w, tmplUser, model)
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserProfile(t *testing.T) {
+	h := setupLoginTest(t, nil, nil)
+	app1, cleanup1 := setupTestApp(t, "ProfileApp1", "profileapp1test.dat")
+	defer cleanup1()
+	app2, cleanup2 := setupTestApp(t, "ProfileApp2", "profileapp2test.dat")
+	defer cleanup2()
+
+	var strs []string
+	for i := 0; i < 40; i++ {
+		strs = append(strs, fmt.Sprintf("str%d", i))
+	}
+	for _, app := range []*App{app1, app2} {
+		if _, _, _, err := app.store.UpdateStringsList(strs); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range strs {
+			if err := app.store.WriteNewTranslation(s, s+"-pl", "pl", "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := app1.store.WriteNewTranslation("str0", "better", "pl", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app2.store.WriteNewTranslation("str0", "str0-de", "de", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	model := buildModelUser("alice", "", 1)
+	st := model.Stats
+	if st.Edits != 81 || st.Translations != 81 || st.Current != 80 || model.Overwritten != 1 {
+		t.Fatalf("unexpected stats %#v", st)
+	}
+	if len(model.Apps) != 2 || len(model.Langs) != 2 || model.Langs[0].Code != "pl" || model.Langs[0].Edits != 80 {
+		t.Fatalf("unexpected apps %#v or langs %#v", model.Apps, model.Langs)
+	}
+	if len(model.Edits) != userEditsPerPage || model.NextPage != 2 || model.PrevPage != 0 {
+		t.Fatalf("unexpected first page: %d edits, next %d", len(model.Edits), model.NextPage)
+	}
+	if e := model.Edits[0]; e.App != "ProfileApp2" || e.Lang != "de" {
+		t.Fatalf("newest edit is %#v", e)
+	}
+	last := buildModelUser("alice", "", 2)
+	if len(last.Edits) != 81-userEditsPerPage || last.NextPage != 0 || last.PrevPage != 1 {
+		t.Fatalf("unexpected last page: %d edits, next %d", len(last.Edits), last.NextPage)
+	}
+	// page past the end shows the last page, even if it would overflow
+	for _, page := range []int{3, 184467440737095518} {
+		m := buildModelUser("alice", "", page)
+		if m.Page != 2 || len(m.Edits) != len(last.Edits) || m.NextPage != 0 {
+			t.Fatalf("page %d is %d with %d edits", page, m.Page, len(m.Edits))
+		}
+	}
+	if rr := getURL(h, "/user/alice?page=184467440737095518"); rr.Code != 200 {
+		t.Fatalf("huge page returned %d", rr.Code)
+	}
+	// the oldest edit in the first app was overwritten by bob
+	if e := last.Edits[len(last.Edits)-1]; e.Text != "str0" || e.Current {
+		t.Fatalf("oldest edit is %#v", e)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	cells := model.Heatmap
+	if n := len(cells); n < 7*(heatmapWeeks-1)+1 || n > 7*heatmapWeeks {
+		t.Fatalf("heatmap has %d days", n)
+	}
+	if c := cells[len(cells)-1]; c.Title != "81 edits on "+today.Format("2006-01-02") || c.Color != heatmapColor(81) {
+		t.Fatalf("unexpected today's cell %#v", c)
+	}
+
+	rr := getURL(h, "/user/alice?page=2")
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "81 edits of 81 strings") || !strings.Contains(rr.Body.String(), "changed since") {
+		t.Fatalf("/user/alice returned %d:\n%s", rr.Code, rr.Body.String())
+	}
+	rr = getURL(h, "/user/nobody")
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "No translations by nobody") {
+		t.Fatalf("/user/nobody returned %d", rr.Code)
+	}
+}
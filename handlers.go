@@ -30,6 +30,7 @@ const lenStatic = len("/s/")
 // url: /s/
 func handleStatic(w http.ResponseWriter, r *http.Request) {
 	file := r.URL.Path[lenStatic:]
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
 	serveFileStatic(w, r, file)
 }
 
@@ -41,6 +42,7 @@ type ModelMain struct {
 	UserIsAdmin bool
 	ErrorMsg    string
 	RedirectUrl string
+	CsrfToken   string
 }
 
 func getAppArg(w http.ResponseWriter, r *http.Request) *App {
@@ -76,15 +78,19 @@ func handleMain(w http.ResponseWriter, r *http.Request) {
 	model := &ModelMain{
 		Apps:        &appState.Apps,
 		User:        user,
-		UserIsAdmin: false,
+		UserIsAdmin: userCanSeeAdminPage(user),
 		RedirectUrl: r.URL.String(),
+		CsrfToken:   csrfToken(r),
 		PageTitle:   "AppTranslator - crowd-sourced translation for software"}
 
 	ExecTemplate(w, tmplMain, model)
 }
 
-// url: /edittranslation?string=${string}&translation=${translation}
+// url: POST /edittranslation?string=${string}&translation=${translation}
 func handleEditTranslation(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
 	app, langCode := getAppLangArg(w, r)
 	if app == nil {
 		return
@@ -94,10 +100,19 @@ func handleEditTranslation(w http.ResponseWriter, r *http.Request) {
 		httpErrorf(w, "User doesn't exist")
 		return
 	}
+	if !userCanTranslate(user) {
+		logger.Noticef("Rejected translation by banned user %s", user)
+		httpErrorf(w, "User %s is not allowed to edit translations", user)
+		return
+	}
+	if !checkRateLimit(w, r, user) {
+		return
+	}
 	str := strings.TrimSpace(r.FormValue("string"))
 	translation := r.FormValue("translation")
+	checkOverwrite(app, str, langCode, user, translation)
 
-	if err := app.store.WriteNewTranslation(str, translation, langCode, user); err != nil {
+	if err := writeTranslation(app, str, translation, langCode, user); err != nil {
 		httpErrorf(w, "Failed to add a translation %q", err)
 		return
 	}
@@ -106,8 +121,11 @@ func handleEditTranslation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
-// url: /duptranslation?string=${string}&duplicate=${duplicate}lang=${langCode}
+// url: POST /duptranslation?string=${string}&duplicate=${duplicate}lang=${langCode}
 func handleDuplicateTranslation(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
 	app, langCode := getAppLangArg(w, r)
 	if app == nil {
 		return
@@ -138,23 +156,62 @@ func handleDuplicateTranslation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+const httpWriteTimeout = 5 * time.Second
+
 // // https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/
 func makeHTTPServer() *http.Server {
 	r := mux.NewRouter()
 	r.HandleFunc("/app/{appname}", makeTimingHandler(handleApp))
 	r.HandleFunc("/app/{appname}/edits", makeTimingHandler(handleAppEdits))
+	r.HandleFunc("/app/{appname}/search", makeTimingHandler(handleSearch))
+	r.HandleFunc("/app/{appname}/comments", makeTimingHandler(handleComments))
 	r.HandleFunc("/app/{appname}/{lang}", makeTimingHandler(handleAppTranslations))
+	r.HandleFunc("/app/{appname}/{lang}/translate", makeTimingHandler(handleTranslate))
+	r.HandleFunc("/app/{appname}/{lang}/events", handleLiveEvents)
+	r.HandleFunc("/app/{appname}/{lang}/presence", makeTimingHandler(handleLivePresence))
 	r.HandleFunc("/user/{user}", makeTimingHandler(handleUser))
 	r.HandleFunc("/edittranslation", makeTimingHandler(handleEditTranslation))
 	r.HandleFunc("/duptranslation", makeTimingHandler(handleDuplicateTranslation))
+	r.HandleFunc("/savetranslation", makeTimingHandler(handleSaveTranslation))
+	r.HandleFunc("/addcomment", makeTimingHandler(handleAddComment))
+	r.HandleFunc("/reviewtranslation", makeTimingHandler(handleReviewTranslation))
+	r.HandleFunc("/reverttranslation", makeTimingHandler(handleRevertTranslation))
 	r.HandleFunc("/dltrans", makeTimingHandler(handleDownloadTranslations))
+	r.HandleFunc("/dltransdelta", makeTimingHandler(handleDownloadTranslationsDelta))
 	r.HandleFunc("/uploadstrings", makeTimingHandler(handleUploadStrings))
+	r.HandleFunc("/uploadscreenshot", makeTimingHandler(handleUploadScreenshot))
+	r.HandleFunc("/importtrans", makeTimingHandler(handleImportTranslations))
+	r.HandleFunc("/screenshot/{appname}/{file}", makeTimingHandler(handleScreenshot))
 	r.HandleFunc("/rss", makeTimingHandler(handleRss))
+	r.HandleFunc("/badge/{appname}.svg", makeTimingHandler(handleBadgeApp))
+	r.HandleFunc("/badge/{appname}/{lang}.svg", makeTimingHandler(handleBadgeLang))
+	r.HandleFunc("/widget/{appname}.svg", makeTimingHandler(handleWidget))
+	r.HandleFunc("/subscriptions", makeTimingHandler(handleSubscriptions))
+	r.HandleFunc("/subscribe", makeTimingHandler(handleSubscribe))
+	r.HandleFunc("/subscribe/confirm", makeTimingHandler(handleSubscribeConfirm))
+	r.HandleFunc("/unsubscribe", makeTimingHandler(handleUnsubscribe))
+	registerAPIHandlers(r)
 
 	r.HandleFunc("/login", handleLogin)
 	r.HandleFunc("/oauthtwittercb", handleOauthTwitterCallback)
+	r.HandleFunc("/oauthcb/{provider}", handleOauthCallback)
 	r.HandleFunc("/logout", handleLogout)
+	r.HandleFunc("/local/login", handleLocalLogin)
+	r.HandleFunc("/local/signup", handleLocalSignup)
+	r.HandleFunc("/local/verify", handleLocalVerify)
+	r.HandleFunc("/local/forgot", handleLocalForgot)
+	r.HandleFunc("/local/reset", handleLocalReset)
 	r.HandleFunc("/logs", makeTimingHandler(handleLogs))
+	r.HandleFunc("/admin", makeTimingHandler(handleAdmin))
+	r.HandleFunc("/admin/role", makeTimingHandler(handleAdminRole))
+	r.HandleFunc("/admin/sessions", makeTimingHandler(handleAdminSessions))
+	r.HandleFunc("/admin/tokens", makeTimingHandler(handleAdminTokens))
+	r.HandleFunc("/admin/tokens/create", makeTimingHandler(handleAdminTokensCreate))
+	r.HandleFunc("/admin/tokens/revoke", makeTimingHandler(handleAdminTokensRevoke))
+	r.HandleFunc("/admin/webhooks", makeTimingHandler(handleAdminWebhooks))
+	r.HandleFunc("/admin/webhooks/create", makeTimingHandler(handleAdminWebhooksCreate))
+	r.HandleFunc("/admin/webhooks/delete", makeTimingHandler(handleAdminWebhooksDelete))
+	r.HandleFunc("/admin/webhooks/test", makeTimingHandler(handleAdminWebhooksTest))
 	r.HandleFunc("/", makeTimingHandler(handleMain))
 
 	smux := &http.ServeMux{}
@@ -164,10 +221,10 @@ func makeHTTPServer() *http.Server {
 
 	srv := &http.Server{
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		WriteTimeout: httpWriteTimeout,
 		// TODO: 1.8 only
 		// IdleTimeout:  120 * time.Second,
-		Handler: smux,
+		Handler: makeCompressHandler(smux),
 	}
 	// TODO: track connections and their state
 	return srv
@@ -0,0 +1,82 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// static files don't change between deploys often, so let browsers and
+// proxies cache them for a day. They still revalidate with Last-Modified
+const staticMaxAge = 24 * 60 * 60
+
+// etagMatches returns true if If-None-Match header matches etag.
+// Uses weak comparison because compressed responses have weak ETags
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets ETag and Last-Modified (if modTime is not zero)
+// of the response. If the client already has this version (If-None-Match
+// or, if not given, If-Modified-Since), it writes 304 Not Modified and
+// returns true. etag must be quoted
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		// Last-Modified has a resolution of a second
+		notModified = err == nil && !modTime.Truncate(time.Second).After(t)
+	}
+	if !notModified {
+		return false
+	}
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// revisionETag returns ETag and modification time for responses that only
+// depend on translations of app. kind distinguishes different kinds of
+// responses and extra is for other state the response depends on
+func revisionETag(app *App, kind string, extra ...interface{}) (string, time.Time) {
+	rev, modTime := app.store.RevisionTime()
+	tag := fmt.Sprintf("%s-%d", kind, rev)
+	for _, e := range extra {
+		tag += fmt.Sprintf("-%v", e)
+	}
+	return `"` + tag + `"`, modTime
+}
+
+// checkAppNotModified is checkNotModified with revisionETag
+func checkAppNotModified(w http.ResponseWriter, r *http.Request, app *App, kind string, extra ...interface{}) bool {
+	etag, modTime := revisionETag(app, kind, extra...)
+	return checkNotModified(w, r, etag, modTime)
+}
+
+// checkAppETagNotModified is checkAppNotModified for responses that also
+// depend on arguments of the request (e.g. the revision a delta starts
+// from), which Last-Modified can't describe. Only If-None-Match is honored
+func checkAppETagNotModified(w http.ResponseWriter, r *http.Request, app *App, kind string, extra ...interface{}) bool {
+	etag, _ := revisionETag(app, kind, extra...)
+	return checkNotModified(w, r, etag, time.Time{})
+}
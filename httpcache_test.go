@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	modTime := time.Date(2019, 5, 1, 10, 20, 30, 500, time.UTC)
+	check := func(header, value string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		if !checkNotModified(rr, r, `"rss-3"`, modTime) {
+			rr.WriteHeader(http.StatusOK)
+		}
+		if rr.Header().Get("ETag") != `"rss-3"` || rr.Header().Get("Last-Modified") != "Wed, 01 May 2019 10:20:30 GMT" {
+			t.Fatalf("unexpected headers %v", rr.Header())
+		}
+		return rr.Code
+	}
+	tests := []struct {
+		header, value string
+		code          int
+	}{
+		{"", "", 200},
+		{"If-None-Match", `"rss-3"`, 304},
+		{"If-None-Match", `"rss-2", W/"rss-3"`, 304},
+		{"If-None-Match", `*`, 304},
+		{"If-None-Match", `"rss-2"`, 200},
+		{"If-Modified-Since", "Wed, 01 May 2019 10:20:30 GMT", 304},
+		{"If-Modified-Since", "Wed, 01 May 2019 10:20:29 GMT", 200},
+		{"If-Modified-Since", "invalid", 200},
+	}
+	for _, test := range tests {
+		if code := check(test.header, test.value); code != test.code {
+			t.Errorf("%s: %s returned %d, exp: %d", test.header, test.value, code, test.code)
+		}
+	}
+}
+
+func TestDownloadTranslationsNotModified(t *testing.T) {
+	logger = NewServerLogger(16, 16, false)
+	app, cleanup := setupTestApp(t, "TestApp", "dltranscachetest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteNewTranslation("foo", "fuu", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		handleDownloadTranslations(rr, r)
+		return rr
+	}
+	rr := get("/dltrans?app=TestApp", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != 200 || etag == "" || !strings.Contains(rr.Body.String(), "pl:fuu") {
+		t.Fatalf("unexpected response %d %v %s", rr.Code, rr.Header(), rr.Body.String())
+	}
+	if rr = get("/dltrans?app=TestApp", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", rr.Code)
+	}
+	// sha1 protocol used by old clients still works
+	sha1 := strings.Split(get("/dltrans?app=TestApp", "").Body.String(), "\n")[1]
+	if rr = get("/dltrans?app=TestApp&sha1="+sha1, ""); !strings.Contains(rr.Body.String(), "No change") {
+		t.Fatalf("unexpected response %s", rr.Body.String())
+	}
+	if rr = get("/dltrans?app=TestApp&sha1=abc", ""); !strings.Contains(rr.Body.String(), "Error:") {
+		t.Fatalf("unexpected response %s", rr.Body.String())
+	}
+
+	if err := app.store.WriteNewTranslation("foo", "fuu2", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if rr = get("/dltrans?app=TestApp", etag); rr.Code != 200 || rr.Header().Get("ETag") == etag {
+		t.Fatalf("new translation should change ETag, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestETagsDependOnArguments(t *testing.T) {
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "etagargstest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.WriteNewTranslation("foo", "fuu", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	_, modTime := app.store.RevisionTime()
+	get := func(url, etag string, ims bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if ims {
+			r.Header.Set("If-Modified-Since", modTime.Add(time.Hour).UTC().Format(http.TimeFormat))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+	// revalidating with a response for other arguments must not return 304
+	pairs := [][2]string{
+		{"/dltransdelta?app=TestApp&rev=3", "/dltransdelta?app=TestApp&rev=0"},
+		{"/api/v1/apps/TestApp/langs/de", "/api/v1/apps/TestApp/langs/pl"},
+		{"/api/v1/apps/TestApp/langs/pl/strings", "/api/v1/apps/TestApp/langs/pl/strings?filter=untranslated"},
+		{"/api/v1/apps/TestApp/langs/pl/strings", "/api/v1/apps/TestApp/langs/de/strings"},
+		{"/api/v1/apps/TestApp/langs?per_page=1", "/api/v1/apps/TestApp/langs?per_page=1&page=2"},
+		{"/api/v1/apps/TestApp/langs/pl/progress", "/api/v1/apps/TestApp/langs/de/progress"},
+		{"/api/v1/apps/TestApp/progress", "/api/v1/apps/TestApp/progress?days=7"},
+	}
+	for _, p := range pairs {
+		rr := get(p[0], "", false)
+		etag := rr.Header().Get("ETag")
+		if rr.Code != 200 || etag == "" {
+			t.Fatalf("%s returned %d, ETag %q", p[0], rr.Code, etag)
+		}
+		if rr = get(p[0], etag, false); rr.Code != http.StatusNotModified {
+			t.Fatalf("%s with its ETag returned %d", p[0], rr.Code)
+		}
+		if rr = get(p[1], etag, false); rr.Code != 200 {
+			t.Fatalf("%s with ETag of %s returned %d", p[1], p[0], rr.Code)
+		}
+	}
+	// If-Modified-Since alone can't tell which revision a delta starts from
+	if rr := get("/dltransdelta?app=TestApp&rev=0", "", true); rr.Code != 200 || rr.Header().Get("Last-Modified") != "" {
+		t.Fatalf("delta with If-Modified-Since returned %d", rr.Code)
+	}
+}
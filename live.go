@@ -0,0 +1,383 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kjk/apptranslator/store"
+)
+
+// Live updates of translation pages via Server-Sent Events. Browsers
+// showing /app/{appname}/{lang} connect to /app/{appname}/{lang}/events and
+// get:
+// - edit: a translation was added or reverted
+// - strings: list of strings of the app changed
+// - presence: a user started or stopped editing a string
+// Each app/lang has a channel with a buffer of recent events, so that
+// a browser that reconnects with Last-Event-ID doesn't miss anything.
+
+const (
+	liveEventEdit     = "edit"
+	liveEventStrings  = "strings"
+	liveEventPresence = "presence"
+	// tells the browser it missed events and should reload the page
+	liveEventReload = "reload"
+
+	// how many recent events per app/lang we keep for reconnecting browsers
+	maxLiveEvents = 256
+	// browsers re-send presence while editing, so we forget about users
+	// we didn't hear from in a while
+	livePresenceTimeout = 60 * time.Second
+)
+
+// a stream ends after that and the browser reconnects
+var liveStreamDuration = 10 * time.Minute
+
+// writeDeadliner is implemented by http.ResponseWriter since Go 1.20. We
+// use it to extend server's WriteTimeout, which otherwise applies to the
+// whole response, before each write to a stream
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// extendWriteDeadline returns false if the deadline can't be extended
+func extendWriteDeadline(w http.ResponseWriter) bool {
+	dl, ok := w.(writeDeadliner)
+	return ok && dl.SetWriteDeadline(time.Now().Add(httpWriteTimeout)) == nil
+}
+
+// LiveEvent is sent to browsers
+type LiveEvent struct {
+	ID   int64
+	Type string
+	Data interface{}
+}
+
+// LiveEdit is data of edit event
+type LiveEdit struct {
+	String      string    `json:"string"`
+	Translation string    `json:"translation"`
+	User        string    `json:"user"`
+	Time        time.Time `json:"time"`
+	Reverted    bool      `json:"reverted"`
+}
+
+// LivePresence is data of presence event
+type LivePresence struct {
+	User    string `json:"user"`
+	String  string `json:"string"`
+	Editing bool   `json:"editing"`
+}
+
+type livePresence struct {
+	str      string
+	lastSeen time.Time
+}
+
+type liveChannel struct {
+	lastID int64
+	// recent events, oldest first
+	events      []*LiveEvent
+	subscribers map[chan struct{}]bool
+	// maps user to the string they're editing
+	presence map[string]*livePresence
+}
+
+type liveHub struct {
+	sync.Mutex
+	// maps app/lang to a channel
+	channels map[string]*liveChannel
+}
+
+var live = &liveHub{channels: make(map[string]*liveChannel)}
+
+func liveChannelKey(app *App, lang string) string {
+	return app.Name + "/" + lang
+}
+
+// must be called with hub locked
+func (h *liveHub) channel(key string) *liveChannel {
+	c := h.channels[key]
+	if c == nil {
+		c = &liveChannel{
+			subscribers: make(map[chan struct{}]bool),
+			presence:    make(map[string]*livePresence),
+		}
+		h.channels[key] = c
+	}
+	return c
+}
+
+// must be called with hub locked
+func (c *liveChannel) publish(typ string, data interface{}) {
+	c.lastID++
+	c.events = append(c.events, &LiveEvent{ID: c.lastID, Type: typ, Data: data})
+	if len(c.events) > maxLiveEvents {
+		c.events = c.events[len(c.events)-maxLiveEvents:]
+	}
+	for ch := range c.subscribers {
+		// ch has a buffer of 1 so a pending notification is enough
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *liveHub) publish(key, typ string, data interface{}) {
+	h.Lock()
+	defer h.Unlock()
+	h.channel(key).publish(typ, data)
+}
+
+// publishToApp sends an event to all languages of app
+func (h *liveHub) publishToApp(app *App, typ string, data interface{}) {
+	h.Lock()
+	defer h.Unlock()
+	prefix := app.Name + "/"
+	for key, c := range h.channels {
+		if strings.HasPrefix(key, prefix) {
+			c.publish(typ, data)
+		}
+	}
+}
+
+func (h *liveHub) subscribe(key string) chan struct{} {
+	h.Lock()
+	defer h.Unlock()
+	ch := make(chan struct{}, 1)
+	h.channel(key).subscribers[ch] = true
+	return ch
+}
+
+func (h *liveHub) unsubscribe(key string, ch chan struct{}) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.channel(key).subscribers, ch)
+}
+
+// eventsAfter returns events with id > lastID. Returns nil and false if
+// some of them are no longer buffered
+func (h *liveHub) eventsAfter(key string, lastID int64) ([]*LiveEvent, bool) {
+	h.Lock()
+	defer h.Unlock()
+	c := h.channel(key)
+	if lastID > c.lastID {
+		// the server was restarted since the browser connected
+		return nil, false
+	}
+	if lastID == c.lastID {
+		return nil, true
+	}
+	if len(c.events) == 0 || c.events[0].ID > lastID+1 {
+		return nil, false
+	}
+	return append([]*LiveEvent(nil), c.events[lastID+1-c.events[0].ID:]...), true
+}
+
+func (h *liveHub) lastEventID(key string) int64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.channel(key).lastID
+}
+
+// setPresence records that user started or stopped editing str and tells
+// other browsers if it changed
+func (h *liveHub) setPresence(key, user, str string, editing bool) {
+	h.Lock()
+	defer h.Unlock()
+	c := h.channel(key)
+	now := time.Now()
+	cur := c.presence[user]
+	if editing {
+		c.presence[user] = &livePresence{str: str, lastSeen: now}
+		if cur != nil && cur.str == str && now.Sub(cur.lastSeen) < livePresenceTimeout {
+			// it's a refresh
+			return
+		}
+	} else {
+		if cur == nil || cur.str != str {
+			return
+		}
+		delete(c.presence, user)
+	}
+	c.publish(liveEventPresence, &LivePresence{User: user, String: str, Editing: editing})
+}
+
+// currentPresence returns who is editing what right now
+func (h *liveHub) currentPresence(key string) []*LivePresence {
+	h.Lock()
+	defer h.Unlock()
+	c := h.channel(key)
+	res := make([]*LivePresence, 0)
+	now := time.Now()
+	for user, p := range c.presence {
+		if now.Sub(p.lastSeen) > livePresenceTimeout {
+			delete(c.presence, user)
+			continue
+		}
+		res = append(res, &LivePresence{User: user, String: p.str, Editing: true})
+	}
+	return res
+}
+
+// publishLiveEdit tells browsers that translation of str in lang changed
+func publishLiveEdit(app *App, lang, str, trans, user string, reverted bool) {
+	key := liveChannelKey(app, lang)
+	live.publish(key, liveEventEdit, &LiveEdit{
+		String:      str,
+		Translation: trans,
+		User:        user,
+		Time:        time.Now(),
+		Reverted:    reverted,
+	})
+	// saving a translation ends editing of it
+	live.setPresence(key, user, str, false)
+}
+
+func writeLiveEvent(w http.ResponseWriter, id int64, typ string, data interface{}) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, typ, d)
+	} else {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, d)
+	}
+	return err
+}
+
+// url: /app/{appname}/{lang}/events
+// Streams Server-Sent Events. The stream ends after liveStreamDuration (or
+// before server's WriteTimeout, if it can't be extended), the browser
+// reconnects with Last-Event-ID header
+func handleLiveEvents(w http.ResponseWriter, r *http.Request) {
+	app, lang := getLiveAppLang(w, r)
+	if app == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	key := liveChannelKey(app, lang)
+	ch := live.subscribe(key)
+	defer live.unsubscribe(key, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// tell nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	duration := liveStreamDuration
+	canExtend := extendWriteDeadline(w)
+	if !canExtend && duration > httpWriteTimeout-time.Second {
+		duration = httpWriteTimeout - time.Second
+	}
+	fmt.Fprintf(w, "retry: 1000\n\n")
+
+	lastID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		// a new page, which already shows everything up to now
+		lastID = live.lastEventID(key)
+		for _, p := range live.currentPresence(key) {
+			writeLiveEvent(w, 0, liveEventPresence, p)
+		}
+	}
+	flusher.Flush()
+
+	timeout := time.After(duration)
+	for {
+		if canExtend {
+			extendWriteDeadline(w)
+		}
+		events, ok := live.eventsAfter(key, lastID)
+		if !ok {
+			lastID = live.lastEventID(key)
+			writeLiveEvent(w, lastID, liveEventReload, map[string]string{})
+		}
+		for _, e := range events {
+			if err := writeLiveEvent(w, e.ID, e.Type, e.Data); err != nil {
+				return
+			}
+			lastID = e.ID
+		}
+		flusher.Flush()
+		select {
+		case <-ch:
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func getLiveAppLang(w http.ResponseWriter, r *http.Request) (*App, string) {
+	vars := mux.Vars(r)
+	app := findApp(vars["appname"])
+	if app == nil {
+		http.Error(w, fmt.Sprintf("Application %q doesn't exist", vars["appname"]), http.StatusNotFound)
+		return nil, ""
+	}
+	lang := vars["lang"]
+	if !store.IsValidLangCode(lang) {
+		http.Error(w, fmt.Sprintf("Invalid language %q", lang), http.StatusNotFound)
+		return nil, ""
+	}
+	return app, lang
+}
+
+// PresenceRequest is sent as JSON body to /app/{appname}/{lang}/presence
+type PresenceRequest struct {
+	String  string `json:"string"`
+	Editing bool   `json:"editing"`
+}
+
+// url: POST /app/{appname}/{lang}/presence
+// Body is json-encoded PresenceRequest, CSRF token is sent in X-CSRF-Token
+// header. Browsers send it when a user starts editing a string (and
+// periodically while editing) and when they stop
+func handleLivePresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		serveJSONError(w, http.StatusMethodNotAllowed, "must be POST")
+		return
+	}
+	if !isValidCSRFToken(r) {
+		serveJSONError(w, http.StatusForbidden, "invalid or missing CSRF token, please reload the page")
+		return
+	}
+	app, lang := getLiveAppLang(w, r)
+	if app == nil {
+		return
+	}
+	user := decodeUserFromCookie(r)
+	if !userCanTranslate(user) {
+		serveJSONError(w, http.StatusForbidden, "you're not allowed to edit translations")
+		return
+	}
+	if isRateLimited(r, user) {
+		w.Header().Set("Retry-After", "60")
+		serveJSONError(w, http.StatusTooManyRequests, "too many requests, please slow down")
+		return
+	}
+	var req PresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		serveJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %s", err))
+		return
+	}
+	if !app.store.IsActiveString(req.String) {
+		serveJSONError(w, http.StatusNotFound, fmt.Sprintf("string %q doesn't exist", req.String))
+		return
+	}
+	live.setPresence(liveChannelKey(app, lang), user, req.String, req.Editing)
+	serveJSON(w, map[string]interface{}{"ok": true})
+}
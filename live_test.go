@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveHub(t *testing.T) {
+	h := &liveHub{channels: make(map[string]*liveChannel)}
+	for i := 0; i < 3; i++ {
+		h.publish("app/pl", liveEventEdit, i)
+	}
+	events, ok := h.eventsAfter("app/pl", 1)
+	if !ok || len(events) != 2 || events[0].ID != 2 || events[1].Data != 2 {
+		t.Fatalf("unexpected events %#v", events)
+	}
+	if events, ok = h.eventsAfter("app/pl", 3); !ok || len(events) != 0 {
+		t.Fatalf("shouldn't have new events %#v", events)
+	}
+	if _, ok = h.eventsAfter("app/pl", 10); ok {
+		t.Fatalf("id from before restart should require a reload")
+	}
+	for i := 0; i < maxLiveEvents; i++ {
+		h.publish("app/pl", liveEventEdit, i)
+	}
+	if _, ok = h.eventsAfter("app/pl", 1); ok {
+		t.Fatalf("events no longer buffered should require a reload")
+	}
+
+	h.setPresence("app/pl", "alice", "foo", true)
+	h.setPresence("app/pl", "alice", "foo", true)
+	h.setPresence("app/pl", "bob", "bar", false)
+	if p := h.currentPresence("app/pl"); len(p) != 1 || p[0].User != "alice" || p[0].String != "foo" {
+		t.Fatalf("unexpected presence %#v", p)
+	}
+	if n := h.lastEventID("app/pl"); n != 3+maxLiveEvents+1 {
+		t.Fatalf("refreshing presence shouldn't send events, last id is %d", n)
+	}
+	h.setPresence("app/pl", "alice", "foo", false)
+	if p := h.currentPresence("app/pl"); len(p) != 0 {
+		t.Fatalf("unexpected presence %#v", p)
+	}
+}
+
+// reads events stream until it ends. Called from a goroutine, so it can't
+// use t.Fatal
+func readLiveEvents(t *testing.T, url, lastID string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("GET %s failed with %s", url, err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("reading events failed with %s", err)
+	}
+	return string(d)
+}
+
+func TestLiveEvents(t *testing.T) {
+	defer setupTestUserStore(t, "livetest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "liveapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	duration := liveStreamDuration
+	liveStreamDuration = 300 * time.Millisecond
+	defer func() { liveStreamDuration = duration }()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	url := srv.URL + "/app/TestApp/pl/events"
+	lastID := live.lastEventID("TestApp/pl")
+
+	alice := loginTestUser(t, "alice", false)
+	body := `{"string": "foo", "editing": true}`
+	if rr := apiRequest(h, "POST", "/app/TestApp/pl/presence", body, alice); rr.Code != 200 {
+		t.Fatalf("presence returned %d, %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(h, "POST", "/app/TestApp/pl/presence", body, nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("presence without a login returned %d", rr.Code)
+	}
+
+	done := make(chan string)
+	go func() {
+		done <- readLiveEvents(t, url, "")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	body = `{"string": "foo", "translation": "fuu"}`
+	if rr := apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/translations", body, alice); rr.Code != 200 {
+		t.Fatalf("submitting translation returned %d, %s", rr.Code, rr.Body.String())
+	}
+	s := <-done
+	// a new page gets current presence, then new events
+	if !strings.Contains(s, `"user":"alice","string":"foo","editing":true`) {
+		t.Fatalf("didn't get presence in %q", s)
+	}
+	if !strings.Contains(s, "event: edit\n") || !strings.Contains(s, `"translation":"fuu"`) {
+		t.Fatalf("didn't get edit event in %q", s)
+	}
+	// saving ends editing
+	if !strings.Contains(s, `"user":"alice","string":"foo","editing":false`) {
+		t.Fatalf("didn't get end of editing in %q", s)
+	}
+
+	// reconnecting browser gets events it missed
+	s = readLiveEvents(t, url, strconv.FormatInt(lastID, 10))
+	if strings.Count(s, "event: presence\n") != 2 || !strings.Contains(s, "event: edit\n") {
+		t.Fatalf("didn't get missed events in %q", s)
+	}
+}
+
+func TestLiveEventsOutlastWriteTimeout(t *testing.T) {
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "liveapptest.dat")
+	defer cleanup()
+	duration := liveStreamDuration
+	liveStreamDuration = 800 * time.Millisecond
+	defer func() { liveStreamDuration = duration }()
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	done := make(chan string)
+	go func() {
+		done <- readLiveEvents(t, srv.URL+"/app/TestApp/pl/events", "")
+	}()
+	time.Sleep(400 * time.Millisecond)
+	publishLiveEdit(app, "pl", "foo", "fuu", "alice", false)
+	if s := <-done; !strings.Contains(s, `"translation":"fuu"`) {
+		t.Fatalf("didn't get edit event sent after WriteTimeout in %q", s)
+	}
+}
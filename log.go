@@ -126,6 +126,7 @@ type ModelLogs struct {
 	User        string
 	UserIsAdmin bool
 	RedirectUrl string
+	CsrfToken   string
 	Errors      []*TimestampedMsg
 	Notices     []*TimestampedMsg
 }
@@ -135,8 +136,9 @@ func handleLogs(w http.ResponseWriter, r *http.Request) {
 	user := decodeUserFromCookie(r)
 	model := &ModelLogs{
 		User:        user,
-		UserIsAdmin: user == "kjk", // only I can see the logs
+		UserIsAdmin: userIsGlobalAdmin(user),
 		RedirectUrl: r.URL.String(),
+		CsrfToken:   csrfToken(r),
 		PageTitle:   "AppTranslator logs",
 	}
 	if model.UserIsAdmin {
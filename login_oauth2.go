@@ -0,0 +1,299 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+var (
+	// used for talking to OAuth2 and OpenID Connect providers
+	loginHTTPClient = &http.Client{Timeout: 15 * time.Second}
+	// user names from providers are shown on pages and used in urls. They
+	// have the characters of local account names (see accountNameRx) and
+	// can also be (verified) emails and longer subject identifiers
+	providerUserNameRx = regexp.MustCompile(`^[a-zA-Z0-9_.@+-]{1,64}$`)
+)
+
+// oauth2Provider implements OAuth2 authorization code flow. Provider-specific
+// parts i.e. finding endpoints and getting user name are delegated to
+// functions, which allows implementing GitHub and OpenID Connect on top of it
+type oauth2Provider struct {
+	name         string
+	displayName  string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	// returns authorization and token endpoints
+	endpoints func() (authURL string, tokenURL string, err error)
+	// returns user name given access token
+	userName func(accessToken string) (string, error)
+}
+
+func (p *oauth2Provider) Name() string {
+	return p.name
+}
+
+func (p *oauth2Provider) DisplayName() string {
+	return p.displayName
+}
+
+// the callback url doesn't have query parameters because some providers
+// (e.g. Google) require it to exactly match registered url. Redirect url is
+// remembered in the cookie
+func (p *oauth2Provider) callbackURL(r *http.Request) string {
+	return loginCallbackURL(r, "/oauthcb/"+p.name)
+}
+
+func (p *oauth2Provider) StartLogin(w http.ResponseWriter, r *http.Request, redirect string) (string, error) {
+	authURL, _, err := p.endpoints()
+	if err != nil {
+		return "", err
+	}
+	// state protects against cross-site request forgery
+	state := fmt.Sprintf("%x", securecookie.GenerateRandomKey(16))
+	cookie := getSecureCookie(r)
+	if cookie == nil {
+		cookie = &SecureCookieValue{}
+	}
+	cookie.LoginState = state
+	cookie.LoginRedirect = redirect
+	setSecureCookie(w, r, cookie)
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.callbackURL(r)},
+		"state":         {state},
+	}
+	if len(p.scopes) > 0 {
+		q.Set("scope", strings.Join(p.scopes, " "))
+	}
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+	return authURL + sep + q.Encode(), nil
+}
+
+func (p *oauth2Provider) FinishLogin(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	cookie := getSecureCookie(r)
+	if cookie == nil || cookie.LoginState == "" {
+		return "", "", errors.New("missing login state in cookie")
+	}
+	redirect := cookie.LoginRedirect
+	if errCode := r.FormValue("error"); errCode != "" {
+		return "", redirect, fmt.Errorf("authorization failed: %s %s", errCode, r.FormValue("error_description"))
+	}
+	if r.FormValue("state") != cookie.LoginState {
+		return "", redirect, errors.New("invalid state")
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		return "", redirect, errors.New("missing code")
+	}
+	accessToken, err := p.exchange(code, p.callbackURL(r))
+	if err != nil {
+		return "", redirect, err
+	}
+	user, err := p.userName(accessToken)
+	if err != nil {
+		return "", redirect, err
+	}
+	if !providerUserNameRx.MatchString(user) {
+		return "", redirect, fmt.Errorf("invalid user name %q", user)
+	}
+	return user, redirect, nil
+}
+
+// exchange exchanges authorization code for an access token
+func (p *oauth2Provider) exchange(code, redirectURI string) (string, error) {
+	_, tokenURL, err := p.endpoints()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+	}
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub returns form-encoded response unless asked for json
+	req.Header.Set("Accept", "application/json")
+	var rsp struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = doLoginJSONRequest(req, &rsp); err != nil {
+		return "", err
+	}
+	// GitHub reports errors with status 200
+	if rsp.Error != "" {
+		return "", fmt.Errorf("token exchange failed: %s %s", rsp.Error, rsp.ErrorDescription)
+	}
+	if rsp.AccessToken == "" {
+		return "", errors.New("token exchange failed: no access_token")
+	}
+	return rsp.AccessToken, nil
+}
+
+func doLoginJSONRequest(req *http.Request, data interface{}) error {
+	resp, err := loginHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s %s returned status %d, %s", req.Method, req.URL, resp.StatusCode, d)
+	}
+	return json.Unmarshal(d, data)
+}
+
+func getLoginJSON(urlStr, accessToken string, data interface{}) error {
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doLoginJSONRequest(req, data)
+}
+
+func newGitHubProvider(c *OAuth2Config) *oauth2Provider {
+	authURL := strOrDefault(c.AuthURL, "https://github.com/login/oauth/authorize")
+	tokenURL := strOrDefault(c.TokenURL, "https://github.com/login/oauth/access_token")
+	apiURL := strings.TrimSuffix(strOrDefault(c.APIURL, "https://api.github.com"), "/")
+	return &oauth2Provider{
+		name:         "github",
+		displayName:  "GitHub",
+		clientID:     c.ClientID,
+		clientSecret: c.ClientSecret,
+		// we only need public information
+		scopes: nil,
+		endpoints: func() (string, string, error) {
+			return authURL, tokenURL, nil
+		},
+		userName: func(accessToken string) (string, error) {
+			var user struct {
+				Login string `json:"login"`
+			}
+			if err := getLoginJSON(apiURL+"/user", accessToken, &user); err != nil {
+				return "", err
+			}
+			return user.Login, nil
+		},
+	}
+}
+
+// oidcDiscovery is a subset of OpenID Provider Metadata
+// http://openid.net/specs/openid-connect-discovery-1_0.html
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type oidcProvider struct {
+	config *OIDCConfig
+	sync.Mutex
+	discovery *oidcDiscovery
+}
+
+// discover downloads provider's configuration. It's done on first use (and
+// retried on failure) so that a provider being down doesn't prevent us from
+// starting
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	var d oidcDiscovery
+	if err := getLoginJSON(issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer mismatch, expected %q, got %q", p.config.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("incomplete configuration of %q", p.config.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *oidcProvider) userName(accessToken string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	var claims map[string]interface{}
+	if err = getLoginJSON(d.UserinfoEndpoint, accessToken, &claims); err != nil {
+		return "", err
+	}
+	// sub is unique and never changes. Other claims, like
+	// preferred_username, can be changed by users and don't have to be
+	// unique, so using them would allow impersonating other users
+	name := p.config.UserClaim
+	if name == "" {
+		name = "sub"
+	}
+	if name == "email" {
+		if v, ok := claims["email_verified"].(bool); !ok || !v {
+			return "", errors.New("email is not verified")
+		}
+	}
+	if s, ok := claims[name].(string); ok && s != "" {
+		return s, nil
+	}
+	return "", fmt.Errorf("userinfo has no %s claim", name)
+}
+
+func newOIDCProvider(c *OIDCConfig) *oauth2Provider {
+	p := &oidcProvider{config: c}
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &oauth2Provider{
+		name:         c.Name,
+		displayName:  c.DisplayName,
+		clientID:     c.ClientID,
+		clientSecret: c.ClientSecret,
+		scopes:       scopes,
+		endpoints: func() (string, string, error) {
+			d, err := p.discover()
+			if err != nil {
+				return "", "", err
+			}
+			return d.AuthorizationEndpoint, d.TokenEndpoint, nil
+		},
+		userName: p.userName,
+	}
+}
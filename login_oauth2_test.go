@@ -0,0 +1,276 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/kjk/apptranslator/store"
+)
+
+// mockIdP is a minimal OAuth2 / OpenID Connect provider
+type mockIdP struct {
+	srv  *httptest.Server
+	user map[string]interface{}
+}
+
+const (
+	mockCode  = "mock-code"
+	mockToken = "mock-token"
+)
+
+func newMockIdP(t *testing.T) *mockIdP {
+	m := &mockIdP{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"userinfo_endpoint":      m.srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("client_secret") != "secret" {
+			http.Error(w, "invalid client", 401)
+			return
+		}
+		if r.FormValue("code") != mockCode {
+			// like GitHub, report errors with status 200
+			serveJSON(w, map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		serveJSON(w, map[string]string{"access_token": mockToken, "token_type": "bearer"})
+	})
+	userInfo := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+mockToken {
+			http.Error(w, "invalid token", 401)
+			return
+		}
+		serveJSON(w, m.user)
+	}
+	mux.HandleFunc("/userinfo", userInfo)
+	mux.HandleFunc("/user", userInfo)
+	m.srv = httptest.NewServer(mux)
+	return m
+}
+
+// setupTestUserStore opens a fresh user store, which is needed for sessions.
+// Call the returned function to close and delete it
+func setupTestUserStore(t *testing.T, path string) func() {
+	os.Remove(path)
+	var err error
+	if userStore, err = store.NewUserStore(path); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		userStore.Close()
+		userStore = nil
+		os.Remove(path)
+	}
+}
+
+func setupLoginTest(t *testing.T, lc *LoginConfig, aliases map[string]string) http.Handler {
+	logger = NewServerLogger(16, 16, false)
+	cookieAuthKey = securecookie.GenerateRandomKey(32)
+	cookieEncrKey = securecookie.GenerateRandomKey(32)
+	secureCookie = securecookie.New(cookieAuthKey, cookieEncrKey)
+	config.TwitterOAuthCredentials = nil
+	config.LocalAccounts = false
+	config.Login = lc
+	config.UserAliases = aliases
+	if err := initLoginProviders(); err != nil {
+		t.Fatalf("initLoginProviders() failed with %s", err)
+	}
+	return makeHTTPServer().Handler
+}
+
+// logs in with a given provider and returns logged in user
+func mockLogin(t *testing.T, h http.Handler, provider, code string) (string, int) {
+	req := httptest.NewRequest("GET", "/login?provider="+provider+"&redirect=/app/foo", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != 302 {
+		t.Fatalf("/login returned %d, %s", rr.Code, rr.Body.String())
+	}
+	authURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(authURL.Path, "/authorize") {
+		t.Fatalf("unexpected authorization url %s", authURL)
+	}
+	q := authURL.Query()
+	if q.Get("client_id") != "client" || q.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization url %s", authURL)
+	}
+	cookies := rr.Result().Cookies()
+
+	cb := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	req = httptest.NewRequest("GET", cb, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != 302 {
+		return "", rr.Code
+	}
+	if loc := rr.Header().Get("Location"); loc != "/app/foo" {
+		t.Fatalf("redirected to %q, expected /app/foo", loc)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return decodeUserFromCookie(req), rr.Code
+}
+
+func TestLoginOIDC(t *testing.T) {
+	defer setupTestUserStore(t, "logintest.dat")()
+	m := newMockIdP(t)
+	defer m.srv.Close()
+	m.user = map[string]interface{}{"sub": "1234", "preferred_username": "alice"}
+	lc := &LoginConfig{
+		OIDC: []*OIDCConfig{
+			{Name: "corp", Issuer: m.srv.URL, ClientID: "client", ClientSecret: "secret"},
+		},
+	}
+	h := setupLoginTest(t, lc, nil)
+	// preferred_username can be changed by users, so sub is used
+	user, _ := mockLogin(t, h, "corp", mockCode)
+	if user != "corp:1234" {
+		t.Fatalf("user is %q, expected corp:1234", user)
+	}
+
+	_, code := mockLogin(t, h, "corp", "bad-code")
+	if code != 500 {
+		t.Fatalf("login with invalid code returned %d", code)
+	}
+
+	// unverified email can't be used as a user name
+	lc.OIDC[0].UserClaim = "email"
+	h = setupLoginTest(t, lc, nil)
+	m.user = map[string]interface{}{"sub": "1234", "email": "bob@example.com", "email_verified": false}
+	if _, code = mockLogin(t, h, "corp", mockCode); code != 500 {
+		t.Fatalf("login with unverified email returned %d", code)
+	}
+	m.user["email_verified"] = true
+	if user, _ = mockLogin(t, h, "corp", mockCode); user != "corp:bob@example.com" {
+		t.Fatalf("user is %q, expected corp:bob@example.com", user)
+	}
+
+	// names that could inject html are rejected
+	lc.OIDC[0].UserClaim = "preferred_username"
+	h = setupLoginTest(t, lc, nil)
+	m.user = map[string]interface{}{"sub": "1234", "preferred_username": `"</script><script>alert(1)//`}
+	if _, code = mockLogin(t, h, "corp", mockCode); code != 500 {
+		t.Fatalf("login with invalid user name returned %d", code)
+	}
+}
+
+func TestLoginGitHubAlias(t *testing.T) {
+	defer setupTestUserStore(t, "logintest.dat")()
+	m := newMockIdP(t)
+	defer m.srv.Close()
+	m.user = map[string]interface{}{"login": "kjk"}
+	lc := &LoginConfig{
+		GitHub: &OAuth2Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			AuthURL:      m.srv.URL + "/authorize",
+			TokenURL:     m.srv.URL + "/token",
+			APIURL:       m.srv.URL,
+		},
+	}
+	h := setupLoginTest(t, lc, nil)
+	user, _ := mockLogin(t, h, "github", mockCode)
+	if user != "github:kjk" {
+		t.Fatalf("user is %q, expected github:kjk", user)
+	}
+
+	// existing Twitter user logging in with GitHub
+	h = setupLoginTest(t, lc, map[string]string{"github:kjk": "twitter:kjk"})
+	user, _ = mockLogin(t, h, "github", mockCode)
+	if user != "kjk" {
+		t.Fatalf("user is %q, expected kjk", user)
+	}
+}
+
+func TestLoginInvalidState(t *testing.T) {
+	defer setupTestUserStore(t, "logintest.dat")()
+	m := newMockIdP(t)
+	defer m.srv.Close()
+	lc := &LoginConfig{
+		OIDC: []*OIDCConfig{
+			{Name: "corp", Issuer: m.srv.URL, ClientID: "client", ClientSecret: "secret"},
+		},
+	}
+	h := setupLoginTest(t, lc, nil)
+	// callback without a login started by us
+	req := httptest.NewRequest("GET", "/oauthcb/corp?code="+mockCode+"&state=foo", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != 500 {
+		t.Fatalf("callback returned %d, expected 500", rr.Code)
+	}
+}
+
+func TestUserIdentity(t *testing.T) {
+	config.UserAliases = nil
+	tests := []struct {
+		id, normalized string
+	}{
+		{"alice", "alice"},
+		{"twitter:alice", "alice"},
+		{" github:alice ", "github:alice"},
+		{"corp:alice@example.com", "corp:alice@example.com"},
+	}
+	for _, test := range tests {
+		if got := normalizeUserIdentity(test.id); got != test.normalized {
+			t.Errorf("normalizeUserIdentity(%q) = %q, expected %q", test.id, got, test.normalized)
+		}
+	}
+	app := &App{AppConfig: AppConfig{AdminTwitterUser: "kjk", Admins: []string{"github:alice", "twitter:bob"}}}
+	for _, user := range []string{"kjk", "github:alice", "bob"} {
+		if !userIsAdmin(app, user) {
+			t.Errorf("%q should be an admin", user)
+		}
+	}
+	for _, user := range []string{"", "alice", "github:kjk", "github:bob"} {
+		if userIsAdmin(app, user) {
+			t.Errorf("%q should not be an admin", user)
+		}
+	}
+}
+
+func TestUserNameEscaped(t *testing.T) {
+	defer setupTestUserStore(t, "logintest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "loginapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	// e.g. a Twitter user from before names were checked
+	name := `"</script><b>x`
+	if err := app.store.WriteNewTranslation("foo", "fuu", "pl", name); err != nil {
+		t.Fatal(err)
+	}
+	cookies := loginTestUser(t, name, false)
+	for _, path := range []string{"/app/TestApp/pl", "/app/TestApp"} {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if body := rr.Body.String(); rr.Code != 200 || strings.Contains(body, name) {
+			t.Fatalf("%s returned %d with unescaped user name:\n%s", path, rr.Code, body)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// loginProvider is an external service that users can log in with
+type loginProvider interface {
+	// Name is a short, unique name of the provider e.g. "github". It's used
+	// in urls and as a prefix of user identities e.g. "github:alice"
+	Name() string
+	// DisplayName is shown in the ui e.g. "GitHub"
+	DisplayName() string
+	// StartLogin returns url of provider's page where the user authorizes
+	// us. After that the provider redirects back to our callback url,
+	// where FinishLogin is called
+	StartLogin(w http.ResponseWriter, r *http.Request, redirect string) (string, error)
+	// FinishLogin returns user name, as known to the provider, and url to
+	// redirect to after logging in
+	FinishLogin(w http.ResponseWriter, r *http.Request) (user string, redirect string, err error)
+}
+
+const twitterProviderName = "twitter"
+
+var (
+	loginProviders []loginProvider
+	// names that can't be used for configured OpenID Connect providers
+	reservedProviderNames = []string{twitterProviderName, "github", "google", localProviderName}
+)
+
+// OAuth2Config is configuration of an OAuth2 provider, like GitHub
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	// optional, over-ride default urls of the provider e.g. for
+	// GitHub Enterprise or for testing with a mock server
+	AuthURL  string
+	TokenURL string
+	APIURL   string
+}
+
+// OIDCConfig is configuration of an OpenID Connect provider
+type OIDCConfig struct {
+	// used in urls and as a prefix of user identities
+	Name        string
+	DisplayName string
+	// provider's configuration is discovered from
+	// ${Issuer}/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// optional, defaults to "openid email profile"
+	Scopes []string
+	// optional, name of userinfo claim used as a user name. Defaults to
+	// sub, which is unique and doesn't change. Only a verified email can be
+	// used. The claim must be unique and immutable, otherwise users can
+	// impersonate each other
+	UserClaim string
+}
+
+// LoginConfig describes login providers other than Twitter
+type LoginConfig struct {
+	GitHub *OAuth2Config
+	// Issuer defaults to Google's
+	Google *OIDCConfig
+	OIDC   []*OIDCConfig
+}
+
+func isReservedProviderName(name string) bool {
+	for _, s := range reservedProviderNames {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findLoginProvider(name string) loginProvider {
+	for _, p := range loginProviders {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// initLoginProviders creates login providers configured in config.json
+func initLoginProviders() error {
+	loginProviders = nil
+	if config.TwitterOAuthCredentials != nil && config.TwitterOAuthCredentials.Token != "" {
+		loginProviders = append(loginProviders, &twitterProvider{})
+	}
+	if config.LocalAccounts {
+		loginProviders = append(loginProviders, &localProvider{})
+	}
+	lc := config.Login
+	if lc == nil {
+		return nil
+	}
+	if lc.GitHub != nil {
+		loginProviders = append(loginProviders, newGitHubProvider(lc.GitHub))
+	}
+	if lc.Google != nil {
+		c := *lc.Google
+		c.Name = "google"
+		if c.DisplayName == "" {
+			c.DisplayName = "Google"
+		}
+		if c.Issuer == "" {
+			c.Issuer = "https://accounts.google.com"
+		}
+		if c.UserClaim == "" {
+			c.UserClaim = "email"
+		}
+		loginProviders = append(loginProviders, newOIDCProvider(&c))
+	}
+	for _, c := range lc.OIDC {
+		if c.Name == "" || strings.Contains(c.Name, ":") || isReservedProviderName(c.Name) {
+			return fmt.Errorf("invalid OIDC provider name %q", c.Name)
+		}
+		if findLoginProvider(c.Name) != nil {
+			return fmt.Errorf("duplicate OIDC provider name %q", c.Name)
+		}
+		if c.Issuer == "" {
+			return fmt.Errorf("OIDC provider %q has no Issuer", c.Name)
+		}
+		if c.DisplayName == "" {
+			c.DisplayName = c.Name
+		}
+		loginProviders = append(loginProviders, newOIDCProvider(c))
+	}
+	return nil
+}
+
+// User identities are in the form ${provider}:${name} e.g. "github:alice".
+// For compatibility with data created when Twitter was the only way to log
+// in, Twitter users don't have a prefix.
+func userIdentity(provider, name string) string {
+	if provider == twitterProviderName {
+		return name
+	}
+	return provider + ":" + name
+}
+
+func splitUserIdentity(id string) (provider, name string) {
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) == 1 {
+		return twitterProviderName, id
+	}
+	return parts[0], parts[1]
+}
+
+// normalizeUserIdentity allows using "twitter:alice" in config.json for
+// a user stored as "alice"
+func normalizeUserIdentity(id string) string {
+	id = strings.TrimSpace(id)
+	return userIdentity(splitUserIdentity(id))
+}
+
+// canonicalUser maps identity to the identity under which user's data is
+// stored. It allows users of existing Twitter accounts to log in with other
+// providers and keep their translations attributed to them
+func canonicalUser(id string) string {
+	for from, to := range config.UserAliases {
+		if normalizeUserIdentity(from) == id {
+			return normalizeUserIdentity(to)
+		}
+	}
+	return id
+}
+
+// userProfileURL returns url of user's profile page at the provider or ""
+// if not known
+func userProfileURL(id string) string {
+	provider, name := splitUserIdentity(id)
+	switch provider {
+	case twitterProviderName:
+		return "https://twitter.com/" + url.PathEscape(name)
+	case "github":
+		return "https://github.com/" + url.PathEscape(name)
+	}
+	return ""
+}
+
+// ModelLogin describes a page where user picks login provider
+type ModelLogin struct {
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	// redirect after logging in
+	Redirect  string
+	Providers []loginProvider
+}
+
+type loginProviderSeq []loginProvider
+
+func (s loginProviderSeq) Len() int           { return len(s) }
+func (s loginProviderSeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s loginProviderSeq) Less(i, j int) bool { return s[i].DisplayName() < s[j].DisplayName() }
+
+func serveLoginChooser(w http.ResponseWriter, r *http.Request, redirect string) {
+	providers := make([]loginProvider, len(loginProviders))
+	copy(providers, loginProviders)
+	sort.Sort(loginProviderSeq(providers))
+	model := &ModelLogin{
+		PageTitle:   "Log in to AppTranslator",
+		User:        decodeUserFromCookie(r),
+		RedirectUrl: redirect,
+		CsrfToken:   csrfToken(r),
+		Redirect:    url.QueryEscape(redirect),
+		Providers:   providers,
+	}
+	ExecTemplate(w, tmplLogin, model)
+}
@@ -0,0 +1,176 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPConfig describes SMTP server used for sending emails
+type SMTPConfig struct {
+	Host string
+	// defaults to 587
+	Port int
+	// optional, if not given we don't authenticate
+	User     string
+	Password string
+	// e.g. "AppTranslator <noreply@apptranslator.org>"
+	From string
+}
+
+// mailHeader is an additional header of an email, e.g. List-Unsubscribe
+type mailHeader struct {
+	Name  string
+	Value string
+}
+
+// mailer sends emails
+type mailer interface {
+	SendMail(to, subject, body string, headers ...mailHeader) error
+}
+
+var mailSender mailer
+
+func initMailer() {
+	if config.SMTP != nil && config.SMTP.Host != "" {
+		mailSender = &smtpMailer{config: config.SMTP}
+		return
+	}
+	if config.MailDir != "" {
+		mailSender = &fileMailer{dir: config.MailDir}
+		return
+	}
+	mailSender = &stubMailer{}
+}
+
+func validateMailHeader(s string) error {
+	if strings.ContainsAny(s, "\r\n") {
+		return fmt.Errorf("invalid email header value %q", s)
+	}
+	return nil
+}
+
+// buildMail returns email message in RFC 5322 format
+func buildMail(from, to, subject, body string, headers []mailHeader) ([]byte, error) {
+	for _, s := range []string{to, subject, from} {
+		if err := validateMailHeader(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range headers {
+		if err := validateMailHeader(h.Name + h.Value); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.Name, h.Value)
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.Replace(normalizeNewlines(body), "\n", "\r\n", -1))
+	return buf.Bytes(), nil
+}
+
+type smtpMailer struct {
+	config *SMTPConfig
+}
+
+// SendMail sends plain text email
+func (m *smtpMailer) SendMail(to, subject, body string, headers ...mailHeader) error {
+	c := m.config
+	msg, err := buildMail(c.From, to, subject, body, headers)
+	if err != nil {
+		return err
+	}
+	port := c.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if c.User != "" {
+		auth = smtp.PlainAuth("", c.User, c.Password, c.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", c.Host, port)
+	from := c.From
+	if addr, err := mailAddress(from); err == nil {
+		from = addr
+	}
+	return smtp.SendMail(addr, auth, from, []string{to}, msg)
+}
+
+// mailAddress extracts address from "Name <address>"
+func mailAddress(s string) (string, error) {
+	start := strings.LastIndex(s, "<")
+	end := strings.LastIndex(s, ">")
+	if start == -1 && end == -1 {
+		return strings.TrimSpace(s), nil
+	}
+	if start == -1 || end < start {
+		return "", fmt.Errorf("invalid email address %q", s)
+	}
+	return s[start+1 : end], nil
+}
+
+type mailMessage struct {
+	To      string
+	Subject string
+	Body    string
+	Headers []mailHeader
+}
+
+// stubMailer doesn't send emails, only logs and remembers them. Used when
+// SMTP is not configured and in tests
+type stubMailer struct {
+	sync.Mutex
+	Sent []mailMessage
+}
+
+func (m *stubMailer) SendMail(to, subject, body string, headers ...mailHeader) error {
+	if _, err := buildMail("", to, subject, body, headers); err != nil {
+		return err
+	}
+	m.Lock()
+	m.Sent = append(m.Sent, mailMessage{To: to, Subject: subject, Body: body, Headers: headers})
+	m.Unlock()
+	logger.Noticef("Not sending email (SMTP not configured) to %s, subject: %q\n%s", to, subject, body)
+	return nil
+}
+
+// fileMailer writes emails to .eml files in a directory instead of sending
+// them. Handy for checking how emails look during development
+type fileMailer struct {
+	sync.Mutex
+	dir string
+	n   int
+}
+
+func (m *fileMailer) SendMail(to, subject, body string, headers ...mailHeader) error {
+	msg, err := buildMail("AppTranslator <noreply@localhost>", to, subject, body, headers)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	m.n++
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102-150405"), m.n)
+	m.Unlock()
+	if err = os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(m.dir, name)
+	logger.Noticef("Writing email to %s, subject: %q to %s", path, subject, to)
+	return ioutil.WriteFile(path, msg, 0644)
+}
@@ -53,12 +53,39 @@ var (
 		AwsSecret               *string
 		S3BackupBucket          *string
 		S3BackupDir             *string
+		// login providers other than Twitter
+		Login *LoginConfig
+		// maps user identity to another identity under which user's data
+		// is stored e.g. "github:alice" => "alice" to keep translations done
+		// by Twitter user "alice" attributed to them when they log in with
+		// GitHub
+		UserAliases map[string]string
+		// global admins, in addition to those granted admin role on /admin
+		Admins []string
+		// enables logging in with user name and password
+		LocalAccounts bool
+		// used for sending emails. If not set, emails are only logged
+		SMTP *SMTPConfig
+		// public url of the server e.g. "https://www.apptranslator.org",
+		// used for login callbacks and links in emails and feeds. If not
+		// set, it's derived from the request
+		BaseURL string
+		// limits that protect against abuse. If not set, defaults are used
+		RateLimits *RateLimitConfig
+		// if set and SMTP is not, emails are written as .eml files to this
+		// directory instead of being sent
+		MailDir string
 	}{
 		&oauthClient.Credentials,
 		nil,
 		nil, nil,
 		nil, nil,
 		nil, nil,
+		nil, nil,
+		nil,
+		false, nil,
+		"", nil,
+		"",
 	}
 	logger        *ServerLogger
 	cookieAuthKey []byte
@@ -73,6 +100,9 @@ var (
 
 	appState = AppState{}
 
+	// information about users, shared by all apps
+	userStore *store.UserStore
+
 	alwaysLogTime = true
 )
 
@@ -138,12 +168,13 @@ type AppConfig struct {
 	// url for the application's website (shown in the UI)
 	Url     string
 	DataDir string
-	// we authenticate only with Twitter, this is the twitter user name
-	// of the admin user
+	// twitter user names of the admin users. Deprecated, use Admins
 	AdminTwitterUser  string
 	AdminTwitterUser2 string
+	// identities of admin users e.g. "github:alice"
+	Admins []string
 	// an arbitrary string, used to protect the API for uploading new strings
-	// for the app
+	// for the app. Deprecated, use API tokens created on /admin/tokens
 	UploadSecret string
 }
 
@@ -215,6 +246,10 @@ func (a *App) storeCsvFilePath() string {
 	return dataFilePath
 }
 
+func userStoreFilePath() string {
+	return filepath.Join(getDataDir(), "users.csv")
+}
+
 func readAppData(app *App) error {
 	var path string
 	path = app.storeCsvFilePath()
@@ -248,11 +283,8 @@ func appInvalidField(app *App) string {
 	if app.DataDir == "" {
 		return "DataDir"
 	}
-	if app.AdminTwitterUser == "" {
-		return "AdminTwitterUser"
-	}
-	if app.UploadSecret == "" {
-		return "UploadSecret"
+	if app.AdminTwitterUser == "" && len(app.Admins) == 0 {
+		return "Admins"
 	}
 	return ""
 }
@@ -275,13 +307,6 @@ func isTopLevelURL(url string) bool {
 	return 0 == len(url) || "/" == url
 }
 
-func userIsAdmin(app *App, user string) bool {
-	if user == "" {
-		return false
-	}
-	return user == app.AdminTwitterUser || user == app.AdminTwitterUser2
-}
-
 // reads the configuration file from the path specified by
 // the config command line flag.
 func readConfig(configFile string) error {
@@ -293,6 +318,12 @@ func readConfig(configFile string) error {
 	if err != nil {
 		return err
 	}
+	if err = validateBaseURL(config.BaseURL); err != nil {
+		return err
+	}
+	if config.LocalAccounts && config.BaseURL == "" {
+		return errors.New("BaseURL must be set in config when LocalAccounts is enabled, it's used for links in emails")
+	}
 	cookieAuthKey, err = hex.DecodeString(*config.CookieAuthKeyHexStr)
 	if err != nil {
 		return err
@@ -302,6 +333,7 @@ func readConfig(configFile string) error {
 		return err
 	}
 	secureCookie = securecookie.New(cookieAuthKey, cookieEncrKey)
+	secureCookie.MaxAge(int(sessionMaxAge / time.Second))
 	// verify auth/encr keys are correct
 	val := map[string]string{
 		"foo": "bar",
@@ -335,7 +367,7 @@ func makeTimingHandler(fn func(http.ResponseWriter, *http.Request)) http.Handler
 		if shouldLog {
 			url := r.URL.Path
 			if len(r.URL.RawQuery) > 0 {
-				url = fmt.Sprintf("%s?%s", url, r.URL.RawQuery)
+				url = fmt.Sprintf("%s?%s", url, redactSecrets(r.URL.RawQuery))
 			}
 			logger.Noticef("%q took %f seconds to serve", url, duration.Seconds())
 		}
@@ -375,6 +407,20 @@ func main() {
 		log.Fatalf("Failed reading config file %s. %s\n", *configPath, err)
 	}
 
+	initMailer()
+	initRateLimits()
+	if err := initLoginProviders(); err != nil {
+		log.Fatalf("Invalid login configuration in %s. %s\n", *configPath, err)
+	}
+	if len(loginProviders) == 0 {
+		logger.Notice("No login providers configured, users won't be able to log in")
+	}
+
+	var err error
+	if userStore, err = store.NewUserStore(userStoreFilePath()); err != nil {
+		log.Fatalf("Failed to open user store %s, err: %s\n", userStoreFilePath(), err)
+	}
+
 	for _, appData := range config.Apps {
 		app := NewApp(&appData)
 		if err := addApp(app); err != nil {
@@ -383,6 +429,7 @@ func main() {
 			logger.Noticef("Added app %s\n", app.Name)
 		}
 	}
+	syncHiddenUsers()
 
 	// for testing, add a dummy app if no apps exist
 	if len(appState.Apps) == 0 {
@@ -401,6 +448,12 @@ func main() {
 		go s3BackupLoop(backupConfig)
 	}
 
+	if config.BaseURL != "" {
+		go digestLoop()
+	} else {
+		logger.Notice("BaseURL is not set in config, email digests won't be sent")
+	}
+
 	var wg sync.WaitGroup
 	var httpsSrv, httpSrv *http.Server
 
@@ -413,8 +466,8 @@ func main() {
 		httpsSrv.Addr = ":443"
 		httpsSrv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
 		logger.Noticef("Started runing HTTPS on %s\n", httpsSrv.Addr)
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			err := httpsSrv.ListenAndServeTLS("", "")
 			// mute error caused by Shutdown()
 			if err == http.ErrServerClosed {
@@ -429,8 +482,8 @@ func main() {
 	httpSrv = makeHTTPServer()
 	httpSrv.Addr = *httpAddr
 	logger.Noticef("Starting http server on %s, data dir: %s", httpSrv.Addr, getDataDir())
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		err := httpSrv.ListenAndServe()
 		// mute error caused by Shutdown()
 		if err == http.ErrServerClosed {
@@ -459,5 +512,6 @@ func main() {
 			app.store.Close()
 		}
 	}
+	userStore.Close()
 	fmt.Printf("Apptranslator has successfully exited\n")
 }
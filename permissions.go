@@ -0,0 +1,110 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"strings"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+// Permissions, from most to least powerful:
+// - global admins (config.json Admins or admin role) can do everything
+// - app admins (AppConfig Admins, AdminTwitterUser or appadmin role) manage
+//   an app and its maintainers
+// - language maintainers can review and revert translations in a language
+// - translators (any logged in user) can edit translations
+// - banned users can't edit anything; edits of hidden users are not shown
+
+func userHasRole(user, role, scope string) bool {
+	if user == "" || userStore == nil {
+		return false
+	}
+	return userStore.HasRole(user, role, scope)
+}
+
+func isUserInList(user string, list []string) bool {
+	for _, s := range list {
+		if normalizeUserIdentity(s) == user {
+			return true
+		}
+	}
+	return false
+}
+
+func userIsGlobalAdmin(user string) bool {
+	if user == "" {
+		return false
+	}
+	return isUserInList(user, config.Admins) || userHasRole(user, store.RoleAdmin, "")
+}
+
+func userIsAdmin(app *App, user string) bool {
+	if user == "" {
+		return false
+	}
+	if user == app.AdminTwitterUser || user == app.AdminTwitterUser2 {
+		return true
+	}
+	if isUserInList(user, app.Admins) {
+		return true
+	}
+	return userIsGlobalAdmin(user) || userHasRole(user, store.RoleAppAdmin, app.Name)
+}
+
+func maintainerScope(app *App, langCode string) string {
+	return app.Name + "/" + langCode
+}
+
+func userIsMaintainer(app *App, langCode, user string) bool {
+	if userIsAdmin(app, user) {
+		return true
+	}
+	return userHasRole(user, store.RoleMaintainer, maintainerScope(app, langCode))
+}
+
+func userIsBanned(user string) bool {
+	return userHasRole(user, store.RoleBanned, "")
+}
+
+// userCanTranslate returns true if user can edit translations and comment
+func userCanTranslate(user string) bool {
+	return user != "" && !userIsBanned(user)
+}
+
+// userCanManageRole returns true if user can grant or revoke a role.
+// App admins can only manage maintainers of their apps
+func userCanManageRole(user, role, scope string) bool {
+	if userIsGlobalAdmin(user) {
+		return true
+	}
+	if role != store.RoleMaintainer {
+		return false
+	}
+	appName := strings.Split(scope, "/")[0]
+	app := findApp(appName)
+	return app != nil && userIsAdmin(app, user)
+}
+
+// userCanSeeAdminPage returns true if user is an admin of anything
+func userCanSeeAdminPage(user string) bool {
+	if userIsGlobalAdmin(user) {
+		return true
+	}
+	for _, app := range appState.Apps {
+		if userIsAdmin(app, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncHiddenUsers tells stores whose edits should be hidden. Must be called
+// after changing hidden role
+func syncHiddenUsers() {
+	hidden := userStore.UsersWithRole(store.RoleHidden)
+	for _, app := range appState.Apps {
+		if err := app.store.SetHiddenUsers(hidden); err != nil {
+			logger.Errorf("syncHiddenUsers(): SetHiddenUsers() for %s failed with %s", app.Name, err)
+		}
+	}
+}
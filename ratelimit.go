@@ -0,0 +1,418 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig configures limits that protect against abuse. Zero values
+// mean default values, negative values disable a given limit
+type RateLimitConfig struct {
+	// edits and comments by a logged in user
+	UserPerMinute int
+	UserBurst     int
+	// edits, comments, logins and API requests from an IP address
+	IPPerMinute int
+	IPBurst     int
+	// API requests made with a token
+	TokenPerMinute int
+	TokenBurst     int
+	// confirmation emails sent to an email address
+	EmailPerHour int
+	EmailBurst   int
+	// after MaxAuthFailures failed logins or API requests with invalid
+	// token from an IP address within LockoutMinutes, the IP address is
+	// locked out for LockoutMinutes
+	MaxAuthFailures int
+	LockoutMinutes  int
+	// a user overwriting more than MassEditThreshold translations by other
+	// users within MassEditMinutes is flagged for admins
+	MassEditThreshold int
+	MassEditMinutes   int
+	// use X-Forwarded-For header for client's IP address. Only set it when
+	// running behind a proxy that sets it
+	TrustXForwardedFor bool
+}
+
+var (
+	userLimiter  *rateLimiter
+	ipLimiter    *rateLimiter
+	tokenLimiter *rateLimiter
+	emailLimiter *rateLimiter
+	authLockouts *lockouts
+	massEdits    *massEditDetector
+)
+
+func intOrDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+func initRateLimits() {
+	c := config.RateLimits
+	if c == nil {
+		c = &RateLimitConfig{}
+	}
+	userLimiter = newRateLimiter(intOrDefault(c.UserPerMinute, 60), intOrDefault(c.UserBurst, 30))
+	ipLimiter = newRateLimiter(intOrDefault(c.IPPerMinute, 120), intOrDefault(c.IPBurst, 60))
+	tokenLimiter = newRateLimiter(intOrDefault(c.TokenPerMinute, 30), intOrDefault(c.TokenBurst, 10))
+	emailLimiter = newRateLimiterPer(intOrDefault(c.EmailPerHour, 5), time.Hour, intOrDefault(c.EmailBurst, 3))
+	lockout := time.Duration(intOrDefault(c.LockoutMinutes, 15)) * time.Minute
+	authLockouts = newLockouts(intOrDefault(c.MaxAuthFailures, 10), lockout)
+	window := time.Duration(intOrDefault(c.MassEditMinutes, 10)) * time.Minute
+	massEdits = newMassEditDetector(intOrDefault(c.MassEditThreshold, 50), window)
+}
+
+// clientIP returns IP address of the client that made r
+func clientIP(r *http.Request) string {
+	if config.RateLimits != nil && config.RateLimits.TrustXForwardedFor {
+		// the last address is the one seen by our proxy, the others could
+		// be spoofed by the client
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter implements token bucket algorithm: each key has a bucket of
+// burst tokens, refilled at perMinute rate. A request takes a token
+type rateLimiter struct {
+	sync.Mutex
+	perSec  float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// we prune full buckets when there's more than that. If it's not enough
+// (e.g. a flood of requests from many addresses), we evict least recently
+// used buckets down to rateLimiterEvictTo, so that we don't prune on every
+// request. Evicted keys start with a full bucket, i.e. we fail open for keys
+// that haven't been seen for the longest time
+const (
+	maxRateLimiterBuckets = 10000
+	rateLimiterEvictTo    = maxRateLimiterBuckets * 9 / 10
+)
+
+// newRateLimiter returns nil (no limits) if perMinute is not positive
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return newRateLimiterPer(perMinute, time.Minute, burst)
+}
+
+// newRateLimiterPer returns a limiter of n requests per a given duration,
+// nil (no limits) if n is not positive
+func newRateLimiterPer(n int, per time.Duration, burst int) *rateLimiter {
+	if n <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perSec:  float64(n) / per.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.perSec
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	// b.last is the time of the last request for a key, so we don't update
+	// it here
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSec >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < maxRateLimiterBuckets {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-rateLimiterEvictTo] {
+		delete(l.buckets, key)
+	}
+}
+
+// allow returns true if a request for key is within limits
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimiterBuckets {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// lockouts locks out keys (IP addresses) after too many authentication
+// failures
+type lockouts struct {
+	sync.Mutex
+	maxFailures int
+	duration    time.Duration
+	// failures within the current window
+	failures map[string][]time.Time
+	until    map[string]time.Time
+}
+
+// we prune expired failures and lockouts when there's more than that
+const maxLockoutKeys = 10000
+
+// newLockouts returns nil (no lockouts) if maxFailures is not positive
+func newLockouts(maxFailures int, duration time.Duration) *lockouts {
+	if maxFailures <= 0 {
+		return nil
+	}
+	return &lockouts{
+		maxFailures: maxFailures,
+		duration:    duration,
+		failures:    make(map[string][]time.Time),
+		until:       make(map[string]time.Time),
+	}
+}
+
+func (l *lockouts) prune(now time.Time) {
+	for key, times := range l.failures {
+		if now.Sub(times[len(times)-1]) >= l.duration {
+			delete(l.failures, key)
+		}
+	}
+	for key, until := range l.until {
+		if now.After(until) {
+			delete(l.until, key)
+		}
+	}
+}
+
+// isLocked returns true if key is locked out
+func (l *lockouts) isLocked(key string, now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	until, ok := l.until[key]
+	if !ok {
+		return false
+	}
+	if now.After(until) {
+		delete(l.until, key)
+		return false
+	}
+	return true
+}
+
+// recordFailure records a failed authentication and returns true if it
+// caused a lockout
+func (l *lockouts) recordFailure(key string, now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.failures[key]; !ok && len(l.failures)+len(l.until) >= maxLockoutKeys {
+		l.prune(now)
+	}
+	var recent []time.Time
+	for _, t := range l.failures[key] {
+		if now.Sub(t) < l.duration {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < l.maxFailures {
+		l.failures[key] = recent
+		return false
+	}
+	delete(l.failures, key)
+	l.until[key] = now.Add(l.duration)
+	return true
+}
+
+// MassEditFlag describes a user that overwrote many translations of other
+// users in a short time
+type MassEditFlag struct {
+	User  string
+	App   string
+	Lang  string
+	Count int
+	Time  time.Time
+}
+
+// massEditDetector flags users that overwrite many translations
+type massEditDetector struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	// maps user to times of recent overwrites
+	overwrites map[string][]time.Time
+	// maps user to the time they were last flagged
+	flagged map[string]time.Time
+	flags   []*MassEditFlag
+}
+
+// we only remember that many most recent flags
+const maxMassEditFlags = 100
+
+func newMassEditDetector(threshold int, window time.Duration) *massEditDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &massEditDetector{
+		threshold:  threshold,
+		window:     window,
+		overwrites: make(map[string][]time.Time),
+		flagged:    make(map[string]time.Time),
+	}
+}
+
+// recordOverwrite records that user overwrote a translation of another user
+// and returns a flag if it's one too many
+func (d *massEditDetector) recordOverwrite(user, app, lang string, now time.Time) *MassEditFlag {
+	if d == nil {
+		return nil
+	}
+	d.Lock()
+	defer d.Unlock()
+	var recent []time.Time
+	for _, t := range d.overwrites[user] {
+		if now.Sub(t) < d.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	d.overwrites[user] = recent
+	if len(recent) <= d.threshold {
+		return nil
+	}
+	// flag at most once per window
+	if last, ok := d.flagged[user]; ok && now.Sub(last) < d.window {
+		return nil
+	}
+	d.flagged[user] = now
+	flag := &MassEditFlag{User: user, App: app, Lang: lang, Count: len(recent), Time: now}
+	d.flags = append(d.flags, flag)
+	if len(d.flags) > maxMassEditFlags {
+		d.flags = d.flags[len(d.flags)-maxMassEditFlags:]
+	}
+	return flag
+}
+
+// recentFlags returns recent flags, newest first
+func (d *massEditDetector) recentFlags() []*MassEditFlag {
+	if d == nil {
+		return nil
+	}
+	d.Lock()
+	defer d.Unlock()
+	n := len(d.flags)
+	res := make([]*MassEditFlag, n)
+	for i, f := range d.flags {
+		res[n-1-i] = f
+	}
+	return res
+}
+
+// isRateLimited returns true if a request from user (can be "") is over
+// the limits
+func isRateLimited(r *http.Request, user string) bool {
+	now := time.Now()
+	ip := clientIP(r)
+	if !ipLimiter.allow(ip, now) {
+		logger.Noticef("Rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+		return true
+	}
+	if user != "" && !userLimiter.allow(user, now) {
+		logger.Noticef("Rate limited %s %s by %s", r.Method, r.URL.Path, user)
+		return true
+	}
+	return false
+}
+
+// writeTooManyRequests writes 429 response
+func writeTooManyRequests(w http.ResponseWriter, msg string) {
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// checkRateLimit writes an error and returns false if a request from user
+// is over the limits
+func checkRateLimit(w http.ResponseWriter, r *http.Request, user string) bool {
+	if isRateLimited(r, user) {
+		writeTooManyRequests(w, "Too many requests, please slow down")
+		return false
+	}
+	return true
+}
+
+// checkAuthLockout writes an error and returns false if client's IP address
+// is locked out because of too many failed authentication attempts
+func checkAuthLockout(w http.ResponseWriter, r *http.Request) bool {
+	if authLockouts.isLocked(clientIP(r), time.Now()) {
+		writeTooManyRequests(w, "Too many failed attempts, try again later")
+		return false
+	}
+	return true
+}
+
+// recordAuthFailure records a failed login or API request with invalid token
+func recordAuthFailure(r *http.Request) {
+	ip := clientIP(r)
+	if authLockouts.recordFailure(ip, time.Now()) {
+		logger.Errorf("Locked out %s after too many failed authentication attempts", ip)
+	}
+}
+
+// checkOverwrite flags user if the edit of str overwrites a translation of
+// another user and there were too many such edits recently
+func checkOverwrite(app *App, str, lang, user, translation string) {
+	cur, curUser := app.store.CurrentTranslation(str, lang)
+	if cur == "" || cur == translation || curUser == user {
+		return
+	}
+	if flag := massEdits.recordOverwrite(user, app.Name, lang, time.Now()); flag != nil {
+		logger.Errorf("%s overwrote %d translations of other users in %s/%s", user, flag.Count, app.Name, lang)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	now := time.Now()
+	if !l.allow("a", now) || !l.allow("a", now) {
+		t.Fatalf("burst should be allowed")
+	}
+	if l.allow("a", now) {
+		t.Fatalf("request over burst should be refused")
+	}
+	if !l.allow("b", now) {
+		t.Fatalf("other keys should have their own limits")
+	}
+	// 60 per minute is one per second
+	if !l.allow("a", now.Add(time.Second)) || l.allow("a", now.Add(time.Second)) {
+		t.Fatalf("bucket should be refilled by one after a second")
+	}
+	if newRateLimiter(-1, 10).allow("a", now) != true {
+		t.Fatalf("disabled limiter should allow everything")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+	// a flood of keys that don't refill before the next one
+	for i := 0; i < maxRateLimiterBuckets; i++ {
+		l.allow(strconv.Itoa(i), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(l.buckets) != maxRateLimiterBuckets {
+		t.Fatalf("expected %d buckets, got %d", maxRateLimiterBuckets, len(l.buckets))
+	}
+	later := now.Add(time.Duration(maxRateLimiterBuckets) * time.Millisecond)
+	l.allow("new", later)
+	if len(l.buckets) != rateLimiterEvictTo+1 {
+		t.Fatalf("expected %d buckets after eviction, got %d", rateLimiterEvictTo+1, len(l.buckets))
+	}
+	// least recently used buckets are evicted first
+	if l.buckets["0"] != nil || l.buckets[strconv.Itoa(maxRateLimiterBuckets-1)] == nil {
+		t.Fatalf("wrong buckets evicted")
+	}
+}
+
+func TestLockouts(t *testing.T) {
+	l := newLockouts(3, time.Minute)
+	now := time.Now()
+	l.recordFailure("ip", now)
+	// failures outside of the window don't count
+	l.recordFailure("ip", now.Add(-2*time.Minute))
+	l.recordFailure("ip", now)
+	if l.isLocked("ip", now) {
+		t.Fatalf("shouldn't be locked after 2 recent failures")
+	}
+	if !l.recordFailure("ip", now) || !l.isLocked("ip", now) {
+		t.Fatalf("should be locked after 3 failures")
+	}
+	if l.isLocked("other", now) {
+		t.Fatalf("other keys shouldn't be locked")
+	}
+	if l.isLocked("ip", now.Add(2*time.Minute)) {
+		t.Fatalf("lockout should expire")
+	}
+}
+
+func TestLockoutsPrune(t *testing.T) {
+	l := newLockouts(2, time.Minute)
+	now := time.Now()
+	for i := 0; i < maxLockoutKeys; i++ {
+		key := strconv.Itoa(i)
+		l.recordFailure(key, now)
+		if i%2 == 0 {
+			l.recordFailure(key, now)
+		}
+	}
+	if len(l.failures)+len(l.until) != maxLockoutKeys {
+		t.Fatalf("expected %d keys, got %d", maxLockoutKeys, len(l.failures)+len(l.until))
+	}
+	later := now.Add(2 * time.Minute)
+	l.recordFailure("new", later)
+	if len(l.failures) != 1 || len(l.until) != 0 {
+		t.Fatalf("expired keys should be pruned, have %d failures and %d lockouts", len(l.failures), len(l.until))
+	}
+}
+
+func TestMassEditDetector(t *testing.T) {
+	d := newMassEditDetector(3, time.Minute)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if d.recordOverwrite("bob", "app", "pl", now) != nil {
+			t.Fatalf("shouldn't flag %d overwrites", i+1)
+		}
+	}
+	f := d.recordOverwrite("bob", "app", "pl", now)
+	if f == nil || f.Count != 4 || f.User != "bob" {
+		t.Fatalf("unexpected flag %#v", f)
+	}
+	if d.recordOverwrite("bob", "app", "pl", now) != nil {
+		t.Fatalf("should only flag once per window")
+	}
+	if flags := d.recentFlags(); len(flags) != 1 {
+		t.Fatalf("unexpected flags %#v", flags)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func() { config.RateLimits = nil }()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	config.RateLimits = nil
+	if ip := clientIP(r); ip != "10.0.0.1" {
+		t.Fatalf("X-Forwarded-For shouldn't be trusted by default, got %s", ip)
+	}
+	config.RateLimits = &RateLimitConfig{TrustXForwardedFor: true}
+	if ip := clientIP(r); ip != "5.6.7.8" {
+		t.Fatalf("expected address seen by the proxy, got %s", ip)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	defer setupTestUserStore(t, "ratelimittest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	_, cleanup := setupTestApp(t, "TestApp", "ratelimitapptest.dat")
+	defer cleanup()
+	config.RateLimits = &RateLimitConfig{MaxAuthFailures: 3}
+	initRateLimits()
+	defer func() {
+		config.RateLimits = nil
+		userLimiter, ipLimiter, tokenLimiter, emailLimiter, authLockouts, massEdits = nil, nil, nil, nil, nil, nil
+	}()
+
+	_, token := createTestToken(t, "TestApp", "ci", store.ScopeUploadStrings)
+	for i := 0; i < 3; i++ {
+		if rr := uploadStrings(h, "TestApp", "apptr_guess", ""); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("upload with invalid token returned %d", rr.Code)
+		}
+	}
+	// even a valid token is refused when locked out
+	if rr := uploadStrings(h, "TestApp", token, ""); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("upload when locked out returned %d", rr.Code)
+	}
+}
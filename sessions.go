@@ -0,0 +1,149 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/kjk/apptranslator/store"
+)
+
+// Logged in users have a session, recorded in user store so that it can be
+// revoked. The cookie only stores session id and user. Sessions expire after
+// sessionMaxAge, after which users have to log in again.
+//
+// Requests that change state must be POSTs with a CSRF token derived from
+// session id, sent as csrf form value or X-CSRF-Token header.
+
+const sessionMaxAge = 30 * 24 * time.Hour
+
+func newSessionID() string {
+	return fmt.Sprintf("%x", securecookie.GenerateRandomKey(16))
+}
+
+// isHTTPS returns true if r was made over https, either directly or via
+// a proxy in front of us
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// startSession creates a new session for user
+func startSession(user string) (*store.Session, error) {
+	if userStore == nil {
+		return nil, fmt.Errorf("no user store")
+	}
+	return userStore.CreateSession(newSessionID(), user)
+}
+
+// getValidSession returns the session from a cookie if it's still valid
+func getValidSession(cookie *SecureCookieValue) *store.Session {
+	if cookie == nil || cookie.SessionID == "" || userStore == nil {
+		return nil
+	}
+	sess := userStore.GetSession(cookie.SessionID)
+	if sess == nil || sess.Revoked || sess.User != cookie.User {
+		return nil
+	}
+	if time.Since(sess.Created) > sessionMaxAge {
+		return nil
+	}
+	return sess
+}
+
+// sessionsCreatedAfter returns the time before which sessions are expired
+func sessionsCreatedAfter() time.Time {
+	return time.Now().Add(-sessionMaxAge)
+}
+
+func csrfTokenForSession(sessionID string) string {
+	mac := hmac.New(sha256.New, cookieAuthKey)
+	mac.Write([]byte("csrf:" + sessionID))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// csrfToken returns CSRF token to be included in forms, "" if not logged in
+func csrfToken(r *http.Request) string {
+	sess := getValidSession(getSecureCookie(r))
+	if sess == nil {
+		return ""
+	}
+	return csrfTokenForSession(sess.ID)
+}
+
+func isValidCSRFToken(r *http.Request) bool {
+	expected := csrfToken(r)
+	if expected == "" {
+		return false
+	}
+	got := r.Header.Get("X-CSRF-Token")
+	if got == "" {
+		got = r.FormValue("csrf")
+	}
+	return hmac.Equal([]byte(got), []byte(expected))
+}
+
+// checkMutatingRequest writes an error and returns false if r is not a POST
+// with a valid CSRF token. Must be called by handlers that change state
+func checkMutatingRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "must be POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !isValidCSRFToken(r) {
+		logger.Noticef("Invalid CSRF token in %s %s", r.Method, r.URL.Path)
+		http.Error(w, "invalid or missing CSRF token, please reload the page", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Forms used before logging in (local log in, sign up, password reset) can't
+// use a token derived from session id. Their token is derived from a random
+// nonce in preSessionCookieName cookie, so that other sites can't submit them
+// e.g. to log a victim into attacker's account.
+
+const preSessionCookieName = "ckiepre"
+
+func csrfTokenForNonce(nonce string) string {
+	mac := hmac.New(sha256.New, cookieAuthKey)
+	mac.Write([]byte("precsrf:" + nonce))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// preSessionCSRFToken returns CSRF token to be included in forms used before
+// logging in. Sets the nonce cookie if it's not already set
+func preSessionCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(preSessionCookieName); err == nil && c.Value != "" {
+		return csrfTokenForNonce(c.Value)
+	}
+	nonce := fmt.Sprintf("%x", securecookie.GenerateRandomKey(16))
+	http.SetCookie(w, &http.Cookie{
+		Name:     preSessionCookieName,
+		Value:    nonce,
+		Path:     "/",
+		Secure:   isHTTPS(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return csrfTokenForNonce(nonce)
+}
+
+// checkPreSessionRequest is like checkMutatingRequest for forms used before
+// logging in
+func checkPreSessionRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "must be POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	c, err := r.Cookie(preSessionCookieName)
+	if err != nil || c.Value == "" || !hmac.Equal([]byte(r.FormValue("csrf")), []byte(csrfTokenForNonce(c.Value))) {
+		logger.Noticef("Invalid CSRF token in %s %s", r.Method, r.URL.Path)
+		http.Error(w, "invalid or missing CSRF token, please reload the page", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// logs in user and returns cookies of the session
+func loginTestUser(t *testing.T, user string, https bool) []*http.Cookie {
+	req := httptest.NewRequest("GET", "/", nil)
+	if https {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+	rr := httptest.NewRecorder()
+	logInUser(rr, req, user, "test", "/")
+	if rr.Code != 302 {
+		t.Fatalf("logInUser() returned %d, %s", rr.Code, rr.Body.String())
+	}
+	return rr.Result().Cookies()
+}
+
+func userFromCookies(cookies []*http.Cookie) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return decodeUserFromCookie(req)
+}
+
+func csrfFromCookies(cookies []*http.Cookie) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return csrfToken(req)
+}
+
+func TestSessionCookie(t *testing.T) {
+	defer setupTestUserStore(t, "sessionstest.dat")()
+	setupLoginTest(t, nil, nil)
+
+	cookies := loginTestUser(t, "alice", false)
+	if len(cookies) != 1 {
+		t.Fatalf("expected 1 cookie, got %d", len(cookies))
+	}
+	c := cookies[0]
+	if !c.HttpOnly || c.Secure || c.MaxAge <= 0 || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected cookie flags %#v", c)
+	}
+	if userFromCookies(cookies) != "alice" {
+		t.Fatalf("user should be logged in")
+	}
+	if c = loginTestUser(t, "alice", true)[0]; !c.Secure {
+		t.Fatalf("cookie set over https should be secure")
+	}
+
+	// a cookie without a session, e.g. created by older versions, is not
+	// a valid login
+	rr := httptest.NewRecorder()
+	setSecureCookie(rr, httptest.NewRequest("GET", "/", nil), &SecureCookieValue{User: "alice"})
+	if userFromCookies(rr.Result().Cookies()) != "" {
+		t.Fatalf("cookie without session shouldn't be valid")
+	}
+
+	// revoking sessions logs the user out
+	if _, err := userStore.RevokeUserSessions("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if userFromCookies(cookies) != "" {
+		t.Fatalf("revoked session shouldn't be valid")
+	}
+}
+
+func TestLogoutAndCSRF(t *testing.T) {
+	defer setupTestUserStore(t, "sessionstest.dat")()
+	h := setupLoginTest(t, nil, nil)
+
+	cookies := loginTestUser(t, "alice", false)
+	csrf := csrfFromCookies(cookies)
+	if csrf == "" || csrf != csrfFromCookies(cookies) {
+		t.Fatalf("csrf token should be stable for a session")
+	}
+	if other := csrfFromCookies(loginTestUser(t, "alice", false)); other == csrf {
+		t.Fatalf("csrf token should be different for each session")
+	}
+
+	req := httptest.NewRequest("GET", "/logout?redirect=/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /logout returned %d", rr.Code)
+	}
+
+	vals := url.Values{"redirect": {"/"}}
+	if rr = postForm(h, "/logout", vals, cookies); rr.Code != http.StatusForbidden {
+		t.Fatalf("/logout without csrf token returned %d", rr.Code)
+	}
+	// state changing requests need csrf token
+	edit := url.Values{"app": {"foo"}, "lang": {"pl"}, "string": {"s"}, "translation": {"t"}}
+	if rr = postForm(h, "/edittranslation", edit, cookies); rr.Code != http.StatusForbidden {
+		t.Fatalf("/edittranslation without csrf token returned %d", rr.Code)
+	}
+	vals.Set("csrf", "bad")
+	if rr = postForm(h, "/logout", vals, cookies); rr.Code != http.StatusForbidden {
+		t.Fatalf("/logout with bad csrf token returned %d", rr.Code)
+	}
+
+	vals.Set("csrf", csrf)
+	rr = postForm(h, "/logout", vals, cookies)
+	if rr.Code != 302 {
+		t.Fatalf("/logout returned %d", rr.Code)
+	}
+	if c := rr.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Fatalf("/logout should delete the cookie")
+	}
+	// the session can't be reused even if someone kept the cookie
+	if userFromCookies(cookies) != "" {
+		t.Fatalf("session should be revoked after logging out")
+	}
+}
@@ -0,0 +1,140 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Account is a local (username and password) account
+type Account struct {
+	Name  string
+	Email string
+	// hash of the password, in a format understood by the caller
+	PasswordHash string
+	// true if the user has confirmed they own the email
+	Verified bool
+	Created  time.Time
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (s *UserStore) setAccount(acc *Account) {
+	if prev := s.accounts[acc.Name]; prev != nil {
+		delete(s.accountsByEmail, normalizeEmail(prev.Email))
+		acc.Created = prev.Created
+	}
+	s.accounts[acc.Name] = acc
+	s.accountsByEmail[normalizeEmail(acc.Email)] = acc
+}
+
+// latest record for a given account name wins
+// acc, ${timeUnix}, ${name}, ${email}, ${passwordHash}, ${verified}
+func (s *UserStore) decodeAccountRecord(rec []string) error {
+	if len(rec) != 6 {
+		return fmt.Errorf("'acc' record should have 6 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	acc := &Account{
+		Name:         rec[2],
+		Email:        rec[3],
+		PasswordHash: rec[4],
+		Verified:     rec[5] == "1",
+		Created:      time.Unix(timeSecs, 0),
+	}
+	s.setAccount(acc)
+	return nil
+}
+
+func (s *UserStore) writeAccount(acc *Account) error {
+	verified := "0"
+	if acc.Verified {
+		verified = "1"
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	rec := []string{recIDAccount, timeStr, acc.Name, acc.Email, acc.PasswordHash, verified}
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.setAccount(acc)
+	return nil
+}
+
+// CreateAccount creates a new, unverified account. Both name and email
+// must be unique
+func (s *UserStore) CreateAccount(name, email, passwordHash string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.accounts[name] != nil {
+		return fmt.Errorf("account %q already exists", name)
+	}
+	if s.accountsByEmail[normalizeEmail(email)] != nil {
+		return fmt.Errorf("account with email %q already exists", email)
+	}
+	acc := &Account{
+		Name:         name,
+		Email:        strings.TrimSpace(email),
+		PasswordHash: passwordHash,
+		Created:      time.Now(),
+	}
+	return s.writeAccount(acc)
+}
+
+// GetAccount returns a copy of account with a given name or nil
+func (s *UserStore) GetAccount(name string) *Account {
+	s.Lock()
+	defer s.Unlock()
+	if acc := s.accounts[name]; acc != nil {
+		res := *acc
+		return &res
+	}
+	return nil
+}
+
+// AccountByEmail returns a copy of account with a given email or nil.
+// Emails are compared case-insensitively
+func (s *UserStore) AccountByEmail(email string) *Account {
+	s.Lock()
+	defer s.Unlock()
+	if acc := s.accountsByEmail[normalizeEmail(email)]; acc != nil {
+		res := *acc
+		return &res
+	}
+	return nil
+}
+
+// SetAccountPassword changes password of an account
+func (s *UserStore) SetAccountPassword(name, passwordHash string) error {
+	s.Lock()
+	defer s.Unlock()
+	acc := s.accounts[name]
+	if acc == nil {
+		return fmt.Errorf("account %q doesn't exist", name)
+	}
+	res := *acc
+	res.PasswordHash = passwordHash
+	return s.writeAccount(&res)
+}
+
+// SetAccountVerified marks account's email as verified
+func (s *UserStore) SetAccountVerified(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	acc := s.accounts[name]
+	if acc == nil {
+		return fmt.Errorf("account %q doesn't exist", name)
+	}
+	if acc.Verified {
+		return nil
+	}
+	res := *acc
+	res.Verified = true
+	return s.writeAccount(&res)
+}
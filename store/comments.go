@@ -0,0 +1,186 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CommentRec represents a comment record. langID is -1 for comments
+// that are not about a specific language
+type CommentRec struct {
+	langID   int
+	userID   int
+	stringID int
+	text     string
+	time     time.Time
+}
+
+// Comment describes a single comment in a discussion about a string
+type Comment struct {
+	// empty if the comment is about all languages
+	Lang   string
+	User   string
+	String string
+	Text   string
+	Time   time.Time
+}
+
+func (s *StoreCsv) addCommentRec(strID, langID, userID int, text string, time time.Time) {
+	c := CommentRec{
+		langID:   langID,
+		userID:   userID,
+		stringID: strID,
+		text:     text,
+		time:     time,
+	}
+	s.comments = append(s.comments, c)
+}
+
+// c, ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${text}
+func (s *StoreCsv) decodeCommentRecord(rec []string) error {
+	if len(rec) != 6 {
+		return fmt.Errorf("'c' record should have 6 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	userID, _ := s.users.Intern(rec[2])
+	langID := -1
+	if rec[3] != "" {
+		langID = LangToId(rec[3])
+		fatalIf(langID < 0, "invalid rec: %#v", rec)
+	}
+	strID, err := strconv.Atoi(rec[4])
+	if err != nil {
+		return fmt.Errorf("rec[4] (%q) failed to parse as int, error: %q", rec[4], err)
+	}
+	if _, ok := s.strings.GetById(strID); !ok {
+		return fmt.Errorf("rec[4] (%q, '%d') is not a valid string id", rec[4], strID)
+	}
+	s.addCommentRec(strID, langID, userID, rec[5], time.Unix(timeSecs, 0))
+	return nil
+}
+
+func (s *StoreCsv) commentFromRec(c *CommentRec) Comment {
+	res := Comment{
+		User:   s.userByID(c.userID),
+		String: s.stringByIDMust(c.stringID),
+		Text:   c.text,
+		Time:   c.time,
+	}
+	if c.langID != -1 {
+		res.Lang = s.langByID(c.langID)
+	}
+	return res
+}
+
+// c, ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${text}
+func (s *StoreCsv) writeComment(str, lang, user, text string) error {
+	strID, ok := s.strings.strToId[str]
+	if !ok {
+		return fmt.Errorf("string %q doesn't exist", str)
+	}
+	langID := -1
+	if lang != "" {
+		langID = LangToId(lang)
+		if langID == -1 {
+			return fmt.Errorf("invalid lang %q", lang)
+		}
+	}
+	userID, _ := s.users.Intern(user)
+	t := time.Now()
+	timeSecsStr := strconv.FormatInt(t.Unix(), 10)
+	rec := []string{recIDComment, timeSecsStr, user, lang, strconv.Itoa(strID), text}
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.addCommentRec(strID, langID, userID, text, t)
+	return nil
+}
+
+// comments about str, in chronological order. If lang is not empty, also
+// returns comments about all languages
+func (s *StoreCsv) commentsFor(str, lang string) []Comment {
+	res := make([]Comment, 0)
+	strID, ok := s.strings.strToId[str]
+	if !ok {
+		return res
+	}
+	langID := LangToId(lang)
+	for i := range s.comments {
+		c := &s.comments[i]
+		if c.stringID != strID {
+			continue
+		}
+		if c.langID == -1 || c.langID == langID {
+			res = append(res, s.commentFromRec(c))
+		}
+	}
+	return res
+}
+
+func (s *StoreCsv) commentCounts(lang string) map[string]int {
+	res := make(map[string]int)
+	langID := LangToId(lang)
+	for i := range s.comments {
+		c := &s.comments[i]
+		if c.langID == -1 || c.langID == langID {
+			str := s.stringByIDMust(c.stringID)
+			res[str]++
+		}
+	}
+	return res
+}
+
+func (s *StoreCsv) recentComments(max int) []Comment {
+	n := len(s.comments)
+	if max > n {
+		max = n
+	}
+	res := make([]Comment, max)
+	for i := 0; i < max; i++ {
+		res[i] = s.commentFromRec(&s.comments[n-i-1])
+	}
+	return res
+}
+
+// WriteComment adds a comment about a string. Empty lang means the comment
+// is about all languages
+func (s *StoreCsv) WriteComment(str, lang, user, text string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.writeComment(str, lang, user, text)
+}
+
+// CommentsFor returns comments about a string in a given language, including
+// comments about all languages
+func (s *StoreCsv) CommentsFor(str, lang string) []Comment {
+	s.Lock()
+	defer s.Unlock()
+	return s.commentsFor(str, lang)
+}
+
+// CommentCounts returns number of comments about each string that are
+// relevant to a given language
+func (s *StoreCsv) CommentCounts(lang string) map[string]int {
+	s.Lock()
+	defer s.Unlock()
+	return s.commentCounts(lang)
+}
+
+// RecentComments returns most recent comments, newest first
+func (s *StoreCsv) RecentComments(max int) []Comment {
+	s.Lock()
+	defer s.Unlock()
+	return s.recentComments(max)
+}
+
+// CommentsCount returns total number of comments
+func (s *StoreCsv) CommentsCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.comments)
+}
@@ -0,0 +1,43 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func (s *StoreCsv) ensureComments(str, lang string, exp ...string) {
+	comments := s.CommentsFor(str, lang)
+	fatalIf(len(comments) != len(exp), "len(CommentsFor(%q, %q))=%d, exp: %d", str, lang, len(comments), len(exp))
+	for i, c := range comments {
+		fatalIf(c.Text != exp[i], "comment %d is %q, exp: %q", i, c.Text, exp[i])
+	}
+}
+
+func TestComments(t *testing.T) {
+	path := "commentstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	s.updateStringsListMust([]string{"foo", "bar"})
+	fatalIfErr(s.WriteComment("foo", "", "dev", "foo is a verb"))
+	fatalIfErr(s.WriteComment("foo", "pl", "user1", "what does foo mean?"))
+	fatalIfErr(s.WriteComment("foo", "de", "user2", "foo in German"))
+	if err := s.WriteComment("baz", "", "user1", "no such string"); err == nil {
+		t.Fatalf("expected an error commenting on a non-existent string")
+	}
+	s.ensureComments("foo", "pl", "foo is a verb", "what does foo mean?")
+	s.ensureComments("foo", "", "foo is a verb")
+	s.ensureComments("bar", "pl")
+	s.Close()
+
+	s = NewTestStore(path)
+	s.ensureComments("foo", "de", "foo is a verb", "foo in German")
+	counts := s.CommentCounts("pl")
+	fatalIf(counts["foo"] != 2 || counts["bar"] != 0, "unexpected counts: %#v", counts)
+	recent := s.RecentComments(2)
+	fatalIf(len(recent) != 2 || recent[0].Text != "foo in German" || recent[0].Lang != "de", "unexpected recent: %#v", recent)
+	fatalIf(recent[1].User != "user1", "unexpected recent: %#v", recent)
+	s.Close()
+}
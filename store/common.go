@@ -35,9 +35,10 @@ func (t *Translation) Current() string {
 	return t.Translations[n-1]
 }
 
-// IsTranslated returns true if the phrase is translated
+// IsTranslated returns true if the phrase is translated. Empty translation
+// (e.g. after reverting the first translation) means it's not translated
 func (t *Translation) IsTranslated() bool {
-	return len(t.Translations) > 0
+	return t.Current() != ""
 }
 
 // History returns list of past translations
@@ -0,0 +1,172 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import "time"
+
+// ProgressPoint is translation progress of a language at the end of a day
+type ProgressPoint struct {
+	// midnight (UTC) of the day
+	Day time.Time
+	// number of active strings
+	Strings    int
+	Translated int
+}
+
+// Untranslated returns number of active strings without a translation
+func (p *ProgressPoint) Untranslated() int {
+	return p.Strings - p.Translated
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sameHiddenUsers(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for user := range a {
+		if !b[user] {
+			return false
+		}
+	}
+	return true
+}
+
+// progressHistory replays revisions and returns progress of each language
+// (indexed by lang id) for every day since the first revision until now
+func (s *StoreCsv) progressHistory(now time.Time) [][]ProgressPoint {
+	nLangs := LangsCount()
+	res := make([][]ProgressPoint, nLangs)
+	if len(s.revisions) == 0 {
+		return res
+	}
+	// translated[langID][strID] is true if the string has a translation
+	translated := make([]map[int]bool, nLangs)
+	// number of translated active strings
+	counts := make([]int, nLangs)
+	var activeStrings []int
+	active := make(map[int]bool)
+	var hidden map[string]bool
+	nEdits := 0
+
+	recount := func() {
+		for langID := range counts {
+			n := 0
+			for _, id := range activeStrings {
+				if translated[langID][id] {
+					n++
+				}
+			}
+			counts[langID] = n
+		}
+	}
+	reset := func() {
+		for langID := range translated {
+			translated[langID] = make(map[int]bool)
+		}
+		nEdits = 0
+	}
+	reset()
+
+	apply := func(r *revision) {
+		needsRecount := false
+		if !sameHiddenUsers(hidden, r.hiddenUsers) {
+			// hiding users changes past edits, so start over
+			hidden = r.hiddenUsers
+			reset()
+			needsRecount = true
+		}
+		if !sameActiveStrings(activeStrings, r.activeStrings) {
+			activeStrings = r.activeStrings
+			active = make(map[int]bool)
+			for _, id := range activeStrings {
+				active[id] = true
+			}
+			needsRecount = true
+		}
+		for ; nEdits < r.edits; nEdits++ {
+			tr := &s.edits[nEdits]
+			if hidden[s.userByID(tr.userID)] {
+				continue
+			}
+			was := translated[tr.langID][tr.stringID]
+			is := tr.translation != ""
+			translated[tr.langID][tr.stringID] = is
+			if !needsRecount && active[tr.stringID] && was != is {
+				if is {
+					counts[tr.langID]++
+				} else {
+					counts[tr.langID]--
+				}
+			}
+		}
+		if needsRecount {
+			recount()
+		}
+	}
+
+	last := startOfDay(now)
+	i := 0
+	for day := startOfDay(s.revisions[0].time); !day.After(last); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		for ; i < len(s.revisions) && s.revisions[i].time.Before(next); i++ {
+			apply(&s.revisions[i])
+		}
+		for langID := range res {
+			res[langID] = append(res[langID], ProgressPoint{
+				Day:        day,
+				Strings:    len(activeStrings),
+				Translated: counts[langID],
+			})
+		}
+	}
+	return res
+}
+
+// progressCache is a result of progressHistory. It stays valid until the
+// next revision or the next day
+type progressCache struct {
+	rev     int
+	day     time.Time
+	history [][]ProgressPoint
+}
+
+// cachedProgressHistory is progressHistory that only replays revisions if
+// something changed since the last call. The result must not be modified
+func (s *StoreCsv) cachedProgressHistory(now time.Time) [][]ProgressPoint {
+	rev, day := s.revision(), startOfDay(now)
+	if c := s.progress; c != nil && c.rev == rev && c.day.Equal(day) {
+		return c.history
+	}
+	history := s.progressHistory(now)
+	s.progress = &progressCache{rev: rev, day: day, history: history}
+	return history
+}
+
+// ProgressHistory returns daily progress of a language, from the day of
+// the first change in the store until now, oldest first. The result must not
+// be modified
+func (s *StoreCsv) ProgressHistory(lang string, now time.Time) []ProgressPoint {
+	langID := LangToId(lang)
+	if langID == -1 {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.cachedProgressHistory(now)[langID]
+}
+
+// ProgressHistoryAll is ProgressHistory of all languages, keyed by language
+// code. The history is replayed once for all languages and only when it
+// changed, so it's cheap to call on every page view
+func (s *StoreCsv) ProgressHistoryAll(now time.Time) map[string][]ProgressPoint {
+	s.Lock()
+	defer s.Unlock()
+	res := make(map[string][]ProgressPoint)
+	for langID, points := range s.cachedProgressHistory(now) {
+		res[s.langByID(langID)] = points
+	}
+	return res
+}
@@ -0,0 +1,69 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProgressHistory(t *testing.T) {
+	path := "progresstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	day1 := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(days int) string {
+		return strconv.FormatInt(day1.AddDate(0, 0, days).Unix(), 10)
+	}
+	f, err := os.Create(path)
+	fatalIfErr(err)
+	w := csv.NewWriter(f)
+	w.WriteAll([][]string{
+		{"s", "0", "foo"},
+		{"s", "1", "bar"},
+		{"s", "2", "baz"},
+		{"as", at(0), "0-1"},
+		{"t", at(0), "alice", "pl", "0", "foo-pl"},
+		// nothing happens on the second day
+		{"as", at(2), "0-2"},
+		{"t", at(2), "bob", "pl", "1", "bar-pl"},
+		{"t", at(2), "bob", "de", "2", "baz-de"},
+		{"hu", at(3), "bob"},
+		{"t", at(4), "alice", "pl", "0", ""},
+	})
+	fatalIfErr(w.Error())
+	f.Close()
+
+	s := NewTestStore(path)
+	defer s.Close()
+	now := day1.AddDate(0, 0, 4).Add(time.Hour)
+	check := func(lang string, exp string) {
+		got := ""
+		for i, p := range s.ProgressHistory(lang, now) {
+			exp := day1.AddDate(0, 0, i).Truncate(24 * time.Hour)
+			fatalIf(!p.Day.Equal(exp), "day %d is %s, exp: %s", i, p.Day, exp)
+			got += fmt.Sprintf("%d/%d ", p.Translated, p.Strings)
+		}
+		fatalIf(got != exp, "progress of %s is %q, exp: %q", lang, got, exp)
+	}
+	check("pl", "1/2 1/2 2/3 1/3 0/3 ")
+	check("de", "0/2 0/2 1/3 0/3 0/3 ")
+	fatalIf(s.ProgressHistory("xx", now) != nil, "progress of invalid language")
+
+	all := s.ProgressHistoryAll(now.AddDate(0, 0, 1))
+	pl := all["pl"]
+	fatalIf(len(all) != LangsCount() || len(pl) != 6, "unexpected ProgressHistoryAll()")
+	fatalIf(pl[5].Untranslated() != 3, "untranslated is %d, exp: 3", pl[5].Untranslated())
+
+	// history is only replayed when it changes
+	again := s.ProgressHistoryAll(now.AddDate(0, 0, 1))["pl"]
+	fatalIf(&again[0] != &pl[0], "unchanged history should be cached")
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-pl", "pl", "carol"))
+	points := s.ProgressHistory("pl", time.Now())
+	last := points[len(points)-1]
+	fatalIf(last.Translated != 1, "translated is %d after a new translation, exp: 1", last.Translated)
+}
@@ -0,0 +1,217 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ReviewRec records that a maintainer has approved a translation
+type ReviewRec struct {
+	userID      int
+	langID      int
+	stringID    int
+	translation string
+	time        time.Time
+}
+
+// Review describes the latest review of a translation
+type Review struct {
+	User string
+	// translation that was approved. If it's different than the current
+	// translation, the review is outdated
+	Translation string
+	Time        time.Time
+}
+
+func (s *StoreCsv) setReview(r *ReviewRec) {
+	m := s.reviews[r.langID]
+	if m == nil {
+		m = make(map[int]*ReviewRec)
+		s.reviews[r.langID] = m
+	}
+	m[r.stringID] = r
+	s.reviewsCount++
+}
+
+// rv, ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${translation}
+func (s *StoreCsv) decodeReviewRecord(rec []string) error {
+	if len(rec) != 6 {
+		return fmt.Errorf("'rv' record should have 6 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	userID, _ := s.users.Intern(rec[2])
+	langID := LangToId(rec[3])
+	if langID < 0 {
+		return fmt.Errorf("rec[3] (%q) is not a valid language", rec[3])
+	}
+	strID, err := strconv.Atoi(rec[4])
+	if err != nil {
+		return fmt.Errorf("rec[4] (%q) failed to parse as int, error: %q", rec[4], err)
+	}
+	if _, ok := s.strings.GetById(strID); !ok {
+		return fmt.Errorf("rec[4] (%q, '%d') is not a valid string id", rec[4], strID)
+	}
+	r := &ReviewRec{
+		userID:      userID,
+		langID:      langID,
+		stringID:    strID,
+		translation: rec[5],
+		time:        time.Unix(timeSecs, 0),
+	}
+	s.setReview(r)
+	return nil
+}
+
+func (s *StoreCsv) isHiddenUser(userID int) bool {
+	if len(s.hiddenUsers) == 0 {
+		return false
+	}
+	return s.hiddenUsers[s.userByID(userID)]
+}
+
+func (s *StoreCsv) isHiddenEdit(tr *TranslationRec) bool {
+	return s.isHiddenUser(tr.userID)
+}
+
+// visibleTranslations returns translations of a string, oldest first,
+// skipping those by hidden users
+func (s *StoreCsv) visibleTranslations(strID, langID int) []string {
+	res := make([]string, 0)
+	for i := range s.edits {
+		tr := &s.edits[i]
+		if tr.stringID == strID && tr.langID == langID && !s.isHiddenEdit(tr) {
+			res = append(res, tr.translation)
+		}
+	}
+	return res
+}
+
+func (s *StoreCsv) stringAndLangIDs(str, lang string) (int, int, error) {
+	strID, ok := s.strings.strToId[str]
+	if !ok {
+		return 0, 0, fmt.Errorf("string %q doesn't exist", str)
+	}
+	langID := LangToId(lang)
+	if langID < 0 {
+		return 0, 0, fmt.Errorf("invalid language %q", lang)
+	}
+	return strID, langID, nil
+}
+
+func (s *StoreCsv) currentTranslation(strID, langID int) string {
+	trans := s.visibleTranslations(strID, langID)
+	if len(trans) == 0 {
+		return ""
+	}
+	return trans[len(trans)-1]
+}
+
+func (s *StoreCsv) writeReview(str, lang, user string) error {
+	strID, langID, err := s.stringAndLangIDs(str, lang)
+	if err != nil {
+		return err
+	}
+	trans := s.currentTranslation(strID, langID)
+	if trans == "" {
+		return fmt.Errorf("string %q is not translated", str)
+	}
+	userID, _ := s.users.Intern(user)
+	t := time.Now()
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	rec := []string{recIDReview, timeStr, user, lang, strconv.Itoa(strID), trans}
+	if err = s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.setReview(&ReviewRec{
+		userID:      userID,
+		langID:      langID,
+		stringID:    strID,
+		translation: trans,
+		time:        t,
+	})
+	return nil
+}
+
+// revertTranslation undoes the latest change of a translation by writing
+// the translation that preceded it. If there was none, the string becomes
+// untranslated
+func (s *StoreCsv) revertTranslation(str, lang, user string) (string, error) {
+	strID, langID, err := s.stringAndLangIDs(str, lang)
+	if err != nil {
+		return "", err
+	}
+	trans := s.visibleTranslations(strID, langID)
+	n := len(trans)
+	if n == 0 || trans[n-1] == "" {
+		return "", fmt.Errorf("string %q is not translated", str)
+	}
+	cur := trans[n-1]
+	prev := ""
+	for i := n - 2; i >= 0; i-- {
+		if trans[i] != cur {
+			prev = trans[i]
+			break
+		}
+	}
+	if err = s.writeNewTranslation(str, prev, lang, user); err != nil {
+		return "", err
+	}
+	return prev, nil
+}
+
+// WriteReview records that user approved the current translation of str
+func (s *StoreCsv) WriteReview(str, lang, user string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.writeReview(str, lang, user)
+}
+
+// RevertTranslation undoes the latest change of a translation and returns
+// the restored translation ("" if the string is now untranslated)
+func (s *StoreCsv) RevertTranslation(str, lang, user string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.revertTranslation(str, lang, user)
+}
+
+// Reviews returns the latest reviews of translations in a given language,
+// keyed by source string
+func (s *StoreCsv) Reviews(lang string) map[string]*Review {
+	s.Lock()
+	defer s.Unlock()
+	res := make(map[string]*Review)
+	langID := LangToId(lang)
+	if langID < 0 {
+		return res
+	}
+	for strID, r := range s.reviews[langID] {
+		res[s.stringByIDMust(strID)] = &Review{
+			User:        s.userByID(r.userID),
+			Translation: r.translation,
+			Time:        r.time,
+		}
+	}
+	return res
+}
+
+// ReviewsCount returns number of reviews ever made. It changes with every
+// review, so it can be used to tell if reviews changed
+func (s *StoreCsv) ReviewsCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.reviewsCount
+}
+
+// SetHiddenUsers sets users whose edits are not shown. Translations by
+// them are ignored as if they never happened. Changes are recorded because
+// they change translations (see revisions.go)
+func (s *StoreCsv) SetHiddenUsers(users []string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.setHiddenUsers(users)
+}
@@ -0,0 +1,62 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func (s *StoreCsv) ensureCurrent(str, lang, exp string) {
+	strID, langID, err := s.stringAndLangIDs(str, lang)
+	fatalIfErr(err)
+	got := s.currentTranslation(strID, langID)
+	fatalIf(got != exp, "current translation of %q is %q, exp: %q", str, got, exp)
+}
+
+func TestReviewsAndReverts(t *testing.T) {
+	path := "reviewstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	s.updateStringsListMust([]string{"foo", "bar"})
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-1", "pl", "alice"))
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-2", "pl", "bob"))
+	fatalIfErr(s.WriteNewTranslation("bar", "bar-1", "pl", "bob"))
+	fatalIf(s.WriteReview("foo", "de", "carol") == nil, "reviewing untranslated string should fail")
+	fatalIfErr(s.WriteReview("foo", "pl", "carol"))
+
+	trans, err := s.RevertTranslation("foo", "pl", "carol")
+	fatalIfErr(err)
+	fatalIf(trans != "foo-1", "reverted to %q, exp: foo-1", trans)
+	trans, err = s.RevertTranslation("bar", "pl", "carol")
+	fatalIfErr(err)
+	fatalIf(trans != "", "reverted to %q, exp: ''", trans)
+	_, err = s.RevertTranslation("bar", "pl", "carol")
+	fatalIf(err == nil, "reverting untranslated string should fail")
+	s.Close()
+
+	s = NewTestStore(path)
+	defer s.Close()
+	s.ensureCurrent("foo", "pl", "foo-1")
+	s.ensureCurrent("bar", "pl", "")
+	trans, user := s.CurrentTranslation("foo", "pl")
+	fatalIf(trans != "foo-1" || user != "carol", "unexpected current translation %q by %q", trans, user)
+	fatalIf(s.UntranslatedForLang("pl") != 1, "UntranslatedForLang('pl') = %d, exp: 1", s.UntranslatedForLang("pl"))
+	reviews := s.Reviews("pl")
+	r := reviews["foo"]
+	fatalIf(r == nil || r.User != "carol" || r.Translation != "foo-2", "unexpected review %#v", r)
+
+	// hiding bob's edits makes them disappear
+	fatalIfErr(s.WriteNewTranslation("bar", "bar-2", "pl", "bob"))
+	s.ensureCurrent("bar", "pl", "bar-2")
+	s.SetHiddenUsers([]string{"bob"})
+	s.ensureCurrent("bar", "pl", "")
+	s.ensureCurrent("foo", "pl", "foo-1")
+	fatalIf(len(s.EditsByUser("bob")) != 0, "edits by hidden user should be hidden")
+	for _, tr := range s.Translators() {
+		fatalIf(tr.Name == "bob", "hidden user shouldn't be listed as translator")
+	}
+	s.SetHiddenUsers(nil)
+	s.ensureCurrent("bar", "pl", "bar-2")
+}
@@ -0,0 +1,277 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Revision of an app's translations is the number of records that changed
+// what /dltrans returns: translations (t), active sets (as) and sets of
+// hidden users (hu). It only grows and because it's derived from the log,
+// it's the same after a restart.
+
+// revision is the state after a record that changed translations
+type revision struct {
+	// time of the record, used as Last-Modified of downloads
+	time time.Time
+	// number of edits
+	edits int
+	// these are never modified, only replaced, so we can share them
+	activeStrings []int
+	hiddenUsers   map[string]bool
+}
+
+// TranslationChange is a change of a translation between two revisions.
+// Translation is "" if the string is no longer translated or no longer used
+type TranslationChange struct {
+	String      string
+	Lang        string
+	Translation string
+}
+
+// AddedStrings describes strings that became active in a given revision,
+// usually because they were uploaded
+type AddedStrings struct {
+	Revision int
+	Time     time.Time
+	Strings  []string
+}
+
+type transKey struct {
+	strID  int
+	langID int
+}
+
+// must be called after a change of edits, active strings or hidden users
+func (s *StoreCsv) addRevision(t time.Time) {
+	if n := len(s.revisions); n > 0 && t.Before(s.revisions[n-1].time) {
+		// clocks can go backwards but Last-Modified shouldn't
+		t = s.revisions[n-1].time
+	}
+	s.revisions = append(s.revisions, revision{
+		time:          t,
+		edits:         len(s.edits),
+		activeStrings: s.activeStrings,
+		hiddenUsers:   s.hiddenUsers,
+	})
+}
+
+func (s *StoreCsv) revision() int {
+	return len(s.revisions)
+}
+
+// recordTime returns time of a record or zero time if it's not valid
+func recordTime(rec []string) time.Time {
+	secs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+// transSnapshot is a result of translationsAt. Revisions don't change, so
+// it stays valid forever
+type transSnapshot struct {
+	rev   int
+	trans map[transKey]string
+}
+
+// how many results of translationsAt we remember. /dltransdelta is mostly
+// called with the current revision or one of the recent ones
+const maxTransSnapshots = 4
+
+// translationsAt is calcTranslationsAt that only replays edits if rev is not
+// one of recently used revisions. The result must not be modified
+func (s *StoreCsv) translationsAt(rev int) map[transKey]string {
+	for i, snap := range s.transSnapshots {
+		if snap.rev == rev {
+			// move to front, so that the least recently used is evicted
+			copy(s.transSnapshots[1:i+1], s.transSnapshots[:i])
+			s.transSnapshots[0] = snap
+			return snap.trans
+		}
+	}
+	trans := s.calcTranslationsAt(rev)
+	s.transSnapshots = append([]*transSnapshot{{rev: rev, trans: trans}}, s.transSnapshots...)
+	if len(s.transSnapshots) > maxTransSnapshots {
+		s.transSnapshots = s.transSnapshots[:maxTransSnapshots]
+	}
+	return trans
+}
+
+// calcTranslationsAt returns translations of active strings at revision rev
+func (s *StoreCsv) calcTranslationsAt(rev int) map[transKey]string {
+	res := make(map[transKey]string)
+	if rev == 0 {
+		return res
+	}
+	r := &s.revisions[rev-1]
+	for i := 0; i < r.edits; i++ {
+		tr := &s.edits[i]
+		if r.hiddenUsers[s.userByID(tr.userID)] {
+			continue
+		}
+		res[transKey{tr.stringID, tr.langID}] = tr.translation
+	}
+	active := make(map[int]bool)
+	for _, id := range r.activeStrings {
+		active[id] = true
+	}
+	for k, trans := range res {
+		if trans == "" || !active[k.strID] {
+			delete(res, k)
+		}
+	}
+	return res
+}
+
+func (s *StoreCsv) translationsDelta(since int) ([]*TranslationChange, error) {
+	cur := s.revision()
+	if since < 0 || since > cur {
+		return nil, fmt.Errorf("invalid revision %d, current is %d", since, cur)
+	}
+	res := make([]*TranslationChange, 0)
+	if since == cur {
+		return res, nil
+	}
+	prev, now := s.translationsAt(since), s.translationsAt(cur)
+	add := func(k transKey, trans string) {
+		res = append(res, &TranslationChange{
+			String:      s.stringByIDMust(k.strID),
+			Lang:        s.langByID(k.langID),
+			Translation: trans,
+		})
+	}
+	for k, trans := range now {
+		if prev[k] != trans {
+			add(k, trans)
+		}
+	}
+	for k := range prev {
+		if _, ok := now[k]; !ok {
+			add(k, "")
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].String != res[j].String {
+			return res[i].String < res[j].String
+		}
+		return res[i].Lang < res[j].Lang
+	})
+	return res, nil
+}
+
+// active strings are replaced, not modified, so unchanged ones share memory
+func sameActiveStrings(a, b []int) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func (s *StoreCsv) recentlyAddedStrings(max int) []*AddedStrings {
+	res := make([]*AddedStrings, 0)
+	for i := len(s.revisions) - 1; i >= 0 && len(res) < max; i-- {
+		r := &s.revisions[i]
+		var prev []int
+		if i > 0 {
+			prev = s.revisions[i-1].activeStrings
+			if sameActiveStrings(prev, r.activeStrings) {
+				continue
+			}
+		}
+		wasActive := make(map[int]bool)
+		for _, id := range prev {
+			wasActive[id] = true
+		}
+		added := &AddedStrings{Revision: i + 1, Time: r.time}
+		for _, id := range r.activeStrings {
+			if !wasActive[id] {
+				added.Strings = append(added.Strings, s.stringByIDMust(id))
+			}
+		}
+		if len(added.Strings) > 0 {
+			sort.Strings(added.Strings)
+			res = append(res, added)
+		}
+	}
+	return res
+}
+
+// hu, ${timeUnix}, ${user}, ...
+func (s *StoreCsv) decodeHiddenUsersRecord(rec []string) error {
+	if len(rec) < 2 {
+		return fmt.Errorf("'hu' record should have at least 2 fields, is '%#v'", rec)
+	}
+	hidden := make(map[string]bool)
+	for _, user := range rec[2:] {
+		hidden[user] = true
+	}
+	s.hiddenUsers = hidden
+	s.addRevision(recordTime(rec))
+	return nil
+}
+
+func (s *StoreCsv) setHiddenUsers(users []string) error {
+	hidden := make(map[string]bool)
+	for _, user := range users {
+		hidden[user] = true
+	}
+	if len(hidden) == len(s.hiddenUsers) {
+		same := true
+		for user := range hidden {
+			same = same && s.hiddenUsers[user]
+		}
+		if same {
+			return nil
+		}
+	}
+	sorted := make([]string, 0, len(hidden))
+	for user := range hidden {
+		sorted = append(sorted, user)
+	}
+	sort.Strings(sorted)
+	now := time.Now()
+	rec := append([]string{recIDHiddenUsers, strconv.FormatInt(now.Unix(), 10)}, sorted...)
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.hiddenUsers = hidden
+	s.addRevision(now)
+	return nil
+}
+
+// Revision returns the current revision of translations
+func (s *StoreCsv) Revision() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.revision()
+}
+
+// RevisionTime returns the current revision of translations and the time
+// it was created. The time is zero if there are no revisions
+func (s *StoreCsv) RevisionTime() (int, time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.revisions) == 0 {
+		return 0, time.Time{}
+	}
+	return s.revision(), s.revisions[len(s.revisions)-1].time
+}
+
+// RecentlyAddedStrings returns up to max most recent changes of active
+// strings that added new strings, newest first
+func (s *StoreCsv) RecentlyAddedStrings(max int) []*AddedStrings {
+	s.Lock()
+	defer s.Unlock()
+	return s.recentlyAddedStrings(max)
+}
+
+// TranslationsDelta returns translations that changed since revision since,
+// sorted by string and language, and the current revision
+func (s *StoreCsv) TranslationsDelta(since int) ([]*TranslationChange, int, error) {
+	s.Lock()
+	defer s.Unlock()
+	res, err := s.translationsDelta(since)
+	return res, s.revision(), err
+}
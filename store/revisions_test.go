@@ -0,0 +1,115 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (s *StoreCsv) ensureDelta(since int, exp string) {
+	changes, _, err := s.TranslationsDelta(since)
+	fatalIfErr(err)
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String+":"+c.Lang+":"+c.Translation)
+	}
+	fatalIf(strings.Join(got, " ") != exp, "delta since %d is %q, exp: %q", since, strings.Join(got, " "), exp)
+}
+
+func TestTranslationsDelta(t *testing.T) {
+	path := "revisionstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	fatalIf(s.Revision() != 0, "revision of a new store is %d", s.Revision())
+	s.updateStringsListMust([]string{"foo", "bar", "baz"})
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-1", "pl", "alice"))
+	fatalIfErr(s.WriteNewTranslation("bar", "bar-1", "de", "bob"))
+	rev := s.Revision()
+	fatalIf(rev != 3, "revision is %d, exp: 3", rev)
+	s.ensureDelta(0, "bar:de:bar-1 foo:pl:foo-1")
+	s.ensureDelta(rev, "")
+
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-2", "pl", "alice"))
+	fatalIfErr(s.WriteNewTranslation("baz", "baz-1", "pl", "alice"))
+	_, err := s.RevertTranslation("baz", "pl", "carol")
+	fatalIfErr(err)
+	// baz was added and removed, so it's not in the delta
+	s.ensureDelta(rev, "foo:pl:foo-2")
+
+	// strings that are no longer used are removed
+	rev = s.Revision()
+	s.updateStringsListMust([]string{"foo", "baz"})
+	s.ensureDelta(rev, "bar:de:")
+	s.updateStringsListMust([]string{"foo", "bar"})
+	s.ensureDelta(rev, "")
+
+	// so are translations by hidden users
+	rev = s.Revision()
+	fatalIfErr(s.SetHiddenUsers([]string{"bob"}))
+	s.ensureDelta(rev, "bar:de:")
+	fatalIfErr(s.SetHiddenUsers([]string{"bob"}))
+	fatalIf(s.Revision() != rev+1, "setting the same hidden users shouldn't change revision")
+	_, _, err = s.TranslationsDelta(rev + 2)
+	fatalIf(err == nil, "delta from the future should fail")
+	rev = s.Revision()
+	s.Close()
+
+	// revisions are the same after re-opening
+	s = NewTestStore(path)
+	defer s.Close()
+	fatalIf(s.Revision() != rev, "revision after re-opening is %d, exp: %d", s.Revision(), rev)
+	rev2, modTime := s.RevisionTime()
+	fatalIf(rev2 != rev || modTime.IsZero() || time.Since(modTime) > time.Minute, "unexpected revision time %d %s", rev2, modTime)
+	fatalIfErr(s.SetHiddenUsers([]string{"bob"}))
+	fatalIf(s.Revision() != rev, "hidden users should be restored")
+	s.ensureDelta(3, "bar:de: foo:pl:foo-2")
+}
+
+func TestTranslationsAtCache(t *testing.T) {
+	path := "transatcachetest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	defer s.Close()
+	s.updateStringsListMust([]string{"foo", "bar"})
+	for i := 0; i < maxTransSnapshots+2; i++ {
+		fatalIfErr(s.WriteNewTranslation("foo", strings.Repeat("f", i+1), "pl", "alice"))
+	}
+	fatalIfErr(s.SetHiddenUsers([]string{"alice"}))
+	for rev := 0; rev <= s.Revision(); rev++ {
+		got := s.translationsAt(rev)
+		fatalIf(!reflect.DeepEqual(got, s.calcTranslationsAt(rev)), "cached translations at %d are %v", rev, got)
+	}
+	fatalIf(len(s.transSnapshots) != maxTransSnapshots, "expected %d snapshots, got %d", maxTransSnapshots, len(s.transSnapshots))
+	// the most recently used revision is not replayed again
+	cur := s.translationsAt(s.Revision())
+	s.translationsAt(0)
+	cur[transKey{-1, -1}] = "marker"
+	fatalIf(s.translationsAt(s.Revision())[transKey{-1, -1}] != "marker", "current revision should be cached")
+	delete(cur, transKey{-1, -1})
+	fatalIf(s.transSnapshots[0].rev != s.Revision(), "cache hit should move snapshot to front")
+}
+
+func TestRecentlyAddedStrings(t *testing.T) {
+	path := "addedstringstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	defer s.Close()
+	s.updateStringsListMust([]string{"foo", "bar"})
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-1", "pl", "alice"))
+	s.updateStringsListMust([]string{"foo"})
+	s.updateStringsListMust([]string{"foo", "bar", "baz"})
+	added := s.RecentlyAddedStrings(10)
+	fatalIf(len(added) != 2, "unexpected %#v", added)
+	fatalIf(added[0].Revision != 4 || strings.Join(added[0].Strings, ",") != "bar,baz", "unexpected %#v", added[0])
+	fatalIf(added[1].Revision != 1 || strings.Join(added[1].Strings, ",") != "bar,foo", "unexpected %#v", added[1])
+	fatalIf(len(s.RecentlyAddedStrings(1)) != 1, "max is not respected")
+}
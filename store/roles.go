@@ -0,0 +1,166 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// roles that can be granted to users. Users without a role are translators
+const (
+	// can do everything, scope is ""
+	RoleAdmin = "admin"
+	// can manage an app, scope is app name
+	RoleAppAdmin = "appadmin"
+	// can review and revert translations, scope is "${appName}/${langCode}"
+	RoleMaintainer = "maintainer"
+	// can't edit translations, scope is ""
+	RoleBanned = "banned"
+	// edits are hidden, scope is ""
+	RoleHidden = "hidden"
+)
+
+// IsValidRole returns true if role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleAppAdmin, RoleMaintainer, RoleBanned, RoleHidden:
+		return true
+	}
+	return false
+}
+
+// Role describes a role granted to a user
+type Role struct {
+	User  string
+	Role  string
+	Scope string
+	// who granted the role
+	GrantedBy string
+	Time      time.Time
+}
+
+type roleKey struct {
+	user  string
+	role  string
+	scope string
+}
+
+func (s *UserStore) setRole(r *Role, granted bool) {
+	key := roleKey{r.User, r.Role, r.Scope}
+	if granted {
+		s.roles[key] = r
+	} else {
+		delete(s.roles, key)
+	}
+}
+
+// role, ${timeUnix}, ${grantedBy}, ${user}, ${role}, ${scope}, ${granted}
+func (s *UserStore) decodeRoleRecord(rec []string) error {
+	if len(rec) != 7 {
+		return fmt.Errorf("'role' record should have 7 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	if !IsValidRole(rec[4]) {
+		return fmt.Errorf("rec[4] (%q) is not a valid role", rec[4])
+	}
+	r := &Role{
+		User:      rec[3],
+		Role:      rec[4],
+		Scope:     rec[5],
+		GrantedBy: rec[2],
+		Time:      time.Unix(timeSecs, 0),
+	}
+	s.setRole(r, rec[6] == "1")
+	return nil
+}
+
+func (s *UserStore) writeRole(by, user, role, scope string, granted bool) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("%q is not a valid role", role)
+	}
+	if user == "" {
+		return fmt.Errorf("empty user")
+	}
+	_, exists := s.roles[roleKey{user, role, scope}]
+	if exists == granted {
+		return nil
+	}
+	t := time.Now()
+	grantedStr := "0"
+	if granted {
+		grantedStr = "1"
+	}
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	rec := []string{recIDRole, timeStr, by, user, role, scope, grantedStr}
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	r := &Role{User: user, Role: role, Scope: scope, GrantedBy: by, Time: t}
+	s.setRole(r, granted)
+	return nil
+}
+
+// GrantRole grants a role to user
+func (s *UserStore) GrantRole(by, user, role, scope string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.writeRole(by, user, role, scope, true)
+}
+
+// RevokeRole revokes a role from user
+func (s *UserStore) RevokeRole(by, user, role, scope string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.writeRole(by, user, role, scope, false)
+}
+
+// HasRole returns true if user has a role with a given scope
+func (s *UserStore) HasRole(user, role, scope string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.roles[roleKey{user, role, scope}]
+	return ok
+}
+
+// Roles returns all granted roles, sorted by role, scope and user
+func (s *UserStore) Roles() []*Role {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]*Role, 0, len(s.roles))
+	for _, r := range s.roles {
+		rc := *r
+		res = append(res, &rc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		r1, r2 := res[i], res[j]
+		if r1.Role != r2.Role {
+			return r1.Role < r2.Role
+		}
+		if r1.Scope != r2.Scope {
+			return r1.Scope < r2.Scope
+		}
+		return r1.User < r2.User
+	})
+	return res
+}
+
+// UsersWithRole returns users that have a given role with any scope
+func (s *UserStore) UsersWithRole(role string) []string {
+	s.Lock()
+	defer s.Unlock()
+	seen := make(map[string]bool)
+	res := make([]string, 0)
+	for key := range s.roles {
+		if key.role == role && !seen[key.user] {
+			seen[key.user] = true
+			res = append(res, key.user)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,105 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ss, ${timeUnix}, ${fileName}, ${strId}, ...
+func (s *StoreCsv) decodeScreenshotRecord(rec []string) error {
+	if len(rec) < 3 {
+		return fmt.Errorf("'ss' record should have at least 3 fields, is '%#v'", rec)
+	}
+	n := len(rec) - 3
+	strIDs := make([]int, n, n)
+	for i := 0; i < n; i++ {
+		strID, err := strconv.Atoi(rec[3+i])
+		if err != nil {
+			return fmt.Errorf("rec[%d] (%q) failed to parse as int, error: %q", 3+i, rec[3+i], err)
+		}
+		if _, ok := s.strings.GetById(strID); !ok {
+			return fmt.Errorf("rec[%d] (%q, '%d') is not a valid string id", 3+i, rec[3+i], strID)
+		}
+		strIDs[i] = strID
+	}
+	s.setScreenshotStrings(rec[2], strIDs)
+	return nil
+}
+
+func (s *StoreCsv) setScreenshotStrings(fileName string, strIDs []int) {
+	if len(strIDs) == 0 {
+		delete(s.screenshots, fileName)
+		return
+	}
+	s.screenshots[fileName] = strIDs
+}
+
+func (s *StoreCsv) writeScreenshot(fileName string, strs []string) error {
+	strIDs := make([]int, 0)
+	for _, str := range strs {
+		strID, ok := s.strings.strToId[str]
+		if !ok {
+			return fmt.Errorf("string %q doesn't exist", str)
+		}
+		strIDs = append(strIDs, strID)
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	rec := []string{recIDScreenshot, timeStr, fileName}
+	for _, strID := range strIDs {
+		rec = append(rec, strconv.Itoa(strID))
+	}
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.setScreenshotStrings(fileName, strIDs)
+	return nil
+}
+
+func (s *StoreCsv) screenshotsByString() map[string][]string {
+	res := make(map[string][]string)
+	for fileName, strIDs := range s.screenshots {
+		for _, strID := range strIDs {
+			str := s.stringByIDMust(strID)
+			res[str] = append(res[str], fileName)
+		}
+	}
+	for _, files := range res {
+		sort.Strings(files)
+	}
+	return res
+}
+
+// WriteScreenshot records that a screenshot stored in fileName shows
+// strs. Replaces strings previously recorded for that file. Empty strs
+// removes the screenshot
+func (s *StoreCsv) WriteScreenshot(fileName string, strs []string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.writeScreenshot(fileName, strs)
+}
+
+// StringID returns id of a string
+func (s *StoreCsv) StringID(str string) (int, bool) {
+	s.Lock()
+	defer s.Unlock()
+	id, ok := s.strings.strToId[str]
+	return id, ok
+}
+
+// StringByID returns a string with a given id
+func (s *StoreCsv) StringByID(id int) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	return s.strings.GetById(id)
+}
+
+// ScreenshotsByString returns a map of string to names of screenshot files
+// that show it
+func (s *StoreCsv) ScreenshotsByString() map[string][]string {
+	s.Lock()
+	defer s.Unlock()
+	return s.screenshotsByString()
+}
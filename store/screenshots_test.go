@@ -0,0 +1,31 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestScreenshots(t *testing.T) {
+	path := "screenshotstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	s.updateStringsListMust([]string{"foo", "bar", "baz"})
+	fatalIfErr(s.WriteScreenshot("1.png", []string{"foo", "bar"}))
+	fatalIfErr(s.WriteScreenshot("2.png", []string{"foo"}))
+	if err := s.WriteScreenshot("3.png", []string{"nope"}); err == nil {
+		t.Fatalf("expected an error for a non-existent string")
+	}
+	// uploading the same screenshot again replaces its strings
+	fatalIfErr(s.WriteScreenshot("1.png", []string{"bar", "baz"}))
+	s.Close()
+
+	s = NewTestStore(path)
+	defer s.Close()
+	m := s.ScreenshotsByString()
+	fatalIf(len(m["foo"]) != 1 || m["foo"][0] != "2.png", "unexpected screenshots for foo: %#v", m["foo"])
+	fatalIf(len(m["bar"]) != 1 || m["bar"][0] != "1.png", "unexpected screenshots for bar: %#v", m["bar"])
+	fatalIf(len(m["baz"]) != 1 || m["baz"][0] != "1.png", "unexpected screenshots for baz: %#v", m["baz"])
+}
@@ -0,0 +1,315 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchQuery describes what to look for with StoreCsv.Search
+type SearchQuery struct {
+	// text to look for in source strings, current translations and
+	// history. Empty matches everything
+	Text string
+	// if true, every word of Text must match a whole word. Otherwise
+	// Text is matched as a substring
+	WholeWord bool
+	// language code. Empty means all languages
+	Lang string
+	// only return strings that are not translated
+	Untranslated bool
+	// only return translations changed after that time
+	ChangedSince time.Time
+	// only return translations last edited by this user
+	User string
+	// also search strings that are no longer in the active set
+	IncludeUnused bool
+	// maximum number of results, 0 means no limit
+	Max int
+}
+
+// SearchResult describes a single match. Lang is empty when only the
+// source string matched and the query wasn't limited to a language
+type SearchResult struct {
+	StringID           int
+	String             string
+	Lang               string
+	Translation        string
+	User               string
+	Time               time.Time
+	IsUnused           bool
+	MatchedSource      bool
+	MatchedTranslation bool
+	MatchedHistory     bool
+}
+
+// indexedTrans is the state of translation of a single string in a single
+// language, with normalized text for matching
+type indexedTrans struct {
+	current     string
+	currentNorm string
+	historyNorm []string
+	userID      int
+	time        time.Time
+}
+
+// searchIndex is kept in sync with StoreCsv as strings and translations
+// are added, so that searching doesn't have to re-normalize every string
+type searchIndex struct {
+	stringsNorm []string
+	// indexed by langId, maps string id to its translation
+	trans []map[int]*indexedTrans
+}
+
+func newSearchIndex() *searchIndex {
+	idx := &searchIndex{
+		trans: make([]map[int]*indexedTrans, LangsCount()),
+	}
+	for i := range idx.trans {
+		idx.trans[i] = make(map[int]*indexedTrans)
+	}
+	return idx
+}
+
+func (idx *searchIndex) addString(strID int, str string) {
+	for len(idx.stringsNorm) <= strID {
+		idx.stringsNorm = append(idx.stringsNorm, "")
+	}
+	idx.stringsNorm[strID] = normalizeForSearch(str)
+}
+
+func (idx *searchIndex) addTranslation(strID, langID, userID int, trans string, t time.Time) {
+	m := idx.trans[langID]
+	it := m[strID]
+	if it == nil {
+		it = &indexedTrans{}
+		m[strID] = it
+	} else if it.current != "" {
+		it.historyNorm = append(it.historyNorm, it.currentNorm)
+	}
+	it.current = trans
+	it.currentNorm = normalizeForSearch(trans)
+	it.userID = userID
+	it.time = t
+}
+
+// latin letters with diacritics and the letters they fold to
+var diacriticsFold = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g", 'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i",
+	'ĵ': "j", 'ķ': "k", 'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'œ': "oe", 'ŕ': "r", 'ŗ': "r", 'ř': "r",
+	'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s", 'ș': "s", 'ß': "ss",
+	'ţ': "t", 'ť': "t", 'ŧ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w", 'ý': "y", 'ÿ': "y", 'ŷ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+	'ơ': "o", 'ư': "u", 'ạ': "a", 'ả': "a", 'ấ': "a", 'ầ': "a", 'ẩ': "a", 'ẫ': "a", 'ậ': "a",
+	'ắ': "a", 'ằ': "a", 'ẳ': "a", 'ẵ': "a", 'ặ': "a", 'ẹ': "e", 'ẻ': "e", 'ẽ': "e", 'ế': "e",
+	'ề': "e", 'ể': "e", 'ễ': "e", 'ệ': "e", 'ỉ': "i", 'ị': "i", 'ọ': "o", 'ỏ': "o", 'ố': "o",
+	'ồ': "o", 'ổ': "o", 'ỗ': "o", 'ộ': "o", 'ớ': "o", 'ờ': "o", 'ở': "o", 'ỡ': "o", 'ợ': "o",
+	'ụ': "u", 'ủ': "u", 'ứ': "u", 'ừ': "u", 'ử': "u", 'ữ': "u", 'ự': "u", 'ỳ': "y", 'ỵ': "y",
+	'ỷ': "y", 'ỹ': "y",
+}
+
+// normalizeForSearch lower-cases s and removes diacritics so that
+// "Übersetzen" matches "ubersetzen". It also removes '&' which marks
+// menu accelerators in strings like "&Open"
+func normalizeForSearch(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c == '&' {
+			continue
+		}
+		if unicode.Is(unicode.Mn, c) {
+			// combining diacritical mark
+			continue
+		}
+		c = unicode.ToLower(c)
+		if folded, ok := diacriticsFold[c]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func isWordSeparator(c rune) bool {
+	return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, isWordSeparator)
+}
+
+// textMatcher matches normalized text against a normalized query
+type textMatcher struct {
+	query     string
+	words     []string
+	wholeWord bool
+}
+
+func newTextMatcher(text string, wholeWord bool) *textMatcher {
+	query := normalizeForSearch(strings.TrimSpace(text))
+	return &textMatcher{
+		query:     query,
+		words:     splitWords(query),
+		wholeWord: wholeWord,
+	}
+}
+
+func (m *textMatcher) matchesAll() bool {
+	return m.query == ""
+}
+
+func (m *textMatcher) match(s string) bool {
+	if m.matchesAll() {
+		return true
+	}
+	if !m.wholeWord {
+		return strings.Contains(s, m.query)
+	}
+	if len(m.words) == 0 {
+		return false
+	}
+	for _, w := range m.words {
+		found := false
+		for _, w2 := range splitWords(s) {
+			if w == w2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *textMatcher) matchAny(arr []string) bool {
+	for _, s := range arr {
+		if m.match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+type searchResultSeq []*SearchResult
+
+func (s searchResultSeq) Len() int      { return len(s) }
+func (s searchResultSeq) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s searchResultSeq) Less(i, j int) bool {
+	r1, r2 := s[i], s[j]
+	if r1.StringID != r2.StringID {
+		if r1.String != r2.String {
+			return transStringLess(r1.String, r2.String)
+		}
+		return r1.StringID < r2.StringID
+	}
+	return r1.Lang < r2.Lang
+}
+
+func (s *StoreCsv) newSearchResult(strID, langID int, it *indexedTrans) *SearchResult {
+	res := &SearchResult{
+		StringID: strID,
+		String:   s.stringByIDMust(strID),
+		IsUnused: s.isUnused(strID),
+	}
+	if langID != -1 {
+		res.Lang = s.langByID(langID)
+	}
+	if it != nil {
+		res.Translation = it.current
+		res.User = s.userByID(it.userID)
+		res.Time = it.time
+	}
+	return res
+}
+
+func (s *StoreCsv) search(q *SearchQuery) []*SearchResult {
+	res := make([]*SearchResult, 0)
+	m := newTextMatcher(q.Text, q.WholeWord)
+	langs := make([]int, 0)
+	if q.Lang != "" {
+		langID := LangToId(q.Lang)
+		if langID == -1 {
+			return res
+		}
+		langs = append(langs, langID)
+	} else {
+		for langID := 0; langID < LangsCount(); langID++ {
+			langs = append(langs, langID)
+		}
+	}
+	filterByUser := -1
+	if q.User != "" {
+		id, ok := s.users.strToId[q.User]
+		if !ok {
+			return res
+		}
+		filterByUser = id
+	}
+	perLangFilter := q.Untranslated || !q.ChangedSince.IsZero() || filterByUser != -1
+
+	for strID, srcNorm := range s.index.stringsNorm {
+		if !q.IncludeUnused && s.isUnused(strID) {
+			continue
+		}
+		srcMatch := m.match(srcNorm)
+		if srcMatch && q.Lang == "" && !perLangFilter {
+			// don't list every language when only the source matched
+			r := s.newSearchResult(strID, -1, nil)
+			r.MatchedSource = true
+			res = append(res, r)
+			continue
+		}
+		for _, langID := range langs {
+			it := s.index.trans[langID][strID]
+			if it != nil && s.isHiddenUser(it.userID) {
+				// the index only knows the latest translation
+				it = nil
+			}
+			isTranslated := it != nil && it.current != ""
+			if q.Untranslated && isTranslated {
+				continue
+			}
+			if !q.ChangedSince.IsZero() && (it == nil || !it.time.After(q.ChangedSince)) {
+				continue
+			}
+			if filterByUser != -1 && (it == nil || it.userID != filterByUser) {
+				continue
+			}
+			transMatch := isTranslated && !m.matchesAll() && m.match(it.currentNorm)
+			histMatch := it != nil && !m.matchesAll() && m.matchAny(it.historyNorm)
+			if !srcMatch && !transMatch && !histMatch {
+				continue
+			}
+			r := s.newSearchResult(strID, langID, it)
+			r.MatchedSource = srcMatch && !m.matchesAll()
+			r.MatchedTranslation = transMatch
+			r.MatchedHistory = histMatch
+			res = append(res, r)
+		}
+	}
+	sort.Sort(searchResultSeq(res))
+	if q.Max > 0 && len(res) > q.Max {
+		res = res[:q.Max]
+	}
+	return res
+}
+
+// Search returns strings and translations matching the query
+func (s *StoreCsv) Search(q *SearchQuery) []*SearchResult {
+	s.Lock()
+	defer s.Unlock()
+	return s.search(q)
+}
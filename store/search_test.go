@@ -0,0 +1,85 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func searchStrings(s *StoreCsv, q *SearchQuery) []string {
+	res := make([]string, 0)
+	for _, r := range s.Search(q) {
+		res = append(res, r.String+"/"+r.Lang)
+	}
+	return res
+}
+
+func ensureSearchResults(t *testing.T, s *StoreCsv, q *SearchQuery, exp ...string) {
+	got := searchStrings(s, q)
+	if len(got) != len(exp) {
+		t.Fatalf("query %#v: got %v, expected %v", q, got, exp)
+	}
+	for i := range got {
+		if got[i] != exp[i] {
+			t.Fatalf("query %#v: got %v, expected %v", q, got, exp)
+		}
+	}
+}
+
+func TestNormalizeForSearch(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"Übersetzen", "ubersetzen"},
+		{"&Open", "open"},
+		{"Zażółć gęślą jaźń", "zazolc gesla jazn"},
+		{"Café", "cafe"},
+	}
+	for _, test := range tests {
+		got := normalizeForSearch(test.s)
+		if got != test.exp {
+			t.Fatalf("normalizeForSearch(%q) = %q, expected %q", test.s, got, test.exp)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	path := "searchtest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	s.updateStringsListMust([]string{"&Open file", "Close", "Open folder", "Print"})
+	s.writeNewTranslationMust("&Open file", "Otwórz plik", "pl", "user1")
+	s.writeNewTranslationMust("&Open file", "Datei öffnen", "de", "user2")
+	s.writeNewTranslationMust("Close", "Zamknij okno", "pl", "user1")
+	s.writeNewTranslationMust("Close", "Zamknij", "pl", "user2")
+
+	ensureSearchResults(t, s, &SearchQuery{Text: "open"}, "&Open file/", "Open folder/")
+	ensureSearchResults(t, s, &SearchQuery{Text: "OPEN", Lang: "pl"}, "&Open file/pl", "Open folder/pl")
+	ensureSearchResults(t, s, &SearchQuery{Text: "otworz"}, "&Open file/pl")
+	ensureSearchResults(t, s, &SearchQuery{Text: "offnen", Lang: "de"}, "&Open file/de")
+	ensureSearchResults(t, s, &SearchQuery{Text: "okno"}, "Close/pl")
+	ensureSearchResults(t, s, &SearchQuery{Text: "fil", WholeWord: true})
+	ensureSearchResults(t, s, &SearchQuery{Text: "file open", WholeWord: true}, "&Open file/")
+	ensureSearchResults(t, s, &SearchQuery{Lang: "pl", Untranslated: true}, "Open folder/pl", "Print/pl")
+	ensureSearchResults(t, s, &SearchQuery{Text: "open", Lang: "pl", Untranslated: true}, "Open folder/pl")
+	ensureSearchResults(t, s, &SearchQuery{User: "user2"}, "Close/pl", "&Open file/de")
+	ensureSearchResults(t, s, &SearchQuery{User: "user3"})
+	ensureSearchResults(t, s, &SearchQuery{Lang: "pl", ChangedSince: time.Now().Add(-time.Hour)}, "Close/pl", "&Open file/pl")
+	ensureSearchResults(t, s, &SearchQuery{Lang: "pl", ChangedSince: time.Now().Add(time.Hour)})
+
+	// unused strings are only returned when asked for
+	s.updateStringsListMust([]string{"Close", "Print"})
+	ensureSearchResults(t, s, &SearchQuery{Text: "open"})
+	ensureSearchResults(t, s, &SearchQuery{Text: "open", IncludeUnused: true}, "&Open file/", "Open folder/")
+	s.Close()
+
+	// the index is rebuilt when reading existing data
+	s = NewTestStore(path)
+	ensureSearchResults(t, s, &SearchQuery{Text: "okno"}, "Close/pl")
+	ensureSearchResults(t, s, &SearchQuery{Text: "zamknij", Lang: "pl"}, "Close/pl")
+	s.Close()
+}
@@ -0,0 +1,141 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Session describes a logged in session of a user
+type Session struct {
+	ID      string
+	User    string
+	Created time.Time
+	Revoked bool
+}
+
+// sess, ${timeUnix}, ${id}, ${user}, ${active}
+func (s *UserStore) decodeSessionRecord(rec []string) error {
+	if len(rec) != 5 {
+		return fmt.Errorf("'sess' record should have 5 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	id := rec[2]
+	if rec[4] == "1" {
+		s.sessions[id] = &Session{
+			ID:      id,
+			User:    rec[3],
+			Created: time.Unix(timeSecs, 0),
+		}
+		return nil
+	}
+	if sess, ok := s.sessions[id]; ok {
+		sess.Revoked = true
+	}
+	return nil
+}
+
+func (s *UserStore) writeSession(id, user string, active bool) error {
+	activeStr := "0"
+	if active {
+		activeStr = "1"
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	rec := []string{recIDSession, timeStr, id, user, activeStr}
+	return s.writeCsv(rec)
+}
+
+func (s *UserStore) revokeSession(sess *Session) error {
+	if sess.Revoked {
+		return nil
+	}
+	if err := s.writeSession(sess.ID, sess.User, false); err != nil {
+		return err
+	}
+	sess.Revoked = true
+	return nil
+}
+
+// CreateSession records a new session of user
+func (s *UserStore) CreateSession(id, user string) (*Session, error) {
+	s.Lock()
+	defer s.Unlock()
+	if id == "" || user == "" {
+		return nil, fmt.Errorf("empty session id or user")
+	}
+	if _, exists := s.sessions[id]; exists {
+		return nil, fmt.Errorf("session %q already exists", id)
+	}
+	if err := s.writeSession(id, user, true); err != nil {
+		return nil, err
+	}
+	sess := &Session{ID: id, User: user, Created: time.Now()}
+	s.sessions[id] = sess
+	res := *sess
+	return &res, nil
+}
+
+// GetSession returns a copy of a session or nil if it doesn't exist
+func (s *UserStore) GetSession(id string) *Session {
+	s.Lock()
+	defer s.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	res := *sess
+	return &res
+}
+
+// RevokeSession revokes a session, after which it can no longer be used
+func (s *UserStore) RevokeSession(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %q doesn't exist", id)
+	}
+	return s.revokeSession(sess)
+}
+
+// RevokeUserSessions revokes all sessions of user and returns how many
+// were revoked
+func (s *UserStore) RevokeUserSessions(user string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.User != user || sess.Revoked {
+			continue
+		}
+		if err := s.revokeSession(sess); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ActiveSessions returns sessions that are not revoked and were created
+// after a given time, newest first
+func (s *UserStore) ActiveSessions(createdAfter time.Time) []*Session {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]*Session, 0)
+	for _, sess := range s.sessions {
+		if sess.Revoked || !sess.Created.After(createdAfter) {
+			continue
+		}
+		c := *sess
+		res = append(res, &c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.After(res[j].Created)
+	})
+	return res
+}
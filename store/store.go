@@ -19,12 +19,22 @@ import (
 s,  ${strId}, ${str}
 t,  ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${translation}
 as, ${timeUnix}, ${strId}, ...
+c,  ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${text}
+ss, ${timeUnix}, ${fileName}, ${strId}, ...
+rv, ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${translation}
+up, ${timeUnix}, ${by}, ${what}, ${details}
+hu, ${timeUnix}, ${user}, ...
 
 */
 const (
-	recIDNewString = "s"
-	recIDTrans     = "t"
-	recIDActiveSet = "as"
+	recIDNewString   = "s"
+	recIDTrans       = "t"
+	recIDActiveSet   = "as"
+	recIDComment     = "c"
+	recIDScreenshot  = "ss"
+	recIDReview      = "rv"
+	recIDUpload      = "up"
+	recIDHiddenUsers = "hu"
 )
 
 // TranslationRec represents translation record
@@ -38,6 +48,8 @@ type TranslationRec struct {
 
 // Edit describes a single edit
 type Edit struct {
+	// position in the log of edits, which never changes
+	ID          int
 	Lang        string
 	User        string
 	Text        string
@@ -62,6 +74,27 @@ type StoreCsv struct {
 	activeStrings        []int
 	deletedStringsBitmap []bool
 	edits                []TranslationRec
+	comments             []CommentRec
+	// maps screenshot file name to ids of strings it shows
+	screenshots map[string][]int
+	index       *searchIndex
+	// maps lang id to string id to the latest review
+	reviews map[int]map[int]*ReviewRec
+	// number of reviews, including the ones that replaced older reviews
+	reviewsCount int
+	// users whose edits are not shown
+	hiddenUsers map[string]bool
+	uploads     []Upload
+	// see revisions.go
+	revisions []revision
+	// the last result of progressHistory, see progress.go
+	progress *progressCache
+	// recent results of translationsAt, see revisions.go
+	transSnapshots []*transSnapshot
+	// indexes into edits by user id and by string and language, see
+	// userstats.go
+	userEdits map[int][]int
+	keyEdits  map[transKey][]int
 }
 
 func openCsv(path string) (*os.File, *csv.Writer, error) {
@@ -77,10 +110,15 @@ func NewStoreCsv(path string) (*StoreCsv, error) {
 	//fmt.Printf("NewStoreCsv: %q\n", path)
 	var err error
 	s := &StoreCsv{
-		filePath: path,
-		strings:  NewStringInterner(),
-		users:    NewStringInterner(),
-		edits:    make([]TranslationRec, 0),
+		filePath:    path,
+		strings:     NewStringInterner(),
+		users:       NewStringInterner(),
+		edits:       make([]TranslationRec, 0),
+		index:       newSearchIndex(),
+		screenshots: make(map[string][]int),
+		reviews:     make(map[int]map[int]*ReviewRec),
+		userEdits:   make(map[int][]int),
+		keyEdits:    make(map[transKey][]int),
 	}
 	if u.PathExists(path) {
 		if err = s.readExistingRecords(path); err != nil {
@@ -110,6 +148,7 @@ func (s *StoreCsv) internStringAndWriteIfNecessary(str string) (int, error) {
 	if isNew {
 		//fmt.Printf("internStringAndWriteIfNecessary: new string %q, id: %d\n", str, strId)
 		s.setActiveStrings(s.activeStrings)
+		s.index.addString(strID, str)
 		return strID, s.writeNewStringRec(strID, str)
 	}
 	//fmt.Printf("internStringAndWriteIfNecessary: existing string %q, id: %d\n", str, strId)
@@ -147,6 +186,7 @@ func (s *StoreCsv) decodeNewStringRecord(rec []string) error {
 	if !isNew {
 		return fmt.Errorf("expected a new string in rec: '%#v')", rec)
 	}
+	s.index.addString(newID, rec[2])
 	return nil
 }
 
@@ -162,6 +202,9 @@ func (s *StoreCsv) addTranslationRec(strID, langID, userID int, trans string, ti
 		time:        time,
 	}
 	s.edits = append(s.edits, tr)
+	s.indexEdit(len(s.edits) - 1)
+	s.index.addTranslation(strID, langID, userID, trans, time)
+	s.addRevision(time)
 }
 
 // t,  ${timeUnix}, ${userStr}, ${langStr}, ${strId}, ${translation}
@@ -205,6 +248,7 @@ func (s *StoreCsv) decodeActiveSetRecord(rec []string) error {
 	}
 
 	s.setActiveStrings(IntRangeToArray(activeRange))
+	s.addRevision(recordTime(rec))
 	return nil
 }
 
@@ -220,6 +264,16 @@ func (s *StoreCsv) decodeRecord(rec []string) error {
 		err = s.decodeActiveSetRecord(rec)
 	case recIDTrans:
 		err = s.decodeTranslationRecord(rec)
+	case recIDComment:
+		err = s.decodeCommentRecord(rec)
+	case recIDScreenshot:
+		err = s.decodeScreenshotRecord(rec)
+	case recIDReview:
+		err = s.decodeReviewRecord(rec)
+	case recIDUpload:
+		err = s.decodeUploadRecord(rec)
+	case recIDHiddenUsers:
+		err = s.decodeHiddenUsersRecord(rec)
 	default:
 		err = fmt.Errorf("unkown record type %q", rec[0])
 	}
@@ -272,10 +326,12 @@ func (s *StoreCsv) translatedCountForLangs() map[int]int {
 		m[langID] = make([]bool, totalStrings, totalStrings)
 	}
 	res := make(map[int]int)
-	for _, trec := range s.edits {
-		if !s.isUnused(trec.stringID) {
+	for i := range s.edits {
+		trec := &s.edits[i]
+		if !s.isUnused(trec.stringID) && !s.isHiddenEdit(trec) {
 			arr := m[trec.langID]
-			arr[trec.stringID] = true
+			// empty translation (e.g. after a revert) means untranslated
+			arr[trec.stringID] = trec.translation != ""
 		}
 	}
 	for langID, arr := range m {
@@ -327,21 +383,21 @@ func (s *StoreCsv) stringByIDMust(id int) string {
 }
 
 func (s *StoreCsv) recentEdits(max int) []Edit {
-	n := max
 	transCount := len(s.edits)
-	if n > transCount {
-		n = transCount
-	}
-	res := make([]Edit, n, n)
-	for i := 0; i < n; i++ {
+	res := make([]Edit, 0, max)
+	for i := 0; i < transCount && len(res) < max; i++ {
 		tr := &(s.edits[transCount-i-1])
+		if s.isHiddenEdit(tr) {
+			continue
+		}
 		var e Edit
+		e.ID = transCount - i - 1
 		e.Lang = s.langByID(tr.langID)
 		e.User = s.userByID(tr.userID)
 		e.Text = s.stringByIDMust(tr.stringID)
 		e.Translation = tr.translation
 		e.Time = tr.time
-		res[i] = e
+		res = append(res, e)
 	}
 	return res
 }
@@ -363,8 +419,9 @@ func (s *StoreCsv) translationsForLang(langID int) ([]*Translation, []*Translati
 		all[strID] = NewTranslation(strID, str, "")
 	}
 
-	for _, edit := range s.edits {
-		if langID != edit.langID {
+	for i := range s.edits {
+		edit := &s.edits[i]
+		if langID != edit.langID || s.isHiddenEdit(edit) {
 			continue
 		}
 		tr := all[edit.stringID]
@@ -398,21 +455,10 @@ func (s *StoreCsv) langInfos() []*LangInfo {
 }
 
 func (s *StoreCsv) editsByUser(user string) []Edit {
-	res := make([]Edit, 0)
-	transCount := len(s.edits)
-	for i := 0; i < transCount; i++ {
-		tr := &(s.edits[transCount-i-1])
-		editUser := s.userByID(tr.userID)
-		if editUser == user {
-			var e = Edit{
-				Lang:        s.langByID(tr.langID),
-				User:        editUser,
-				Text:        s.stringByIDMust(tr.stringID),
-				Translation: tr.translation,
-				Time:        tr.time,
-			}
-			res = append(res, e)
-		}
+	idxs := s.userEditIndexes(user)
+	res := make([]Edit, 0, len(idxs))
+	for i := len(idxs) - 1; i >= 0; i-- {
+		res = append(res, s.editAt(idxs[i]))
 	}
 	return res
 }
@@ -423,8 +469,9 @@ func (s *StoreCsv) editsForLang(lang string, max int) []Edit {
 	for i := 0; i < transCount; i++ {
 		tr := &(s.edits[transCount-i-1])
 		editLang := s.langByID(tr.langID)
-		if editLang == lang {
+		if editLang == lang && !s.isHiddenEdit(tr) {
 			var e = Edit{
+				ID:          transCount - i - 1,
 				Lang:        s.langByID(tr.langID),
 				User:        s.userByID(tr.userID),
 				Text:        s.stringByIDMust(tr.stringID),
@@ -447,7 +494,7 @@ func (s *StoreCsv) translators() []*Translator {
 		userID := tr.userID
 		// filter out edits by the dummy 'unknown' user (used for translations
 		// imported from the code before we had apptranslator)
-		if userID == unknownUserID {
+		if userID == unknownUserID || s.isHiddenUser(userID) {
 			continue
 		}
 		if t, ok := m[userID]; ok {
@@ -520,8 +567,9 @@ func (s *StoreCsv) duplicateTranslation(origStr, newStr string) error {
 	nLangs := LangsCount()
 	langTrans := make([]string, nLangs, nLangs)
 	langUserID := make([]int, nLangs, nLangs)
-	for _, edit := range s.edits {
-		if origStrID != edit.stringID {
+	for i := range s.edits {
+		edit := &s.edits[i]
+		if origStrID != edit.stringID || s.isHiddenEdit(edit) {
 			continue
 		}
 		langTrans[edit.langID] = edit.translation
@@ -550,6 +598,24 @@ func (s *StoreCsv) WriteNewTranslation(txt, trans, lang, user string) error {
 	return s.writeNewTranslation(txt, trans, lang, user)
 }
 
+// CurrentTranslation returns the current translation of str in lang and
+// who made it. Returns "" if str is not translated
+func (s *StoreCsv) CurrentTranslation(str, lang string) (string, string) {
+	s.Lock()
+	defer s.Unlock()
+	strID, langID, err := s.stringAndLangIDs(str, lang)
+	if err != nil {
+		return "", ""
+	}
+	for i := len(s.edits) - 1; i >= 0; i-- {
+		tr := &s.edits[i]
+		if tr.stringID == strID && tr.langID == langID && !s.isHiddenEdit(tr) {
+			return tr.translation, s.userByID(tr.userID)
+		}
+	}
+	return "", ""
+}
+
 // DuplicateTranslation duplicates a translation
 func (s *StoreCsv) DuplicateTranslation(origStr, newStr string) error {
 	s.Lock()
@@ -643,6 +709,7 @@ func (s *StoreCsv) writeActiveStrings(activeStrings []string) (err error) {
 		return err
 	}
 	s.setActiveStrings(activeStrIds)
+	s.addRevision(time.Now())
 	return nil
 }
 
@@ -660,3 +727,12 @@ func (s *StoreCsv) GetUnusedStrings() []string {
 	defer s.Unlock()
 	return s.getDeletedStrings()
 }
+
+// IsActiveString returns true if str is one of the strings currently used
+// by the app
+func (s *StoreCsv) IsActiveString(str string) bool {
+	s.Lock()
+	defer s.Unlock()
+	strID, ok := s.strings.strToId[str]
+	return ok && strID < len(s.deletedStringsBitmap) && !s.deletedStringsBitmap[strID]
+}
@@ -0,0 +1,240 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how often digests are sent
+const (
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+)
+
+// Subscription is a request to get an email digest of changes in a language
+// of an app or, if Lang is "", a digest of uploads and flagged edits for
+// admins of an app. ID is secret and is used in unsubscribe links
+type Subscription struct {
+	ID        string
+	User      string
+	Email     string
+	App       string
+	Lang      string
+	Frequency string
+	// true if the user confirmed they own the email
+	Confirmed bool
+	Created   time.Time
+	// zero if no digest was sent yet
+	LastSent time.Time
+	Deleted  bool
+}
+
+// Period returns time between digests
+func (sub *Subscription) Period() time.Duration {
+	if sub.Frequency == FrequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Since returns the start of the period covered by the next digest
+func (sub *Subscription) Since() time.Time {
+	if sub.LastSent.IsZero() {
+		return sub.Created
+	}
+	return sub.LastSent
+}
+
+// IsDue returns true if a digest should be sent at time now
+func (sub *Subscription) IsDue(now time.Time) bool {
+	return sub.Confirmed && !sub.Deleted && now.Sub(sub.Since()) >= sub.Period()
+}
+
+func (sub *Subscription) copy() *Subscription {
+	res := *sub
+	return &res
+}
+
+func parseTimeField(rec []string, i int) (time.Time, error) {
+	timeSecs, err := strconv.ParseInt(rec[i], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("rec[%d] (%q) failed to parse as int64, error: %q", i, rec[i], err)
+	}
+	return time.Unix(timeSecs, 0), nil
+}
+
+// sub, ${timeUnix}, ${id}, ${user}, ${email}, ${app}, ${lang}, ${frequency}, ${confirmed}
+func (s *UserStore) decodeSubscriptionRecord(rec []string) error {
+	if len(rec) != 9 {
+		return fmt.Errorf("'sub' record should have 9 fields, is '%#v'", rec)
+	}
+	t, err := parseTimeField(rec, 1)
+	if err != nil {
+		return err
+	}
+	s.subscriptions[rec[2]] = &Subscription{
+		ID:        rec[2],
+		User:      rec[3],
+		Email:     rec[4],
+		App:       rec[5],
+		Lang:      rec[6],
+		Frequency: rec[7],
+		Confirmed: rec[8] == "1",
+		Created:   t,
+	}
+	return nil
+}
+
+// subconf, ${timeUnix}, ${id}
+// subsent, ${timeUnix}, ${id}
+// subdel, ${timeUnix}, ${id}
+func (s *UserStore) decodeSubscriptionChangeRecord(rec []string) error {
+	if len(rec) != 3 {
+		return fmt.Errorf("'%s' record should have 3 fields, is '%#v'", rec[0], rec)
+	}
+	t, err := parseTimeField(rec, 1)
+	if err != nil {
+		return err
+	}
+	sub, ok := s.subscriptions[rec[2]]
+	if !ok {
+		return fmt.Errorf("rec[2] (%q) is not a valid subscription id", rec[2])
+	}
+	s.applySubscriptionChange(sub, rec[0], t)
+	return nil
+}
+
+func (s *UserStore) applySubscriptionChange(sub *Subscription, recID string, t time.Time) {
+	switch recID {
+	case recIDSubscriptionConfirmed:
+		sub.Confirmed = true
+	case recIDSubscriptionSent:
+		sub.LastSent = t
+	case recIDSubscriptionDeleted:
+		sub.Deleted = true
+	}
+}
+
+func (s *UserStore) changeSubscription(id, recID string, t time.Time) (*Subscription, error) {
+	sub, ok := s.subscriptions[id]
+	if !ok || sub.Deleted {
+		return nil, fmt.Errorf("subscription %q doesn't exist", id)
+	}
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	if err := s.writeCsv([]string{recID, timeStr, id}); err != nil {
+		return nil, err
+	}
+	s.applySubscriptionChange(sub, recID, t)
+	return sub.copy(), nil
+}
+
+// CreateSubscription records a new subscription. An existing subscription
+// of user to the same app and language is replaced
+func (s *UserStore) CreateSubscription(id, user, email, app, lang, frequency string, confirmed bool) (*Subscription, error) {
+	s.Lock()
+	defer s.Unlock()
+	if id == "" || user == "" || app == "" {
+		return nil, fmt.Errorf("empty subscription id, user or app")
+	}
+	if frequency != FrequencyDaily && frequency != FrequencyWeekly {
+		return nil, fmt.Errorf("%q is not a valid frequency", frequency)
+	}
+	email = strings.TrimSpace(email)
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, " \r\n,<>") {
+		return nil, fmt.Errorf("%q is not a valid email", email)
+	}
+	if _, exists := s.subscriptions[id]; exists {
+		return nil, fmt.Errorf("subscription %q already exists", id)
+	}
+	t := time.Now()
+	for _, sub := range s.subscriptions {
+		if sub.User == user && sub.App == app && sub.Lang == lang && !sub.Deleted {
+			if _, err := s.changeSubscription(sub.ID, recIDSubscriptionDeleted, t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	confirmedStr := "0"
+	if confirmed {
+		confirmedStr = "1"
+	}
+	rec := []string{recIDSubscription, timeStr, id, user, email, app, lang, frequency, confirmedStr}
+	if err := s.writeCsv(rec); err != nil {
+		return nil, err
+	}
+	sub := &Subscription{
+		ID:        id,
+		User:      user,
+		Email:     email,
+		App:       app,
+		Lang:      lang,
+		Frequency: frequency,
+		Confirmed: confirmed,
+		Created:   t,
+	}
+	s.subscriptions[id] = sub
+	return sub.copy(), nil
+}
+
+// GetSubscription returns a copy of a subscription or nil if it doesn't
+// exist or was deleted
+func (s *UserStore) GetSubscription(id string) *Subscription {
+	s.Lock()
+	defer s.Unlock()
+	if sub, ok := s.subscriptions[id]; ok && !sub.Deleted {
+		return sub.copy()
+	}
+	return nil
+}
+
+// Subscriptions returns subscriptions of user or, if user is "", of all
+// users, oldest first
+func (s *UserStore) Subscriptions(user string) []*Subscription {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]*Subscription, 0)
+	for _, sub := range s.subscriptions {
+		if !sub.Deleted && (user == "" || sub.User == user) {
+			res = append(res, sub.copy())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Created.Equal(res[j].Created) {
+			return res[i].Created.Before(res[j].Created)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// ConfirmSubscription marks subscription's email as confirmed
+func (s *UserStore) ConfirmSubscription(id string) (*Subscription, error) {
+	s.Lock()
+	defer s.Unlock()
+	if sub, ok := s.subscriptions[id]; ok && sub.Confirmed && !sub.Deleted {
+		return sub.copy(), nil
+	}
+	return s.changeSubscription(id, recIDSubscriptionConfirmed, time.Now())
+}
+
+// MarkSubscriptionSent records that a digest covering changes up to t was
+// sent
+func (s *UserStore) MarkSubscriptionSent(id string, t time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	_, err := s.changeSubscription(id, recIDSubscriptionSent, t)
+	return err
+}
+
+// DeleteSubscription deletes a subscription
+func (s *UserStore) DeleteSubscription(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	_, err := s.changeSubscription(id, recIDSubscriptionDeleted, time.Now())
+	return err
+}
@@ -0,0 +1,52 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSubscriptions(t *testing.T) {
+	path := "subscriptionstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	_, err := s.CreateSubscription("id1", "alice", "alice@example.com", "SumatraPDF", "pl", FrequencyDaily, false)
+	fatalIfErr(err)
+	_, err = s.CreateSubscription("id2", "bob", "bob@example.com", "SumatraPDF", "", FrequencyWeekly, true)
+	fatalIfErr(err)
+	for _, args := range [][]string{
+		{"id3", "alice", "not an email", "daily"},
+		{"id3", "alice", "alice@example.com", "hourly"},
+		{"id1", "carol", "carol@example.com", "daily"},
+	} {
+		_, err = s.CreateSubscription(args[0], args[1], args[2], "SumatraPDF", "de", args[3], true)
+		fatalIf(err == nil, "CreateSubscription(%v) should fail", args)
+	}
+
+	now := time.Now()
+	sub := s.GetSubscription("id1")
+	fatalIf(sub == nil || sub.IsDue(now.Add(48*time.Hour)), "unconfirmed subscription can't be due %#v", sub)
+	sub, err = s.ConfirmSubscription("id1")
+	fatalIfErr(err)
+	fatalIf(sub.IsDue(now) || !sub.IsDue(now.Add(25*time.Hour)), "unexpected due %#v", sub)
+	fatalIfErr(s.MarkSubscriptionSent("id1", now.Add(25*time.Hour)))
+	fatalIf(s.GetSubscription("id1").IsDue(now.Add(26*time.Hour)), "digest was just sent")
+	fatalIf(s.GetSubscription("id2").IsDue(now.Add(6*24*time.Hour)), "weekly digest is not due after 6 days")
+
+	// subscribing again to the same language replaces the subscription
+	_, err = s.CreateSubscription("id4", "alice", "alice@example.com", "SumatraPDF", "pl", FrequencyWeekly, true)
+	fatalIfErr(err)
+	fatalIf(s.GetSubscription("id1") != nil, "id1 should be replaced")
+	fatalIfErr(s.DeleteSubscription("id2"))
+	fatalIf(s.DeleteSubscription("id2") == nil, "deleting twice should fail")
+	s.Close()
+
+	s = NewTestUserStore(path)
+	defer s.Close()
+	subs := s.Subscriptions("")
+	fatalIf(len(subs) != 1 || subs[0].ID != "id4" || subs[0].Frequency != FrequencyWeekly || !subs[0].Confirmed, "unexpected %#v", subs)
+	fatalIf(len(s.Subscriptions("bob")) != 0, "bob has no subscriptions")
+}
@@ -0,0 +1,132 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// Suggestion is an existing translation of a string similar to the one
+// being translated
+type Suggestion struct {
+	String      string
+	Translation string
+	// 100 means the strings are the same, modulo case, accelerators and
+	// punctuation
+	Score int
+}
+
+const suggestTrimSet = ".:;,!?()[]_ "
+
+// suggestKey returns a form of the string that doesn't change between
+// variants like "&Open", "Open..." and "open:"
+func suggestKey(s string) string {
+	s = normalizeForSearch(s)
+	s = strings.Replace(s, "...", "", -1)
+	s = strings.Replace(s, "…", "", -1)
+	return strings.Trim(s, suggestTrimSet)
+}
+
+type suggestCandidate struct {
+	strID int
+	key   string
+	words map[string]bool
+	trans string
+}
+
+func wordSet(s string) map[string]bool {
+	res := make(map[string]bool)
+	for _, w := range splitWords(s) {
+		res[w] = true
+	}
+	return res
+}
+
+// similarity is a Jaccard index of word sets, in 0-100 range
+func similarity(w1, w2 map[string]bool) int {
+	if len(w1) == 0 || len(w2) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range w1 {
+		if w2[w] {
+			common++
+		}
+	}
+	total := len(w1) + len(w2) - common
+	return common * 100 / total
+}
+
+type suggestionSeq []Suggestion
+
+func (s suggestionSeq) Len() int      { return len(s) }
+func (s suggestionSeq) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestionSeq) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score > s[j].Score
+	}
+	return transStringLess(s[i].String, s[j].String)
+}
+
+func (s *StoreCsv) suggest(lang string, strs []string, max int, minScore int) [][]Suggestion {
+	res := make([][]Suggestion, len(strs))
+	langID := LangToId(lang)
+	if langID == -1 {
+		return res
+	}
+	candidates := make([]*suggestCandidate, 0)
+	for strID, it := range s.index.trans[langID] {
+		if it.current == "" {
+			continue
+		}
+		key := suggestKey(s.stringByIDMust(strID))
+		c := &suggestCandidate{
+			strID: strID,
+			key:   key,
+			words: wordSet(key),
+			trans: it.current,
+		}
+		candidates = append(candidates, c)
+	}
+	for i, str := range strs {
+		key := suggestKey(str)
+		words := wordSet(key)
+		arr := make([]Suggestion, 0)
+		for _, c := range candidates {
+			other := s.stringByIDMust(c.strID)
+			if other == str {
+				continue
+			}
+			score := 100
+			if c.key != key {
+				// exact matches modulo punctuation always rank above
+				// partial matches
+				score = similarity(words, c.words) * 99 / 100
+			}
+			if score < minScore {
+				continue
+			}
+			sugg := Suggestion{
+				String:      other,
+				Translation: c.trans,
+				Score:       score,
+			}
+			arr = append(arr, sugg)
+		}
+		sort.Sort(suggestionSeq(arr))
+		if len(arr) > max {
+			arr = arr[:max]
+		}
+		res[i] = arr
+	}
+	return res
+}
+
+// Suggest returns, for each of strs, up to max existing translations in
+// lang of strings that are similar. Suggestions with score lower than
+// minScore are omitted
+func (s *StoreCsv) Suggest(lang string, strs []string, max int, minScore int) [][]Suggestion {
+	s.Lock()
+	defer s.Unlock()
+	return s.suggest(lang, strs, max, minScore)
+}
@@ -0,0 +1,38 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	path := "suggesttest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	defer s.Close()
+	s.updateStringsListMust([]string{"&Open", "Open...", "Open file", "Save file", "Print"})
+	s.writeNewTranslationMust("&Open", "&Otwórz", "pl", "user1")
+	s.writeNewTranslationMust("Save file", "Zapisz plik", "pl", "user1")
+
+	res := s.Suggest("pl", []string{"Open...", "Open file", "Print"}, 5, 30)
+	if len(res) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(res))
+	}
+	if len(res[0]) != 1 || res[0][0].Translation != "&Otwórz" || res[0][0].Score != 100 {
+		t.Fatalf("unexpected suggestions for 'Open...': %#v", res[0])
+	}
+	// "Open file" shares one word with "&Open" and one with "Save file"
+	if len(res[1]) != 2 || res[1][0].Score >= 100 {
+		t.Fatalf("unexpected suggestions for 'Open file': %#v", res[1])
+	}
+	if len(res[2]) != 0 {
+		t.Fatalf("unexpected suggestions for 'Print': %#v", res[2])
+	}
+	res = s.Suggest("de", []string{"Open..."}, 5, 30)
+	if len(res[0]) != 0 {
+		t.Fatalf("unexpected suggestions for 'Open...' in de: %#v", res[0])
+	}
+}
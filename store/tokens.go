@@ -0,0 +1,248 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scopes of API tokens, which limit what a token can be used for
+const (
+	// uploading strings and screenshots
+	ScopeUploadStrings = "upload-strings"
+	// downloading translations
+	ScopeDownload = "download"
+	// importing existing translations
+	ScopeImport = "import"
+	// everything
+	ScopeAdmin = "admin"
+)
+
+// TokenScopes lists all valid scopes of API tokens
+var TokenScopes = []string{ScopeUploadStrings, ScopeDownload, ScopeImport, ScopeAdmin}
+
+// IsValidScope returns true if scope is one of TokenScopes
+func IsValidScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// we don't record every use of a token, only if the last recorded use is
+// older than that
+const tokenUsedResolution = time.Hour
+
+// APIToken describes an API token of an app. We only store hash of the
+// token, the token itself is shown once when it's created
+type APIToken struct {
+	ID        string
+	App       string
+	Name      string
+	Scopes    []string
+	Hash      string
+	CreatedBy string
+	Created   time.Time
+	// zero if never used
+	LastUsed  time.Time
+	Revoked   bool
+	RevokedBy string
+	// last use recorded in the file
+	lastUsedRecorded time.Time
+}
+
+// HasScope returns true if token can be used for scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) copy() *APIToken {
+	res := *t
+	res.Scopes = append([]string(nil), t.Scopes...)
+	return &res
+}
+
+// tok, ${timeUnix}, ${id}, ${app}, ${name}, ${scopes}, ${hash}, ${createdBy}
+func (s *UserStore) decodeTokenRecord(rec []string) error {
+	if len(rec) != 8 {
+		return fmt.Errorf("'tok' record should have 8 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	t := &APIToken{
+		ID:        rec[2],
+		App:       rec[3],
+		Name:      rec[4],
+		Scopes:    strings.Fields(rec[5]),
+		Hash:      rec[6],
+		CreatedBy: rec[7],
+		Created:   time.Unix(timeSecs, 0),
+	}
+	s.tokens[t.ID] = t
+	s.tokensByHash[t.Hash] = t
+	return nil
+}
+
+// tokrev, ${timeUnix}, ${id}, ${revokedBy}
+func (s *UserStore) decodeTokenRevokedRecord(rec []string) error {
+	if len(rec) != 4 {
+		return fmt.Errorf("'tokrev' record should have 4 fields, is '%#v'", rec)
+	}
+	t, ok := s.tokens[rec[2]]
+	if !ok {
+		return fmt.Errorf("rec[2] (%q) is not a valid token id", rec[2])
+	}
+	t.Revoked = true
+	t.RevokedBy = rec[3]
+	return nil
+}
+
+// tokused, ${timeUnix}, ${id}
+func (s *UserStore) decodeTokenUsedRecord(rec []string) error {
+	if len(rec) != 3 {
+		return fmt.Errorf("'tokused' record should have 3 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	t, ok := s.tokens[rec[2]]
+	if !ok {
+		return fmt.Errorf("rec[2] (%q) is not a valid token id", rec[2])
+	}
+	t.LastUsed = time.Unix(timeSecs, 0)
+	t.lastUsedRecorded = t.LastUsed
+	return nil
+}
+
+// CreateAPIToken records a new API token for app
+func (s *UserStore) CreateAPIToken(id, app, name string, scopes []string, hash, by string) (*APIToken, error) {
+	s.Lock()
+	defer s.Unlock()
+	if id == "" || hash == "" {
+		return nil, fmt.Errorf("empty token id or hash")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("token name can't be empty")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("token must have at least one scope")
+	}
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return nil, fmt.Errorf("%q is not a valid scope", scope)
+		}
+	}
+	if _, exists := s.tokens[id]; exists {
+		return nil, fmt.Errorf("token %q already exists", id)
+	}
+	t := time.Now()
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	rec := []string{recIDToken, timeStr, id, app, name, strings.Join(scopes, " "), hash, by}
+	if err := s.writeCsv(rec); err != nil {
+		return nil, err
+	}
+	tok := &APIToken{
+		ID:        id,
+		App:       app,
+		Name:      name,
+		Scopes:    append([]string(nil), scopes...),
+		Hash:      hash,
+		CreatedBy: by,
+		Created:   t,
+	}
+	s.tokens[id] = tok
+	s.tokensByHash[hash] = tok
+	return tok.copy(), nil
+}
+
+// GetAPIToken returns a copy of a token or nil if it doesn't exist
+func (s *UserStore) GetAPIToken(id string) *APIToken {
+	s.Lock()
+	defer s.Unlock()
+	if t, ok := s.tokens[id]; ok {
+		return t.copy()
+	}
+	return nil
+}
+
+// APITokenByHash returns a copy of a token with a given hash or nil if it
+// doesn't exist
+func (s *UserStore) APITokenByHash(hash string) *APIToken {
+	s.Lock()
+	defer s.Unlock()
+	if t, ok := s.tokensByHash[hash]; ok {
+		return t.copy()
+	}
+	return nil
+}
+
+// APITokens returns tokens of app that were not revoked, oldest first
+func (s *UserStore) APITokens(app string) []*APIToken {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]*APIToken, 0)
+	for _, t := range s.tokens {
+		if t.App == app && !t.Revoked {
+			res = append(res, t.copy())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+// RevokeAPIToken revokes a token, after which it can no longer be used
+func (s *UserStore) RevokeAPIToken(id, by string) error {
+	s.Lock()
+	defer s.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return fmt.Errorf("token %q doesn't exist", id)
+	}
+	if t.Revoked {
+		return nil
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.writeCsv([]string{recIDTokenRevoked, timeStr, id, by}); err != nil {
+		return err
+	}
+	t.Revoked = true
+	t.RevokedBy = by
+	return nil
+}
+
+// MarkAPITokenUsed updates last use of a token. To not grow the file on
+// every use, it's only saved if the last saved use is older than an hour
+func (s *UserStore) MarkAPITokenUsed(id string, when time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return fmt.Errorf("token %q doesn't exist", id)
+	}
+	t.LastUsed = when
+	if when.Sub(t.lastUsedRecorded) < tokenUsedResolution {
+		return nil
+	}
+	timeStr := strconv.FormatInt(when.Unix(), 10)
+	if err := s.writeCsv([]string{recIDTokenUsed, timeStr, id}); err != nil {
+		return err
+	}
+	t.lastUsedRecorded = when
+	return nil
+}
@@ -0,0 +1,61 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Upload records who uploaded strings or a screenshot
+type Upload struct {
+	// who did the upload, e.g. "token:${id}"
+	By string
+	// "strings" or "screenshot"
+	What string
+	// e.g. number of strings or name of the screenshot
+	Details string
+	Time    time.Time
+}
+
+// up, ${timeUnix}, ${by}, ${what}, ${details}
+func (s *StoreCsv) decodeUploadRecord(rec []string) error {
+	if len(rec) != 5 {
+		return fmt.Errorf("'up' record should have 5 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	s.uploads = append(s.uploads, Upload{
+		By:      rec[2],
+		What:    rec[3],
+		Details: rec[4],
+		Time:    time.Unix(timeSecs, 0),
+	})
+	return nil
+}
+
+// WriteUpload records an upload
+func (s *StoreCsv) WriteUpload(by, what, details string) error {
+	s.Lock()
+	defer s.Unlock()
+	t := time.Now()
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	if err := s.writeCsv([]string{recIDUpload, timeStr, by, what, details}); err != nil {
+		return err
+	}
+	s.uploads = append(s.uploads, Upload{By: by, What: what, Details: details, Time: t})
+	return nil
+}
+
+// RecentUploads returns up to max most recent uploads, newest first
+func (s *StoreCsv) RecentUploads(max int) []Upload {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]Upload, 0)
+	for i := len(s.uploads) - 1; i >= 0 && len(res) < max; i-- {
+		res = append(res, s.uploads[i])
+	}
+	return res
+}
@@ -0,0 +1,26 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUploads(t *testing.T) {
+	path := "uploadstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	fatalIfErr(s.WriteUpload("token:1", "strings", "3 strings"))
+	fatalIfErr(s.WriteUpload("token:2", "screenshot", "1.png"))
+	s.Close()
+
+	s = NewTestStore(path)
+	defer s.Close()
+	uploads := s.RecentUploads(10)
+	fatalIf(len(uploads) != 2, "len(uploads) = %d, exp: 2", len(uploads))
+	fatalIf(uploads[0].By != "token:2" || uploads[0].Details != "1.png", "unexpected uploads[0]: %#v", uploads[0])
+	uploads = s.RecentUploads(1)
+	fatalIf(len(uploads) != 1 || uploads[0].By != "token:2", "unexpected uploads: %#v", uploads)
+}
@@ -0,0 +1,209 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/* csv records:
+
+pref, ${timeUnix}, ${user}, ${name}, ${value}
+acc, ${timeUnix}, ${name}, ${email}, ${passwordHash}, ${verified}
+role, ${timeUnix}, ${grantedBy}, ${user}, ${role}, ${scope}, ${granted}
+sess, ${timeUnix}, ${id}, ${user}, ${active}
+tok, ${timeUnix}, ${id}, ${app}, ${name}, ${scopes}, ${hash}, ${createdBy}
+tokrev, ${timeUnix}, ${id}, ${revokedBy}
+tokused, ${timeUnix}, ${id}
+hook, ${timeUnix}, ${id}, ${app}, ${url}, ${events}, ${secret}, ${progress}, ${createdBy}
+hookdel, ${timeUnix}, ${id}, ${deletedBy}
+sub, ${timeUnix}, ${id}, ${user}, ${email}, ${app}, ${lang}, ${frequency}, ${confirmed}
+subconf, ${timeUnix}, ${id}
+subsent, ${timeUnix}, ${id}
+subdel, ${timeUnix}, ${id}
+
+*/
+const (
+	recIDPref    = "pref"
+	recIDAccount = "acc"
+	recIDRole    = "role"
+	recIDSession = "sess"
+
+	recIDToken        = "tok"
+	recIDTokenRevoked = "tokrev"
+	recIDTokenUsed    = "tokused"
+
+	recIDWebhook        = "hook"
+	recIDWebhookDeleted = "hookdel"
+
+	recIDSubscription          = "sub"
+	recIDSubscriptionConfirmed = "subconf"
+	recIDSubscriptionSent      = "subsent"
+	recIDSubscriptionDeleted   = "subdel"
+)
+
+// UserStore stores information about users that is not specific to any
+// app, like their preferences, local accounts, roles, sessions, API
+// tokens, webhooks and email subscriptions
+type UserStore struct {
+	sync.Mutex
+	filePath string
+	file     *os.File
+	w        *csv.Writer
+	// maps user to a map of preference name to value
+	prefs           map[string]map[string]string
+	accounts        map[string]*Account
+	accountsByEmail map[string]*Account
+	roles           map[roleKey]*Role
+	sessions        map[string]*Session
+	tokens          map[string]*APIToken
+	tokensByHash    map[string]*APIToken
+	webhooks        map[string]*Webhook
+	subscriptions   map[string]*Subscription
+}
+
+// NewUserStore creates new user store using .csv for encoding
+func NewUserStore(path string) (*UserStore, error) {
+	var err error
+	s := &UserStore{
+		filePath: path,
+		prefs:           make(map[string]map[string]string),
+		accounts:        make(map[string]*Account),
+		accountsByEmail: make(map[string]*Account),
+		roles:           make(map[roleKey]*Role),
+		sessions:        make(map[string]*Session),
+		tokens:          make(map[string]*APIToken),
+		tokensByHash:    make(map[string]*APIToken),
+		webhooks:        make(map[string]*Webhook),
+		subscriptions:   make(map[string]*Subscription),
+	}
+	if u.PathExists(path) {
+		if err = s.readExistingRecords(path); err != nil {
+			return nil, err
+		}
+	}
+	if s.file, s.w, err = openCsv(path); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *UserStore) writeCsv(rec []string) error {
+	recs := [][]string{rec}
+	return s.w.WriteAll(recs)
+}
+
+func (s *UserStore) setPref(user, name, value string) {
+	m := s.prefs[user]
+	if m == nil {
+		m = make(map[string]string)
+		s.prefs[user] = m
+	}
+	if value == "" {
+		delete(m, name)
+	} else {
+		m[name] = value
+	}
+}
+
+// pref, ${timeUnix}, ${user}, ${name}, ${value}
+func (s *UserStore) decodePrefRecord(rec []string) error {
+	if len(rec) != 5 {
+		return fmt.Errorf("'pref' record should have 5 fields, is '%#v'", rec)
+	}
+	s.setPref(rec[2], rec[3], rec[4])
+	return nil
+}
+
+func (s *UserStore) decodeRecord(rec []string) error {
+	if len(rec) < 2 {
+		return fmt.Errorf("not enough fields (%d) in %#v", len(rec), rec)
+	}
+	var err error
+	switch rec[0] {
+	case recIDPref:
+		err = s.decodePrefRecord(rec)
+	case recIDAccount:
+		err = s.decodeAccountRecord(rec)
+	case recIDRole:
+		err = s.decodeRoleRecord(rec)
+	case recIDSession:
+		err = s.decodeSessionRecord(rec)
+	case recIDToken:
+		err = s.decodeTokenRecord(rec)
+	case recIDTokenRevoked:
+		err = s.decodeTokenRevokedRecord(rec)
+	case recIDTokenUsed:
+		err = s.decodeTokenUsedRecord(rec)
+	case recIDWebhook:
+		err = s.decodeWebhookRecord(rec)
+	case recIDWebhookDeleted:
+		err = s.decodeWebhookDeletedRecord(rec)
+	case recIDSubscription:
+		err = s.decodeSubscriptionRecord(rec)
+	case recIDSubscriptionConfirmed, recIDSubscriptionSent, recIDSubscriptionDeleted:
+		err = s.decodeSubscriptionChangeRecord(rec)
+	default:
+		err = fmt.Errorf("unkown record type %q", rec[0])
+	}
+	return err
+}
+
+func (s *UserStore) readExistingRecords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = s.decodeRecord(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the store
+func (s *UserStore) Close() {
+	s.w.Flush()
+	s.file.Close()
+	s.file = nil
+}
+
+// GetPref returns the value of user's preference or "" if not set
+func (s *UserStore) GetPref(user, name string) string {
+	s.Lock()
+	defer s.Unlock()
+	return s.prefs[user][name]
+}
+
+// SetPref sets the value of user's preference. Empty value removes it
+func (s *UserStore) SetPref(user, name, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.prefs[user][name] == value {
+		return nil
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	rec := []string{recIDPref, timeStr, user, name, value}
+	if err := s.writeCsv(rec); err != nil {
+		return err
+	}
+	s.setPref(user, name, value)
+	return nil
+}
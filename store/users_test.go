@@ -0,0 +1,200 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func NewTestUserStore(path string) *UserStore {
+	s, err := NewUserStore(path)
+	fatalIf(err != nil, "Failed to create new %s", path)
+	return s
+}
+
+func (s *UserStore) ensurePref(user, name, exp string) {
+	got := s.GetPref(user, name)
+	fatalIf(got != exp, "GetPref(%q, %q) = %q, exp: %q", user, name, got, exp)
+}
+
+func TestUserPrefs(t *testing.T) {
+	path := "userstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	s.ensurePref("user1", "reflang-ca-xv", "")
+	fatalIfErr(s.SetPref("user1", "reflang-ca-xv", "ca"))
+	fatalIfErr(s.SetPref("user2", "reflang-br", "pt"))
+	fatalIfErr(s.SetPref("user2", "reflang-br", "es"))
+	s.ensurePref("user1", "reflang-ca-xv", "ca")
+	s.ensurePref("user2", "reflang-br", "es")
+	s.Close()
+
+	s = NewTestUserStore(path)
+	s.ensurePref("user1", "reflang-ca-xv", "ca")
+	s.ensurePref("user2", "reflang-br", "es")
+	fatalIfErr(s.SetPref("user1", "reflang-ca-xv", ""))
+	s.Close()
+
+	s = NewTestUserStore(path)
+	s.ensurePref("user1", "reflang-ca-xv", "")
+	s.Close()
+}
+
+func (s *UserStore) ensureAccount(name, email, hash string, verified bool) {
+	acc := s.GetAccount(name)
+	fatalIf(acc == nil, "GetAccount(%q) returned nil", name)
+	fatalIf(acc.Email != email, "acc.Email = %q, exp: %q", acc.Email, email)
+	fatalIf(acc.PasswordHash != hash, "acc.PasswordHash = %q, exp: %q", acc.PasswordHash, hash)
+	fatalIf(acc.Verified != verified, "acc.Verified = %v, exp: %v", acc.Verified, verified)
+	acc2 := s.AccountByEmail(email)
+	fatalIf(acc2 == nil || acc2.Name != name, "AccountByEmail(%q) = %v, exp: %q", email, acc2, name)
+}
+
+func TestAccounts(t *testing.T) {
+	path := "accountstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	fatalIf(s.GetAccount("alice") != nil, "account 'alice' shouldn't exist")
+	fatalIfErr(s.CreateAccount("alice", "Alice@example.com", "hash1"))
+	fatalIfErr(s.CreateAccount("bob", "bob@example.com", "hash2"))
+	fatalIf(s.CreateAccount("alice", "alice2@example.com", "hash") == nil, "duplicate name should fail")
+	fatalIf(s.CreateAccount("alice2", "alice@EXAMPLE.com", "hash") == nil, "duplicate email should fail")
+	s.ensureAccount("alice", "Alice@example.com", "hash1", false)
+	fatalIfErr(s.SetAccountVerified("alice"))
+	fatalIfErr(s.SetAccountPassword("bob", "hash3"))
+	fatalIf(s.SetAccountPassword("carol", "hash") == nil, "SetAccountPassword of missing account should fail")
+	s.Close()
+
+	s = NewTestUserStore(path)
+	s.ensureAccount("alice", "Alice@example.com", "hash1", true)
+	s.ensureAccount("bob", "bob@example.com", "hash3", false)
+	fatalIf(s.AccountByEmail("carol@example.com") != nil, "unexpected account")
+	s.Close()
+}
+
+func TestRoles(t *testing.T) {
+	path := "rolestest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	fatalIfErr(s.GrantRole("kjk", "alice", RoleMaintainer, "SumatraPDF/pl"))
+	fatalIfErr(s.GrantRole("kjk", "bob", RoleBanned, ""))
+	fatalIfErr(s.GrantRole("kjk", "bob", RoleHidden, ""))
+	fatalIfErr(s.GrantRole("kjk", "carol", RoleAdmin, ""))
+	fatalIf(s.GrantRole("kjk", "carol", "superuser", "") == nil, "invalid role should fail")
+	fatalIfErr(s.RevokeRole("kjk", "bob", RoleHidden, ""))
+	fatalIfErr(s.RevokeRole("kjk", "dave", RoleBanned, ""))
+	s.Close()
+
+	s = NewTestUserStore(path)
+	fatalIf(!s.HasRole("alice", RoleMaintainer, "SumatraPDF/pl"), "alice should be a maintainer")
+	fatalIf(s.HasRole("alice", RoleMaintainer, "SumatraPDF/de"), "alice shouldn't be a de maintainer")
+	fatalIf(!s.HasRole("bob", RoleBanned, ""), "bob should be banned")
+	fatalIf(s.HasRole("bob", RoleHidden, ""), "bob shouldn't be hidden")
+	roles := s.Roles()
+	fatalIf(len(roles) != 3, "len(roles) = %d, exp: 3", len(roles))
+	fatalIf(roles[0].Role != RoleAdmin || roles[0].User != "carol", "unexpected roles[0]: %#v", roles[0])
+	banned := s.UsersWithRole(RoleBanned)
+	fatalIf(len(banned) != 1 || banned[0] != "bob", "unexpected banned users %v", banned)
+	s.Close()
+}
+
+func TestSessions(t *testing.T) {
+	path := "sessionstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	_, err := s.CreateSession("s1", "alice")
+	fatalIfErr(err)
+	_, err = s.CreateSession("s2", "alice")
+	fatalIfErr(err)
+	_, err = s.CreateSession("s3", "bob")
+	fatalIfErr(err)
+	_, err = s.CreateSession("s3", "bob")
+	fatalIf(err == nil, "duplicate session should fail")
+	fatalIfErr(s.RevokeSession("s3"))
+	fatalIf(s.RevokeSession("s4") == nil, "revoking unknown session should fail")
+	s.Close()
+
+	s = NewTestUserStore(path)
+	fatalIf(s.GetSession("s1") == nil || s.GetSession("s1").User != "alice", "s1 should be alice's session")
+	fatalIf(!s.GetSession("s3").Revoked, "s3 should be revoked")
+	fatalIf(s.GetSession("s4") != nil, "s4 shouldn't exist")
+	active := s.ActiveSessions(time.Time{})
+	fatalIf(len(active) != 2, "len(active) = %d, exp: 2", len(active))
+	fatalIf(len(s.ActiveSessions(time.Now().Add(time.Hour))) != 0, "sessions should be expired")
+	n, err := s.RevokeUserSessions("alice")
+	fatalIfErr(err)
+	fatalIf(n != 2, "revoked %d sessions, exp: 2", n)
+	fatalIf(len(s.ActiveSessions(time.Time{})) != 0, "all sessions should be revoked")
+	s.Close()
+}
+
+func TestAPITokens(t *testing.T) {
+	path := "tokenstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	_, err := s.CreateAPIToken("t1", "SumatraPDF", "ci", []string{ScopeUploadStrings}, "hash1", "kjk")
+	fatalIfErr(err)
+	_, err = s.CreateAPIToken("t2", "SumatraPDF", "all", []string{ScopeAdmin}, "hash2", "kjk")
+	fatalIfErr(err)
+	_, err = s.CreateAPIToken("t3", "SumatraPDF", "bad", []string{"write"}, "hash3", "kjk")
+	fatalIf(err == nil, "invalid scope should fail")
+	_, err = s.CreateAPIToken("t3", "SumatraPDF", "", []string{ScopeDownload}, "hash3", "kjk")
+	fatalIf(err == nil, "empty name should fail")
+	now := time.Now()
+	fatalIfErr(s.MarkAPITokenUsed("t1", now))
+	fatalIfErr(s.RevokeAPIToken("t2", "kjk"))
+	s.Close()
+
+	s = NewTestUserStore(path)
+	tok := s.APITokenByHash("hash1")
+	fatalIf(tok == nil || tok.ID != "t1", "token with hash1 should be t1")
+	fatalIf(!tok.HasScope(ScopeUploadStrings) || tok.HasScope(ScopeDownload), "unexpected scopes %v", tok.Scopes)
+	fatalIf(tok.LastUsed.Unix() != now.Unix(), "unexpected last used %s", tok.LastUsed)
+	tok = s.GetAPIToken("t2")
+	fatalIf(!tok.Revoked || !tok.HasScope(ScopeImport), "t2 should be revoked admin token")
+	tokens := s.APITokens("SumatraPDF")
+	fatalIf(len(tokens) != 1 || tokens[0].ID != "t1", "unexpected tokens %#v", tokens)
+	fatalIf(len(s.APITokens("Other")) != 0, "Other app shouldn't have tokens")
+	s.Close()
+}
+
+func TestWebhooks(t *testing.T) {
+	path := "webhookstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestUserStore(path)
+	events := []string{EventTranslationAdded, EventLangProgress}
+	_, err := s.CreateWebhook("h1", "SumatraPDF", "https://example.com/hook", events, "sec1", 90, "kjk")
+	fatalIfErr(err)
+	_, err = s.CreateWebhook("h2", "SumatraPDF", "http://localhost:8080", []string{EventCommentPosted}, "sec2", 100, "kjk")
+	fatalIfErr(err)
+	_, err = s.CreateWebhook("h3", "SumatraPDF", "ftp://example.com", events, "sec3", 100, "kjk")
+	fatalIf(err == nil, "invalid url should fail")
+	_, err = s.CreateWebhook("h3", "SumatraPDF", "https://example.com", []string{"push"}, "sec3", 100, "kjk")
+	fatalIf(err == nil, "invalid event should fail")
+	_, err = s.CreateWebhook("h3", "SumatraPDF", "https://example.com", events, "sec3", 0, "kjk")
+	fatalIf(err == nil, "invalid progress should fail")
+	fatalIfErr(s.DeleteWebhook("h2", "kjk"))
+	s.Close()
+
+	s = NewTestUserStore(path)
+	h := s.GetWebhook("h1")
+	fatalIf(h == nil || h.Secret != "sec1" || h.Progress != 90, "unexpected webhook %#v", h)
+	fatalIf(!h.HasEvent(EventLangProgress) || h.HasEvent(EventCommentPosted), "unexpected events %v", h.Events)
+	fatalIf(!s.GetWebhook("h2").Deleted, "h2 should be deleted")
+	hooks := s.Webhooks("SumatraPDF")
+	fatalIf(len(hooks) != 1 || hooks[0].ID != "h1", "unexpected webhooks %#v", hooks)
+	s.Close()
+}
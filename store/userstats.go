@@ -0,0 +1,139 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import "time"
+
+// Edits are indexed by user and by string and language, so that profiles of
+// users don't have to scan all edits. Both are positions in s.edits, oldest
+// first. Hidden users are filtered when reading.
+
+// UserEdit is an edit by a user
+type UserEdit struct {
+	Edit
+	// false if the translation was changed by a later edit
+	Current bool
+}
+
+// UserStats summarizes contributions of a user
+type UserStats struct {
+	Edits int
+	// number of strings (in a given language) the user translated
+	Translations int
+	// number of those that still have the user's translation. The rest were
+	// overwritten or reverted
+	Current int
+	// number of edits per language code
+	Langs       map[string]int
+	First, Last time.Time
+	// number of edits per day, keyed by midnight UTC
+	Days map[time.Time]int
+}
+
+// Overwritten returns number of user's translations that were changed
+func (s *UserStats) Overwritten() int {
+	return s.Translations - s.Current
+}
+
+func (s *StoreCsv) indexEdit(i int) {
+	tr := &s.edits[i]
+	s.userEdits[tr.userID] = append(s.userEdits[tr.userID], i)
+	k := transKey{tr.stringID, tr.langID}
+	s.keyEdits[k] = append(s.keyEdits[k], i)
+}
+
+// userEditIndexes returns positions of edits by user, oldest first
+func (s *StoreCsv) userEditIndexes(user string) []int {
+	userID, ok := s.users.strToId[user]
+	if !ok || s.isHiddenUser(userID) {
+		return nil
+	}
+	return s.userEdits[userID]
+}
+
+func (s *StoreCsv) editAt(i int) Edit {
+	tr := &s.edits[i]
+	return Edit{
+		ID:          i,
+		Lang:        s.langByID(tr.langID),
+		User:        s.userByID(tr.userID),
+		Text:        s.stringByIDMust(tr.stringID),
+		Translation: tr.translation,
+		Time:        tr.time,
+	}
+}
+
+// isCurrentEdit returns true if edit i is the latest visible edit of its
+// string in its language
+func (s *StoreCsv) isCurrentEdit(i int) bool {
+	tr := &s.edits[i]
+	idxs := s.keyEdits[transKey{tr.stringID, tr.langID}]
+	for j := len(idxs) - 1; j >= 0; j-- {
+		if idxs[j] == i {
+			return true
+		}
+		if !s.isHiddenEdit(&s.edits[idxs[j]]) {
+			return false
+		}
+	}
+	return false
+}
+
+func (s *StoreCsv) userStats(user string) *UserStats {
+	res := &UserStats{
+		Langs: make(map[string]int),
+		Days:  make(map[time.Time]int),
+	}
+	idxs := s.userEditIndexes(user)
+	translated := make(map[transKey]bool)
+	for _, i := range idxs {
+		tr := &s.edits[i]
+		res.Langs[s.langByID(tr.langID)]++
+		res.Days[startOfDay(tr.time)]++
+		if tr.translation == "" {
+			continue
+		}
+		k := transKey{tr.stringID, tr.langID}
+		if !translated[k] {
+			translated[k] = true
+			res.Translations++
+		}
+	}
+	// only the user's latest edit of a string can be the current one
+	latest := make(map[transKey]int)
+	for _, i := range idxs {
+		tr := &s.edits[i]
+		latest[transKey{tr.stringID, tr.langID}] = i
+	}
+	for _, i := range latest {
+		if s.edits[i].translation != "" && s.isCurrentEdit(i) {
+			res.Current++
+		}
+	}
+	res.Edits = len(idxs)
+	if len(idxs) > 0 {
+		res.First = s.edits[idxs[0]].time
+		res.Last = s.edits[idxs[len(idxs)-1]].time
+	}
+	return res
+}
+
+// UserStats returns a summary of edits by user
+func (s *StoreCsv) UserStats(user string) *UserStats {
+	s.Lock()
+	defer s.Unlock()
+	return s.userStats(user)
+}
+
+// UserEdits returns up to max edits by user, newest first, skipping the
+// newest offset ones, and the number of all edits by user
+func (s *StoreCsv) UserEdits(user string, offset, max int) ([]UserEdit, int) {
+	s.Lock()
+	defer s.Unlock()
+	idxs := s.userEditIndexes(user)
+	res := make([]UserEdit, 0)
+	for n := len(idxs) - 1 - offset; n >= 0 && len(res) < max; n-- {
+		i := idxs[n]
+		res = append(res, UserEdit{Edit: s.editAt(i), Current: s.isCurrentEdit(i)})
+	}
+	return res, len(idxs)
+}
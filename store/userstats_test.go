@@ -0,0 +1,50 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUserStats(t *testing.T) {
+	path := "userstatstest.dat"
+	os.Remove(path) // just in case
+	defer os.Remove(path)
+
+	s := NewTestStore(path)
+	s.updateStringsListMust([]string{"foo", "bar", "baz"})
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-1", "pl", "alice"))
+	fatalIfErr(s.WriteNewTranslation("bar", "bar-1", "pl", "alice"))
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-de", "de", "alice"))
+	fatalIfErr(s.WriteNewTranslation("foo", "foo-2", "pl", "bob"))
+	fatalIfErr(s.WriteNewTranslation("baz", "baz-1", "pl", "alice"))
+	_, err := s.RevertTranslation("baz", "pl", "carol")
+	fatalIfErr(err)
+
+	st := s.UserStats("alice")
+	fatalIf(st.Edits != 4 || st.Translations != 4, "unexpected stats %#v", st)
+	// foo/pl was overwritten by bob and baz/pl reverted by carol
+	fatalIf(st.Current != 2 || st.Overwritten() != 2, "unexpected stats %#v", st)
+	fatalIf(st.Langs["pl"] != 3 || st.Langs["de"] != 1, "unexpected langs %#v", st.Langs)
+	fatalIf(st.First.IsZero() || st.Last.Before(st.First) || len(st.Days) != 1, "unexpected times %#v", st)
+	fatalIf(s.UserStats("nobody").Edits != 0, "stats of unknown user")
+
+	edits, total := s.UserEdits("alice", 0, 2)
+	fatalIf(total != 4 || len(edits) != 2, "UserEdits() returned %d of %d", len(edits), total)
+	fatalIf(edits[0].Text != "baz" || edits[0].Current || edits[1].Text != "foo" || !edits[1].Current, "unexpected edits %#v", edits)
+	edits, _ = s.UserEdits("alice", 3, 2)
+	fatalIf(len(edits) != 1 || edits[0].Text != "foo" || edits[0].Lang != "pl" || edits[0].Current, "unexpected last page %#v", edits)
+	fatalIf(len(s.EditsByUser("alice")) != 4, "EditsByUser() should use the same index")
+
+	// bob's edit no longer overwrites alice's when bob is hidden
+	fatalIfErr(s.SetHiddenUsers([]string{"bob"}))
+	fatalIf(s.UserStats("alice").Current != 3, "hidden edits shouldn't overwrite")
+	fatalIf(s.UserStats("bob").Edits != 0, "hidden user should have no edits")
+	s.Close()
+
+	// indexes are rebuilt when reading the log
+	s = NewTestStore(path)
+	defer s.Close()
+	st2 := s.UserStats("alice")
+	fatalIf(st2.Edits != 4 || st2.Current != 3, "unexpected stats after reopening %#v", st2)
+}
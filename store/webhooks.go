@@ -0,0 +1,192 @@
+// This code is under BSD license. See license-bsd.txt
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// events that webhooks can subscribe to
+const (
+	EventTranslationAdded    = "translation.added"
+	EventTranslationReverted = "translation.reverted"
+	// a language reached webhook's progress threshold
+	EventLangProgress    = "lang.progress"
+	EventStringsUploaded = "strings.uploaded"
+	EventCommentPosted   = "comment.posted"
+)
+
+// WebhookEvents lists all events webhooks can subscribe to
+var WebhookEvents = []string{EventTranslationAdded, EventTranslationReverted, EventLangProgress, EventStringsUploaded, EventCommentPosted}
+
+// IsValidWebhookEvent returns true if event is one of WebhookEvents
+func IsValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook describes a url of an app that is notified about events. Payloads
+// are signed with Secret
+type Webhook struct {
+	ID     string
+	App    string
+	URL    string
+	Events []string
+	Secret string
+	// percentage of translated strings for EventLangProgress
+	Progress  int
+	CreatedBy string
+	Created   time.Time
+	Deleted   bool
+}
+
+// HasEvent returns true if webhook is subscribed to event
+func (h *Webhook) HasEvent(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Webhook) copy() *Webhook {
+	res := *h
+	res.Events = append([]string(nil), h.Events...)
+	return &res
+}
+
+// hook, ${timeUnix}, ${id}, ${app}, ${url}, ${events}, ${secret}, ${progress}, ${createdBy}
+func (s *UserStore) decodeWebhookRecord(rec []string) error {
+	if len(rec) != 9 {
+		return fmt.Errorf("'hook' record should have 9 fields, is '%#v'", rec)
+	}
+	timeSecs, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("rec[1] (%q) failed to parse as int64, error: %q", rec[1], err)
+	}
+	progress, err := strconv.Atoi(rec[7])
+	if err != nil {
+		return fmt.Errorf("rec[7] (%q) failed to parse as int, error: %q", rec[7], err)
+	}
+	s.webhooks[rec[2]] = &Webhook{
+		ID:        rec[2],
+		App:       rec[3],
+		URL:       rec[4],
+		Events:    strings.Fields(rec[5]),
+		Secret:    rec[6],
+		Progress:  progress,
+		CreatedBy: rec[8],
+		Created:   time.Unix(timeSecs, 0),
+	}
+	return nil
+}
+
+// hookdel, ${timeUnix}, ${id}, ${deletedBy}
+func (s *UserStore) decodeWebhookDeletedRecord(rec []string) error {
+	if len(rec) != 4 {
+		return fmt.Errorf("'hookdel' record should have 4 fields, is '%#v'", rec)
+	}
+	h, ok := s.webhooks[rec[2]]
+	if !ok {
+		return fmt.Errorf("rec[2] (%q) is not a valid webhook id", rec[2])
+	}
+	h.Deleted = true
+	return nil
+}
+
+// CreateWebhook records a new webhook for app
+func (s *UserStore) CreateWebhook(id, app, url string, events []string, secret string, progress int, by string) (*Webhook, error) {
+	s.Lock()
+	defer s.Unlock()
+	if id == "" || secret == "" {
+		return nil, fmt.Errorf("empty webhook id or secret")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%q is not a valid http or https url", url)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("webhook must have at least one event")
+	}
+	for _, event := range events {
+		if !IsValidWebhookEvent(event) {
+			return nil, fmt.Errorf("%q is not a valid event", event)
+		}
+	}
+	if progress < 1 || progress > 100 {
+		return nil, fmt.Errorf("progress must be between 1 and 100, is %d", progress)
+	}
+	if _, exists := s.webhooks[id]; exists {
+		return nil, fmt.Errorf("webhook %q already exists", id)
+	}
+	t := time.Now()
+	timeStr := strconv.FormatInt(t.Unix(), 10)
+	rec := []string{recIDWebhook, timeStr, id, app, url, strings.Join(events, " "), secret, strconv.Itoa(progress), by}
+	if err := s.writeCsv(rec); err != nil {
+		return nil, err
+	}
+	h := &Webhook{
+		ID:        id,
+		App:       app,
+		URL:       url,
+		Events:    append([]string(nil), events...),
+		Secret:    secret,
+		Progress:  progress,
+		CreatedBy: by,
+		Created:   t,
+	}
+	s.webhooks[id] = h
+	return h.copy(), nil
+}
+
+// GetWebhook returns a copy of a webhook or nil if it doesn't exist
+func (s *UserStore) GetWebhook(id string) *Webhook {
+	s.Lock()
+	defer s.Unlock()
+	if h, ok := s.webhooks[id]; ok {
+		return h.copy()
+	}
+	return nil
+}
+
+// Webhooks returns webhooks of app that were not deleted, oldest first
+func (s *UserStore) Webhooks(app string) []*Webhook {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]*Webhook, 0)
+	for _, h := range s.webhooks {
+		if h.App == app && !h.Deleted {
+			res = append(res, h.copy())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+// DeleteWebhook deletes a webhook
+func (s *UserStore) DeleteWebhook(id, by string) error {
+	s.Lock()
+	defer s.Unlock()
+	h, ok := s.webhooks[id]
+	if !ok {
+		return fmt.Errorf("webhook %q doesn't exist", id)
+	}
+	if h.Deleted {
+		return nil
+	}
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.writeCsv([]string{recIDWebhookDeleted, timeStr, id, by}); err != nil {
+		return err
+	}
+	h.Deleted = true
+	return nil
+}
@@ -2,25 +2,54 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"text/template"
 )
 
 var (
-	tmplMain      = "main.html"
-	tmplApp       = "app.html"
-	tmplAppTrans  = "apptrans.html"
-	tmplUser      = "user.html"
-	tmplLogs      = "logs.html"
-	templateNames = [...]string{
-		tmplMain, tmplApp, tmplAppTrans, tmplUser, tmplLogs, "header.html",
-		"footer.html"}
+	tmplMain             = "main.html"
+	tmplApp              = "app.html"
+	tmplAppTrans         = "apptrans.html"
+	tmplUser             = "user.html"
+	tmplLogs             = "logs.html"
+	tmplSearch           = "search.html"
+	tmplTranslate        = "translate.html"
+	tmplComments         = "comments.html"
+	tmplLogin            = "login.html"
+	tmplLocalAccount     = "localaccount.html"
+	tmplAdmin            = "admin.html"
+	tmplTokens           = "tokens.html"
+	tmplWebhooks         = "webhooks.html"
+	tmplSubscriptions    = "subscriptions.html"
+	tmplUnsubscribe      = "unsubscribe.html"
+	tmplSubscribeConfirm = "subscribeconfirm.html"
+	templateNames        = [...]string{
+		tmplMain, tmplApp, tmplAppTrans, tmplUser, tmplLogs, tmplSearch,
+		tmplTranslate, tmplComments, tmplLogin, tmplLocalAccount, tmplAdmin, tmplTokens,
+		tmplWebhooks, tmplSubscriptions, tmplUnsubscribe, tmplSubscribeConfirm,
+		"header.html", "footer.html"}
+	templateFuncs = template.FuncMap{
+		"thumbnail":  thumbnailFileName,
+		"profileurl": userProfileURL,
+		"logoutform": logoutForm,
+	}
 	templatePaths   []string
 	templates       *template.Template
 	reloadTemplates = true
 )
 
+// logoutForm returns html of a logout link. Logging out changes state, so
+// it must be a POST with CSRF token
+func logoutForm(csrf, redirect string) string {
+	return fmt.Sprintf(`<form action="/logout" method="POST" style="display:inline;margin:0">`+
+		`<input type="hidden" name="csrf" value="%s">`+
+		`<input type="hidden" name="redirect" value="%s">`+
+		`<a href="#" onclick="this.parentNode.submit();return false;">logout</a></form>`,
+		template.HTMLEscapeString(csrf), template.HTMLEscapeString(redirect))
+}
+
 func GetTemplates() *template.Template {
 	if reloadTemplates || (nil == templates) {
 		if 0 == len(templatePaths) {
@@ -28,7 +57,7 @@ func GetTemplates() *template.Template {
 				templatePaths = append(templatePaths, filepath.Join("tmpl", name))
 			}
 		}
-		templates = template.Must(template.ParseFiles(templatePaths...))
+		templates = template.Must(template.New("").Funcs(templateFuncs).ParseFiles(templatePaths...))
 	}
 	return templates
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -54,6 +55,29 @@ func httpErrorf(w http.ResponseWriter, format string, args ...interface{}) {
 	http.Error(w, msg, http.StatusBadRequest)
 }
 
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	serveJSONWithCode(w, http.StatusOK, v)
+}
+
+func serveJSONWithCode(w http.ResponseWriter, code int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		logger.Errorf("json.Marshal() failed with %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(d)
+}
+
+func serveJSONError(w http.ResponseWriter, code int, msg string) {
+	// errors must not be cached with the ETag of a valid response
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	serveJSONWithCode(w, code, map[string]string{"error": msg})
+}
+
 func sha1OfFile(path string) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -87,3 +111,10 @@ func sha1OfBytes(data []byte) []byte {
 	h.Write(data)
 	return h.Sum(nil)
 }
+
+func strOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,493 @@
+// This code is under BSD license. See license-bsd.txt
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/kjk/apptranslator/store"
+)
+
+// Webhooks notify urls of an app about events, so that e.g. a build system
+// doesn't have to poll /dltrans. We POST json-encoded WebhookPayload,
+// signed with webhook's secret:
+//
+//   X-AppTranslator-Signature: sha256=${hex of hmac-sha256(secret, body)}
+//
+// Failed deliveries are retried with exponential backoff. Recent deliveries
+// are kept in memory and shown to app admins on /admin/webhooks.
+//
+// Webhook urls are given by app admins, so to not let them probe our internal
+// network, we refuse to connect to loopback, private and link-local addresses.
+// It's checked when connecting (after resolving the host) and redirects are
+// not followed.
+
+// eventPing is sent when admin tests a webhook
+const eventPing = "ping"
+
+const (
+	webhookMaxAttempts = 5
+	// how many recent deliveries we remember per app
+	maxWebhookDeliveries = 100
+)
+
+var (
+	// delay before the first retry, doubled after each failed attempt
+	webhookRetryDelay = 5 * time.Second
+	webhookClient     = newWebhookClient()
+	// tests deliver webhooks to local servers
+	webhookAllowLocal = false
+	webhookLog        = &webhookDeliveryLog{deliveries: make(map[string][]*WebhookDelivery)}
+)
+
+// WebhookPayload is sent as json body to webhook's url
+type WebhookPayload struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	App   string      `json:"app"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// WebhookDelivery describes sending of an event to a webhook
+type WebhookDelivery struct {
+	ID        string
+	WebhookID string
+	URL       string
+	Event     string
+	Created   time.Time
+	Attempts  int
+	// http status of the last attempt, 0 if the request failed
+	Status    int
+	Error     string
+	Delivered bool
+	// true while there are retries left
+	Pending bool
+}
+
+type webhookDeliveryLog struct {
+	sync.Mutex
+	// maps app name to recent deliveries, oldest first
+	deliveries map[string][]*WebhookDelivery
+}
+
+func (l *webhookDeliveryLog) add(app string, d *WebhookDelivery) {
+	l.Lock()
+	defer l.Unlock()
+	a := append(l.deliveries[app], d)
+	if len(a) > maxWebhookDeliveries {
+		a = a[len(a)-maxWebhookDeliveries:]
+	}
+	l.deliveries[app] = a
+}
+
+func (l *webhookDeliveryLog) update(d *WebhookDelivery, fn func(d *WebhookDelivery)) {
+	l.Lock()
+	defer l.Unlock()
+	fn(d)
+}
+
+// recent returns copies of recent deliveries of app, newest first
+func (l *webhookDeliveryLog) recent(app string) []*WebhookDelivery {
+	l.Lock()
+	defer l.Unlock()
+	a := l.deliveries[app]
+	res := make([]*WebhookDelivery, 0, len(a))
+	for i := len(a) - 1; i >= 0; i-- {
+		d := *a[i]
+		res = append(res, &d)
+	}
+	return res
+}
+
+var webhookPrivateNets = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	// carrier-grade NAT
+	"100.64.0.0/10",
+	// ipv6 unique local addresses
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var res []*net.IPNet
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// isWebhookIPAllowed returns false for addresses on our machine or
+// internal network
+func isWebhookIPAllowed(ip net.IP) bool {
+	if webhookAllowLocal {
+		return true
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range webhookPrivateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookDialControl is called after resolving the host, right before
+// connecting, so it also catches hosts that resolve to internal addresses
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isWebhookIPAllowed(ip) {
+		return fmt.Errorf("webhooks can't be delivered to %s", host)
+	}
+	return nil
+}
+
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: webhookDialControl,
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		// no proxy, we must be the one connecting to the webhook
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// redirect could point to internal address, so we don't follow it
+		// and a delivery that got redirected fails
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkWebhookURL rejects urls that point to internal addresses. Host names
+// can resolve to anything so they're checked again when delivering
+func checkWebhookURL(hookURL string) error {
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if !webhookAllowLocal && strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("webhooks can't be delivered to %s", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isWebhookIPAllowed(ip) {
+		return fmt.Errorf("webhooks can't be delivered to %s", host)
+	}
+	return nil
+}
+
+func newWebhookSecret() string {
+	return fmt.Sprintf("%x", securecookie.GenerateRandomKey(20))
+}
+
+// webhookSignature returns value of X-AppTranslator-Signature header
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// postWebhook makes a single delivery attempt and returns http status
+func postWebhook(h *store.Webhook, d *WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AppTranslator-Webhook")
+	req.Header.Set("X-AppTranslator-Event", d.Event)
+	req.Header.Set("X-AppTranslator-Delivery", d.ID)
+	req.Header.Set("X-AppTranslator-Signature", webhookSignature(h.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func sendWebhook(h *store.Webhook, d *WebhookDelivery, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		status, err := postWebhook(h, d, body)
+		webhookLog.update(d, func(d *WebhookDelivery) {
+			d.Attempts = attempt
+			d.Status = status
+			d.Error = ""
+			if err != nil {
+				d.Error = err.Error()
+			}
+			d.Delivered = err == nil
+			d.Pending = err != nil && attempt < webhookMaxAttempts
+		})
+		if err == nil {
+			return
+		}
+		logger.Noticef("Delivery %s of %s to %s failed (attempt %d): %s", d.ID, d.Event, h.URL, attempt, err)
+		if attempt < webhookMaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	logger.Errorf("Giving up on delivery %s of %s to %s", d.ID, d.Event, h.URL)
+}
+
+// deliverWebhook sends event to a webhook in the background
+func deliverWebhook(h *store.Webhook, event string, data interface{}) *WebhookDelivery {
+	d := &WebhookDelivery{
+		ID:        fmt.Sprintf("%x", securecookie.GenerateRandomKey(8)),
+		WebhookID: h.ID,
+		URL:       h.URL,
+		Event:     event,
+		Created:   time.Now(),
+		Pending:   true,
+	}
+	payload := &WebhookPayload{ID: d.ID, Event: event, App: h.App, Time: d.Created, Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Errorf("json.Marshal() failed with %s", err)
+		return nil
+	}
+	webhookLog.add(h.App, d)
+	go sendWebhook(h, d, body)
+	return d
+}
+
+func webhooksForEvent(app *App, event string) []*store.Webhook {
+	res := make([]*store.Webhook, 0)
+	if userStore == nil {
+		return res
+	}
+	for _, h := range userStore.Webhooks(app.Name) {
+		if h.HasEvent(event) {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// fireWebhooks sends event to all webhooks of app subscribed to it
+func fireWebhooks(app *App, event string, data interface{}) {
+	for _, h := range webhooksForEvent(app, event) {
+		deliverWebhook(h, event, data)
+	}
+}
+
+// langProgress returns percentage of translated strings in lang, the same
+// as langInfoProgress but without building infos of all languages
+func langProgress(app *App, lang string) int {
+	total := app.store.StringsCount()
+	return progressPercent(total-app.store.UntranslatedForLang(lang), total)
+}
+
+// translationChange fires webhooks about a change of a translation.
+// Create it before the change and call fire() after
+type translationChange struct {
+	app    *App
+	lang   string
+	hooks  []*store.Webhook
+	before int
+}
+
+func newTranslationChange(app *App, lang string) *translationChange {
+	c := &translationChange{app: app, lang: lang, hooks: webhooksForEvent(app, store.EventLangProgress)}
+	// calculating progress is not free so only do it when needed
+	if len(c.hooks) > 0 {
+		c.before = langProgress(app, lang)
+	}
+	return c
+}
+
+func (c *translationChange) fire(event, str, trans, user string) {
+	fireWebhooks(c.app, event, map[string]string{
+		"string":      str,
+		"lang":        c.lang,
+		"translation": trans,
+		"user":        user,
+	})
+	if len(c.hooks) == 0 {
+		return
+	}
+	after := langProgress(c.app, c.lang)
+	for _, h := range c.hooks {
+		if c.before < h.Progress && after >= h.Progress {
+			deliverWebhook(h, store.EventLangProgress, map[string]interface{}{
+				"lang":     c.lang,
+				"langName": store.LangNameByCode(c.lang),
+				"progress": after,
+			})
+		}
+	}
+}
+
+// ModelWebhooks describes /admin/webhooks page
+type ModelWebhooks struct {
+	PageTitle   string
+	User        string
+	RedirectUrl string
+	CsrfToken   string
+	App         *App
+	Message     string
+	Webhooks    []*store.Webhook
+	Events      []string
+	Deliveries  []*WebhookDelivery
+	// secret of a newly created webhook, shown only once
+	NewSecret string
+	NewURL    string
+}
+
+func buildModelWebhooks(app *App, user string) *ModelWebhooks {
+	return &ModelWebhooks{
+		PageTitle:  fmt.Sprintf("Webhooks of %s", app.Name),
+		User:       user,
+		App:        app,
+		Webhooks:   userStore.Webhooks(app.Name),
+		Events:     store.WebhookEvents,
+		Deliveries: webhookLog.recent(app.Name),
+	}
+}
+
+func serveWebhooksPage(w http.ResponseWriter, r *http.Request, model *ModelWebhooks) {
+	model.RedirectUrl = "/admin/webhooks?app=" + url.QueryEscape(model.App.Name)
+	model.CsrfToken = csrfToken(r)
+	ExecTemplate(w, tmplWebhooks, model)
+}
+
+func redirectToWebhooks(w http.ResponseWriter, r *http.Request, app *App, msg string) {
+	u := fmt.Sprintf("/admin/webhooks?app=%s&msg=%s", url.QueryEscape(app.Name), url.QueryEscape(msg))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// getWebhookArg returns webhook given by id argument, which must belong to app
+func getWebhookArg(w http.ResponseWriter, r *http.Request, app *App) *store.Webhook {
+	h := userStore.GetWebhook(r.FormValue("id"))
+	if h == nil || h.App != app.Name || h.Deleted {
+		httpErrorf(w, "Webhook doesn't exist")
+		return nil
+	}
+	return h
+}
+
+// url: /admin/webhooks?app=${app}&msg=${msg}
+func handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	model := buildModelWebhooks(app, user)
+	model.Message = r.FormValue("msg")
+	serveWebhooksPage(w, r, model)
+}
+
+// url: POST /admin/webhooks/create
+// app, url, event (can be repeated), progress
+func handleAdminWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	hookURL := strings.TrimSpace(r.FormValue("url"))
+	progress := 100
+	if s := strings.TrimSpace(r.FormValue("progress")); s != "" {
+		var err error
+		if progress, err = strconv.Atoi(s); err != nil {
+			httpErrorf(w, "Invalid progress %q", s)
+			return
+		}
+	}
+	if err := checkWebhookURL(hookURL); err != nil {
+		httpErrorf(w, "Invalid webhook url: %s", err)
+		return
+	}
+	events := r.Form["event"]
+	sort.Strings(events)
+	id := fmt.Sprintf("%x", securecookie.GenerateRandomKey(8))
+	h, err := userStore.CreateWebhook(id, app.Name, hookURL, events, newWebhookSecret(), progress, user)
+	if err != nil {
+		httpErrorf(w, "Failed to create webhook: %s", err)
+		return
+	}
+	logger.Noticef("%s created webhook %s (%s) for %s with events %v", user, h.ID, h.URL, app.Name, h.Events)
+	model := buildModelWebhooks(app, user)
+	model.NewSecret = h.Secret
+	model.NewURL = h.URL
+	serveWebhooksPage(w, r, model)
+}
+
+// url: POST /admin/webhooks/delete
+// app, id
+func handleAdminWebhooksDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	h := getWebhookArg(w, r, app)
+	if h == nil {
+		return
+	}
+	if err := userStore.DeleteWebhook(h.ID, user); err != nil {
+		httpErrorf(w, "Failed to delete webhook: %s", err)
+		return
+	}
+	logger.Noticef("%s deleted webhook %s (%s) of %s", user, h.ID, h.URL, app.Name)
+	redirectToWebhooks(w, r, app, fmt.Sprintf("Deleted webhook %s", h.URL))
+}
+
+// url: POST /admin/webhooks/test
+// app, id
+// Sends ping event to a webhook
+func handleAdminWebhooksTest(w http.ResponseWriter, r *http.Request) {
+	if !checkMutatingRequest(w, r) {
+		return
+	}
+	app, user := getAppAdminArg(w, r)
+	if app == nil {
+		return
+	}
+	h := getWebhookArg(w, r, app)
+	if h == nil {
+		return
+	}
+	deliverWebhook(h, eventPing, map[string]string{"webhook": h.ID, "user": user})
+	redirectToWebhooks(w, r, app, fmt.Sprintf("Sent %s event to %s, reload to see if it was delivered", eventPing, h.URL))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kjk/apptranslator/store"
+)
+
+// webhookReceiver is a local http server that records received webhooks.
+// It fails the first request to test retries
+type webhookReceiver struct {
+	sync.Mutex
+	srv      *httptest.Server
+	secret   string
+	requests int
+	payloads chan *WebhookPayload
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	rcv := &webhookReceiver{payloads: make(chan *WebhookPayload, 16)}
+	rcv.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcv.Lock()
+		rcv.requests++
+		n, secret := rcv.requests, rcv.secret
+		rcv.Unlock()
+		if n == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-AppTranslator-Signature") != webhookSignature(secret, body) {
+			t.Errorf("invalid signature of %s", body)
+		}
+		var p WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("invalid payload %s", body)
+		}
+		if r.Header.Get("X-AppTranslator-Event") != p.Event {
+			t.Errorf("event header doesn't match payload %s", body)
+		}
+		rcv.payloads <- &p
+	}))
+	return rcv
+}
+
+// waits for events and returns received payloads keyed by event
+func (rcv *webhookReceiver) expect(t *testing.T, events ...string) map[string]*WebhookPayload {
+	res := make(map[string]*WebhookPayload)
+	for len(res) < len(events) {
+		select {
+		case p := <-rcv.payloads:
+			res[p.Event] = p
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v, got %v", events, res)
+		}
+	}
+	for _, e := range events {
+		if res[e] == nil {
+			t.Fatalf("didn't get %s, got %v", e, res)
+		}
+	}
+	return res
+}
+
+// waits until deliveries of app are no longer pending and returns them
+func waitForDeliveries(t *testing.T, app string) []*WebhookDelivery {
+	for i := 0; i < 500; i++ {
+		deliveries := webhookLog.recent(app)
+		pending := false
+		for _, d := range deliveries {
+			pending = pending || d.Pending
+		}
+		if !pending {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("deliveries still pending")
+	return nil
+}
+
+func TestWebhooks(t *testing.T) {
+	defer setupTestUserStore(t, "webhookstest.dat")()
+	h := setupLoginTest(t, nil, nil)
+	app, cleanup := setupTestApp(t, "TestApp", "webhooksapptest.dat")
+	defer cleanup()
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	webhookLog.Lock()
+	delete(webhookLog.deliveries, "TestApp")
+	webhookLog.Unlock()
+	delay := webhookRetryDelay
+	webhookRetryDelay = 10 * time.Millisecond
+	webhookAllowLocal = true
+	defer func() {
+		webhookRetryDelay = delay
+		webhookAllowLocal = false
+	}()
+
+	rcv := newWebhookReceiver(t)
+	defer rcv.srv.Close()
+	events := []string{store.EventTranslationAdded, store.EventLangProgress}
+	hook, err := userStore.CreateWebhook("h1", "TestApp", rcv.srv.URL, events, "sec", 50, "kjk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.secret = hook.Secret
+
+	alice := loginTestUser(t, "alice", false)
+	body := `{"string": "foo", "translation": "fuu"}`
+	if rr := apiRequest(h, "POST", "/api/v1/apps/TestApp/langs/pl/translations", body, alice); rr.Code != 200 {
+		t.Fatalf("submitting translation returned %d, %s", rr.Code, rr.Body.String())
+	}
+	got := rcv.expect(t, events...)
+	data := got[store.EventTranslationAdded].Data.(map[string]interface{})
+	if data["string"] != "foo" || data["translation"] != "fuu" || data["user"] != "alice" {
+		t.Fatalf("unexpected payload %#v", data)
+	}
+	if data = got[store.EventLangProgress].Data.(map[string]interface{}); data["progress"] != 50.0 {
+		t.Fatalf("unexpected payload %#v", data)
+	}
+
+	// the first request failed, so one of the deliveries was retried
+	deliveries := waitForDeliveries(t, "TestApp")
+	retried := false
+	for _, d := range deliveries {
+		if !d.Delivered {
+			t.Fatalf("delivery not delivered %#v", d)
+		}
+		retried = retried || d.Attempts == 2
+	}
+	if len(deliveries) != 2 || !retried {
+		t.Fatalf("unexpected deliveries %#v", deliveries)
+	}
+
+	// test endpoint is only for admins of the app
+	vals := url.Values{"app": {"TestApp"}, "id": {hook.ID}}
+	testHook := func(cookies []*http.Cookie) int {
+		vals.Set("csrf", csrfFromCookies(cookies))
+		req := httptest.NewRequest("POST", "/admin/webhooks/test", strings.NewReader(vals.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := testHook(alice); code == http.StatusFound {
+		t.Fatalf("non-admin shouldn't be able to test webhooks")
+	}
+	if code := testHook(loginTestUser(t, "kjk", false)); code != http.StatusFound {
+		t.Fatalf("testing webhook returned %d", code)
+	}
+	rcv.expect(t, eventPing)
+}
+
+func TestWebhookInternalAddressesRefused(t *testing.T) {
+	refused := []string{
+		"http://127.0.0.1/", "http://localhost:8080/", "http://10.1.2.3/",
+		"http://172.16.0.1/", "http://192.168.1.1/", "http://169.254.169.254/latest/meta-data/",
+		"http://0.0.0.0/", "http://[::1]/", "http://[fd00::1]/", "http://[::ffff:127.0.0.1]/",
+	}
+	for _, s := range refused {
+		if checkWebhookURL(s) == nil {
+			t.Errorf("webhook url %s should be refused", s)
+		}
+	}
+	for _, s := range []string{"https://example.com/hook", "http://8.8.8.8/", "http://172.32.0.1/"} {
+		if err := checkWebhookURL(s); err != nil {
+			t.Errorf("webhook url %s should be allowed, got %s", s, err)
+		}
+	}
+
+	// host names can resolve to internal addresses, so it's also checked
+	// when connecting
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("webhook shouldn't be delivered to local server")
+	}))
+	defer srv.Close()
+	u := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	h := &store.Webhook{URL: u, Secret: "sec"}
+	d := &WebhookDelivery{ID: "d1", Event: eventPing}
+	if _, err := postWebhook(h, d, []byte("{}")); err == nil || !strings.Contains(err.Error(), "can't be delivered") {
+		t.Fatalf("delivery to %s should fail, err: %v", u, err)
+	}
+}
+
+func TestWebhookRedirectsNotFollowed(t *testing.T) {
+	webhookAllowLocal = true
+	defer func() { webhookAllowLocal = false }()
+	followed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	h := &store.Webhook{URL: srv.URL + "/hook", Secret: "sec"}
+	d := &WebhookDelivery{ID: "d1", Event: eventPing}
+	status, err := postWebhook(h, d, []byte("{}"))
+	if err == nil || status != http.StatusTemporaryRedirect || followed {
+		t.Fatalf("redirect shouldn't be followed, status: %d, err: %v", status, err)
+	}
+}
+
+func TestLangProgress(t *testing.T) {
+	app, cleanup := setupTestApp(t, "TestApp", "langprogresstest.dat")
+	defer cleanup()
+	check := func(exp int) {
+		li := findLangInfo(app.store.LangInfos(), "pl")
+		if got := langProgress(app, "pl"); got != exp || got != langInfoProgress(li) {
+			t.Fatalf("langProgress() is %d, langInfoProgress() is %d, expected %d", got, langInfoProgress(li), exp)
+		}
+	}
+	// nothing to translate is the same as everything translated
+	check(100)
+	if _, _, _, err := app.store.UpdateStringsList([]string{"foo", "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	check(0)
+	if err := app.store.WriteNewTranslation("foo", "fu", "pl", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	check(50)
+}